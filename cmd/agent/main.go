@@ -52,7 +52,8 @@ func main() {
 		"version", agentVersion,
 		"agent_id", config.Agent.ID,
 		"agent_name", config.Agent.Name,
-		"controller", fmt.Sprintf("%s:%d", config.Controller.Host, config.Controller.Port),
+		"controllers", config.Controller.ControllerHosts(),
+		"controller_port", config.Controller.Port,
 	)
 
 	// Create agent client
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -57,12 +58,42 @@ func main() {
 		"agents", len(config.Agents),
 		"target_bandwidth_gbps", config.Bandwidth.TargetGbps,
 	)
+	for _, pool := range config.Pools {
+		log.Infow("Bandwidth pool configured",
+			"pool", pool.Name,
+			"agents", len(pool.Agents),
+			"target_bandwidth_gbps", pool.Bandwidth.TargetGbps,
+		)
+	}
+
+	// State store for crash recovery, opened by whichever instance leads
+	var store *controller.Store
+	if !config.Persistence.Disabled {
+		store = controller.NewStore(config.Persistence.Path)
+		defer store.Close()
+		log.Infow("State persistence enabled", "path", config.Persistence.Path)
+	}
+
+	// Leader election for active/standby deployments
+	var elector *controller.Elector
+	if config.HA.Enabled {
+		lease, err := controller.NewFileLease(config.HA.LeasePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up leadership lease: %v\n", err)
+			os.Exit(1)
+		}
+		elector = controller.NewElector(lease, config.HA.InstanceID, config.HA.LeaseTTL, config.HA.RenewInterval, log)
+		log.Infow("High availability enabled",
+			"instance_id", config.HA.InstanceID,
+			"lease_path", config.HA.LeasePath,
+		)
+	}
 
 	// Create server
-	server := controller.NewServer(config, log)
+	server := controller.NewServer(config, store, elector, log)
 
 	// Create API server
-	apiServer := controller.NewAPIServer(config, server, server.GetScheduler(), server.GetMetrics(), log)
+	apiServer := controller.NewAPIServer(config, server, server.GetMetrics(), log)
 
 	// Setup context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -80,9 +111,12 @@ func main() {
 
 	// Start servers
 	errChan := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
 
-	// Start WebSocket server
+	// Start WebSocket server. It returns once agents have been drained.
 	go func() {
+		defer wg.Done()
 		if err := server.Start(ctx); err != nil {
 			errChan <- fmt.Errorf("WebSocket server error: %w", err)
 		}
@@ -90,6 +124,7 @@ func main() {
 
 	// Start API server
 	go func() {
+		defer wg.Done()
 		if err := apiServer.Start(ctx); err != nil {
 			errChan <- fmt.Errorf("API server error: %w", err)
 		}
@@ -109,12 +144,12 @@ func main() {
 	case err := <-errChan:
 		log.Errorw("Server error", "error", err)
 		cancel()
-		time.Sleep(2 * time.Second) // Give time for graceful shutdown
+		wg.Wait()
 		os.Exit(1)
 
 	case <-ctx.Done():
-		log.Info("Shutting down controller")
-		time.Sleep(2 * time.Second) // Give time for graceful shutdown
+		log.Info("Shutting down controller, draining agents")
+		wg.Wait()
 	}
 
 	log.Info("Controller shutdown complete")
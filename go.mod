@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/google/uuid v1.5.0
 	github.com/gorilla/websocket v1.5.1
+	go.etcd.io/bbolt v1.3.8
 	go.uber.org/zap v1.26.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -12,4 +13,5 @@ require (
 require (
 	go.uber.org/multierr v1.10.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
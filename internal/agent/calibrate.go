@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
+)
+
+const (
+	// Longest probe the agent runs, whatever the controller asks for
+	maxCalibrationDuration = 2 * time.Minute
+	// Streams used if the controller does not say
+	defaultCalibrationStreams = 8
+)
+
+// Calibrator measures the agent's sustained download capacity by running
+// parallel unthrottled downloads for a bounded time
+type Calibrator struct {
+	logger  *logger.Logger
+	client  *http.Client
+	running atomic.Bool
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	last   protocol.CalibrationResult // Last successful probe
+	lastAt time.Time
+}
+
+// NewCalibrator creates a calibrator
+func NewCalibrator(log *logger.Logger) *Calibrator {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	transport.MaxIdleConnsPerHost = 64
+
+	return &Calibrator{
+		logger: log,
+		client: &http.Client{Transport: transport},
+	}
+}
+
+// Run probes the download capacity as instructed. Only one probe runs at a time.
+func (c *Calibrator) Run(cmd *protocol.CalibrateCommand) protocol.CalibrationResult {
+	result := protocol.CalibrationResult{
+		CalibrationID: cmd.CalibrationID,
+		Streams:       cmd.Streams,
+	}
+	if result.Streams <= 0 {
+		result.Streams = defaultCalibrationStreams
+	}
+
+	duration, err := time.ParseDuration(cmd.Duration)
+	if err != nil || duration <= 0 {
+		result.Message = fmt.Sprintf("invalid duration %q", cmd.Duration)
+		return result
+	}
+	if duration > maxCalibrationDuration {
+		duration = maxCalibrationDuration
+	}
+	var warmup time.Duration
+	if cmd.Warmup != "" {
+		if warmup, err = time.ParseDuration(cmd.Warmup); err != nil || warmup >= duration {
+			result.Message = fmt.Sprintf("invalid warmup %q", cmd.Warmup)
+			return result
+		}
+	}
+	if len(cmd.URLs) == 0 {
+		result.Message = "no probe URLs"
+		return result
+	}
+
+	if !c.running.CompareAndSwap(false, true) {
+		result.Message = "calibration already running"
+		return result
+	}
+	defer c.running.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+	defer func() {
+		cancel()
+		c.mu.Lock()
+		c.cancel = nil
+		c.mu.Unlock()
+	}()
+
+	c.logger.Infow("Starting capacity calibration",
+		"calibration_id", cmd.CalibrationID,
+		"streams", result.Streams,
+		"duration", duration,
+		"warmup", warmup,
+	)
+
+	samples := c.probe(ctx, cmd.URLs, result.Streams, warmup)
+	result.Samples = len(samples)
+	if len(samples) < 3 {
+		result.Message = fmt.Sprintf("too few samples (%d)", len(samples))
+		return result
+	}
+
+	sort.Float64s(samples)
+	result.SustainedMbps = samples[len(samples)/2]
+	result.PeakMbps = samples[len(samples)-1]
+	if result.SustainedMbps <= 0 {
+		result.Message = "no throughput measured"
+		return result
+	}
+	result.Success = true
+
+	c.mu.Lock()
+	c.last = result
+	c.lastAt = time.Now()
+	c.mu.Unlock()
+
+	c.logger.Infow("Capacity calibration finished",
+		"calibration_id", cmd.CalibrationID,
+		"sustained_mbps", result.SustainedMbps,
+		"peak_mbps", result.PeakMbps,
+		"samples", result.Samples,
+	)
+	return result
+}
+
+// probe runs the streams until ctx is done and returns the per-second
+// throughput samples taken after the warm-up, in Mbps
+func (c *Calibrator) probe(ctx context.Context, urls []string, streams int, warmup time.Duration) []float64 {
+	var received atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			c.stream(ctx, url, &received)
+		}(urls[i%len(urls)])
+	}
+
+	var samples []float64
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last, lastAt := int64(0), start
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return samples
+		case now := <-ticker.C:
+			total := received.Load()
+			if now.Sub(start) > warmup {
+				mbps := float64(total-last) * 8 / 1e6 / now.Sub(lastAt).Seconds()
+				samples = append(samples, mbps)
+			}
+			last, lastAt = total, now
+		}
+	}
+}
+
+// stream downloads url repeatedly, unthrottled, until ctx is done
+func (c *Calibrator) stream(ctx context.Context, url string, received *atomic.Int64) {
+	buf := make([]byte, 256*1024)
+	for ctx.Err() == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			c.logger.Debugw("Calibration stream request failed", "url", url, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for {
+			n, err := resp.Body.Read(buf)
+			received.Add(int64(n))
+			if err != nil {
+				break
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+// Stop aborts a probe in progress
+func (c *Calibrator) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Capacity returns the sustained throughput of the last successful probe
+// in Mbps, and when it was measured. It returns 0 if never calibrated.
+func (c *Calibrator) Capacity() (int64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(c.last.SustainedMbps), c.lastAt
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
 	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
@@ -23,15 +24,19 @@ type Client struct {
 	config         *Config
 	conn           *websocket.Conn
 	executor       *Executor
+	calibrator     *Calibrator
 	metrics        *MetricsCollector
 	logger         *logger.Logger
 	reconnectChan  chan struct{}
 	shutdownChan   chan struct{}
+	shutdownOnce   sync.Once
 	sendChan       chan *protocol.Message
 	startTime      time.Time
 	mu             sync.Mutex
 	connected      bool
 	metricsCancel  context.CancelFunc
+	hostIndex      int    // Controller host currently in use
+	registerNonce  string // Sent with the current connection's registration
 }
 
 // NewClient creates a new agent client
@@ -49,6 +54,7 @@ func NewClient(config *Config, log *logger.Logger) *Client {
 	}
 
 	client.executor = NewExecutor(config, metricsCollector, log)
+	client.calibrator = NewCalibrator(log)
 
 	return client
 }
@@ -76,7 +82,12 @@ func (c *Client) Run(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("Shutting down agent client")
-			c.disconnect()
+			c.shutdown()
+			return nil
+
+		case <-c.shutdownChan:
+			c.logger.Info("Shutting down agent client at controller request")
+			c.shutdown()
 			return nil
 
 		case <-c.reconnectChan:
@@ -85,14 +96,39 @@ func (c *Client) Run(ctx context.Context) error {
 	}
 }
 
+// shutdown stops all downloads and closes the connection
+func (c *Client) shutdown() {
+	c.calibrator.Stop()
+
+	if c.executor.GetActiveJobs() > 0 {
+		c.executor.Stop("")
+		if !c.executor.WaitStopped("", 10*time.Second) {
+			c.logger.Warn("Timed out waiting for downloads to stop")
+		}
+	}
+
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "agent shutting down"),
+			time.Now().Add(time.Second))
+	}
+	c.mu.Unlock()
+
+	c.disconnect()
+}
+
 // connect establishes WebSocket connection to controller
 func (c *Client) connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	hosts := c.config.Controller.ControllerHosts()
+	host := hosts[c.hostIndex%len(hosts)]
+
 	wsURL := url.URL{
 		Scheme: "ws",
-		Host:   fmt.Sprintf("%s:%d", c.config.Controller.Host, c.config.Controller.Port),
+		Host:   fmt.Sprintf("%s:%d", host, c.config.Controller.Port),
 		Path:   "/ws",
 	}
 
@@ -103,6 +139,8 @@ func (c *Client) connect() error {
 
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL.String(), header)
 	if err != nil {
+		// Try the next controller next time; a standby refuses the upgrade
+		c.hostIndex++
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
@@ -184,9 +222,16 @@ func (c *Client) readMessages() {
 		var msg protocol.Message
 		err := c.conn.ReadJSON(&msg)
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseTryAgainLater) {
 				c.logger.Errorw("WebSocket read error", "error", err)
 			}
+			// A controller that closes the connection is shutting down or
+			// standing by; try the next one
+			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseTryAgainLater) {
+				c.mu.Lock()
+				c.hostIndex++
+				c.mu.Unlock()
+			}
 			return
 		}
 
@@ -247,6 +292,30 @@ func (c *Client) processMessage(msg *protocol.Message) {
 		}
 		c.handleStopCommand(&cmd)
 
+	case protocol.MsgTypeAdjustCommand:
+		var cmd protocol.AdjustCommand
+		if err := msg.UnmarshalPayload(&cmd); err != nil {
+			c.logger.Errorw("Failed to unmarshal adjust command", "error", err)
+			return
+		}
+		c.handleAdjustCommand(&cmd)
+
+	case protocol.MsgTypeCalibrateCommand:
+		var cmd protocol.CalibrateCommand
+		if err := msg.UnmarshalPayload(&cmd); err != nil {
+			c.logger.Errorw("Failed to unmarshal calibrate command", "error", err)
+			return
+		}
+		c.handleCalibrateCommand(&cmd)
+
+	case protocol.MsgTypeRegisterAck:
+		var ack protocol.RegisterAck
+		if err := msg.UnmarshalPayload(&ack); err != nil {
+			c.logger.Errorw("Failed to unmarshal register ack", "error", err)
+			return
+		}
+		c.handleRegisterAck(&ack)
+
 	case protocol.MsgTypeHealthCheck:
 		var hc protocol.HealthCheck
 		if err := msg.UnmarshalPayload(&hc); err != nil {
@@ -255,9 +324,14 @@ func (c *Client) processMessage(msg *protocol.Message) {
 		}
 		c.handleHealthCheck(&hc)
 
+	case protocol.MsgTypeStatusRequest:
+		c.handleStatusRequest()
+
 	case protocol.MsgTypeShutdown:
 		c.logger.Info("Received shutdown command")
-		close(c.shutdownChan)
+		c.shutdownOnce.Do(func() {
+			close(c.shutdownChan)
+		})
 
 	default:
 		c.logger.Warnw("Unknown message type", "type", msg.Type)
@@ -278,13 +352,122 @@ func (c *Client) handleDownloadCommand(cmd *protocol.DownloadCommand) {
 	}
 }
 
+// handleAdjustCommand handles a bandwidth adjustment for a running download
+func (c *Client) handleAdjustCommand(cmd *protocol.AdjustCommand) {
+	c.logger.Infow("Received adjust command",
+		"command_id", cmd.CommandID,
+		"bandwidth", cmd.Bandwidth,
+	)
+
+	if err := c.executor.Adjust(cmd.CommandID, cmd.Bandwidth); err != nil {
+		c.logger.Warnw("Failed to adjust command", "error", err, "command_id", cmd.CommandID)
+	}
+}
+
 // handleStopCommand handles a stop command
 func (c *Client) handleStopCommand(cmd *protocol.StopCommand) {
-	c.logger.Infow("Received stop command", "command_id", cmd.CommandID)
+	c.logger.Infow("Received stop command", "command_id", cmd.CommandID, "emergency", cmd.Emergency)
+
+	if cmd.Emergency {
+		c.calibrator.Stop()
+	}
 
-	if err := c.executor.Stop(cmd.CommandID); err != nil {
+	err := c.executor.Stop(cmd.CommandID)
+	if err != nil {
 		c.logger.Errorw("Failed to stop command", "error", err)
 	}
+
+	if cmd.RequestID != "" {
+		c.acknowledgeStop(cmd.RequestID, cmd.CommandID, err)
+	}
+}
+
+// handleRegisterAck stops everything if the controller's emergency stop is
+// engaged. The flag is only honoured if it is signed with our auth token and
+// answers this connection's registration.
+func (c *Client) handleRegisterAck(ack *protocol.RegisterAck) {
+	if !ack.EmergencyStop {
+		return
+	}
+	if !ack.Verify(c.config.Agent.ID, c.config.Controller.AuthToken) {
+		c.logger.Warn("Ignoring emergency stop with an invalid signature")
+		return
+	}
+	c.mu.Lock()
+	current := ack.Nonce == c.registerNonce
+	c.mu.Unlock()
+	if !current {
+		c.logger.Warn("Ignoring emergency stop answering an earlier registration")
+		return
+	}
+
+	c.logger.Warnw("Controller emergency stop engaged, stopping all downloads",
+		"issued_at", ack.IssuedAt,
+		"active_jobs", c.executor.GetActiveJobs(),
+	)
+
+	c.calibrator.Stop()
+	err := c.executor.Stop("")
+	if err != nil {
+		c.logger.Errorw("Failed to stop downloads", "error", err)
+	}
+
+	if ack.RequestID != "" {
+		c.acknowledgeStop(ack.RequestID, "", err)
+	}
+}
+
+// acknowledgeStop reports to the controller once the stopped downloads have
+// actually exited
+func (c *Client) acknowledgeStop(requestID, commandID string, stopErr error) {
+	go func() {
+		ack := protocol.CommandAck{
+			RequestID: requestID,
+			CommandID: commandID,
+			Success:   true,
+		}
+		if stopErr != nil {
+			ack.Success = false
+			ack.Message = stopErr.Error()
+		} else if !c.executor.WaitStopped(commandID, 10*time.Second) {
+			ack.Success = false
+			ack.Message = "timed out waiting for downloads to stop"
+		}
+
+		msg, err := protocol.NewMessage(protocol.MsgTypeCommandAck, c.config.Agent.ID, ack)
+		if err != nil {
+			c.logger.Errorw("Failed to create command ack", "error", err)
+			return
+		}
+
+		c.sendChan <- msg
+	}()
+}
+
+// handleCalibrateCommand runs a capacity probe and reports the result.
+// Running downloads would skew the measurement, so a busy agent refuses.
+func (c *Client) handleCalibrateCommand(cmd *protocol.CalibrateCommand) {
+	c.logger.Infow("Received calibrate command", "calibration_id", cmd.CalibrationID)
+
+	go func() {
+		var result protocol.CalibrationResult
+		if c.executor.GetActiveJobs() > 0 {
+			result = protocol.CalibrationResult{
+				CalibrationID: cmd.CalibrationID,
+				Message:       "agent is busy with downloads",
+			}
+		} else {
+			result = c.calibrator.Run(cmd)
+		}
+
+		msg, err := protocol.NewMessage(protocol.MsgTypeCalibrationResult, c.config.Agent.ID, result)
+		if err != nil {
+			c.logger.Errorw("Failed to create calibration result", "error", err)
+			return
+		}
+
+		c.sendChan <- msg
+	}()
 }
 
 // handleHealthCheck handles a health check
@@ -304,8 +487,22 @@ func (c *Client) handleHealthCheck(hc *protocol.HealthCheck) {
 	c.sendChan <- msg
 }
 
-// sendRegistration sends registration to controller
+// handleStatusRequest reports current status, including running jobs
+func (c *Client) handleStatusRequest() {
+	msg, err := protocol.NewMessage(protocol.MsgTypeStatus, c.config.Agent.ID, c.GetStatus())
+	if err != nil {
+		c.logger.Errorw("Failed to create status message", "error", err)
+		return
+	}
+
+	c.sendChan <- msg
+}
+
+// sendRegistration sends registration to controller, with a fresh nonce
+// for the register ack to answer. Must be called with c.mu held.
 func (c *Client) sendRegistration() error {
+	capacity, calibratedAt := c.calibrator.Capacity()
+
 	payload := protocol.RegisterPayload{
 		AgentID: c.config.Agent.ID,
 		Name:    c.config.Agent.Name,
@@ -314,12 +511,17 @@ func (c *Client) sendRegistration() error {
 			"wget":   true,
 			"yt-dlp": c.checkYtDlpAvailability(),
 		},
-		MaxBandwidth: 0, // Will be configured on controller side
+		MaxBandwidth: capacity, // 0 until calibrated; the controller falls back to its config
+		CalibratedAt: calibratedAt,
+		ActiveJobs:   c.executor.GetActiveJobList(),
+		Nonce:        uuid.New().String(),
 	}
+	c.registerNonce = payload.Nonce
 
 	c.logger.Infow("Registering agent with capabilities",
 		"agent_id", c.config.Agent.ID,
 		"capabilities", payload.Capabilities,
+		"active_jobs", len(payload.ActiveJobs),
 	)
 
 	msg, err := protocol.NewMessage(protocol.MsgTypeRegister, c.config.Agent.ID, payload)
@@ -419,5 +621,6 @@ func (c *Client) GetStatus() *protocol.StatusPayload {
 		State:          state,
 		ActiveCommands: activeCommands,
 		UptimeSeconds:  int64(time.Since(c.startTime).Seconds()),
+		Jobs:           c.executor.GetActiveJobList(),
 	}
 }
@@ -26,6 +26,7 @@ type AgentConfig struct {
 // ControllerConfig contains controller connection settings
 type ControllerConfig struct {
 	Host                 string        `yaml:"host"`
+	Hosts                []string      `yaml:"hosts"` // Additional controllers tried in turn (HA deployments)
 	Port                 int           `yaml:"port"`
 	AuthToken            string        `yaml:"auth_token"`
 	ReconnectInterval    time.Duration `yaml:"reconnect_interval"`
@@ -53,6 +54,15 @@ type LoggingConfig struct {
 	Output string `yaml:"output"`
 }
 
+// ControllerHosts returns all controller hosts in the order they are tried
+func (c *ControllerConfig) ControllerHosts() []string {
+	var hosts []string
+	if c.Host != "" {
+		hosts = append(hosts, c.Host)
+	}
+	return append(hosts, c.Hosts...)
+}
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -102,7 +112,7 @@ func (c *Config) Validate() error {
 	if c.Agent.ID == "" {
 		return fmt.Errorf("agent.id is required")
 	}
-	if c.Controller.Host == "" {
+	if c.Controller.Host == "" && len(c.Controller.Hosts) == 0 {
 		return fmt.Errorf("controller.host is required")
 	}
 	if c.Controller.AuthToken == "" {
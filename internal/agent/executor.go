@@ -38,6 +38,8 @@ type Job struct {
 	threads          []*downloadThread
 	mu               sync.Mutex
 	DownloadType     protocol.DownloadType
+	Bandwidth        atomic.Int64 // Mbps, may be adjusted while running
+	Deadline         time.Time    // Planned end from the command duration, zero if none
 }
 
 // downloadThread represents a single download thread
@@ -80,6 +82,11 @@ func (e *Executor) ExecuteDownload(cmd *protocol.DownloadCommand) error {
 		DownloadType: downloadType,
 	}
 	job.CurrentSpeedMbps.Store(0.0)
+	job.Bandwidth.Store(cmd.Bandwidth)
+
+	if duration, err := time.ParseDuration(cmd.Duration); err == nil && duration > 0 {
+		job.Deadline = job.StartTime.Add(duration)
+	}
 
 	e.activeJobs.Store(cmd.CommandID, job)
 
@@ -132,6 +139,45 @@ func (e *Executor) Stop(commandID string) error {
 	return fmt.Errorf("command %s not found", commandID)
 }
 
+// Adjust changes the bandwidth limit of a running command. Download tools
+// cannot change their rate limit while running, so the current processes
+// are restarted and pick up the new limit.
+func (e *Executor) Adjust(commandID string, bandwidth int64) error {
+	jobVal, exists := e.activeJobs.Load(commandID)
+	if !exists {
+		return fmt.Errorf("command %s not found", commandID)
+	}
+	if bandwidth < 1 {
+		return fmt.Errorf("invalid bandwidth %d", bandwidth)
+	}
+
+	job := jobVal.(*Job)
+	if job.Bandwidth.Swap(bandwidth) == bandwidth {
+		return nil
+	}
+
+	job.mu.Lock()
+	for _, thread := range job.threads {
+		thread.cancel()
+	}
+	job.mu.Unlock()
+
+	e.logger.Infow("Adjusted download bandwidth",
+		"command_id", commandID,
+		"bandwidth", bandwidth,
+	)
+	return nil
+}
+
+// threadBandwidth returns the current per-thread bandwidth of a job in Mbps
+func threadBandwidth(job *Job) int64 {
+	perThread := job.Bandwidth.Load() / DefaultConcurrentDownloads
+	if perThread < 1 {
+		perThread = 1
+	}
+	return perThread
+}
+
 // stopJob stops all threads in a job
 func (e *Executor) stopJob(job *Job) {
 	job.Cancel()
@@ -145,6 +191,29 @@ func (e *Executor) stopJob(job *Job) {
 	job.mu.Unlock()
 }
 
+// WaitStopped waits until the given command, or every command if commandID
+// is empty, has finished. It returns false if the timeout expires first.
+func (e *Executor) WaitStopped(commandID string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		running := false
+		if commandID == "" {
+			running = e.GetActiveJobs() > 0
+		} else {
+			_, running = e.activeJobs.Load(commandID)
+		}
+
+		if !running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // GetActiveJobs returns the number of active jobs
 func (e *Executor) GetActiveJobs() int {
 	count := 0
@@ -155,6 +224,34 @@ func (e *Executor) GetActiveJobs() int {
 	return count
 }
 
+// GetActiveJobList describes all running jobs so a controller can adopt them
+func (e *Executor) GetActiveJobList() []protocol.ActiveJob {
+	var jobs []protocol.ActiveJob
+
+	e.activeJobs.Range(func(key, value interface{}) bool {
+		job := value.(*Job)
+
+		activeJob := protocol.ActiveJob{
+			CommandID: job.CommandID,
+			URL:       job.URL,
+			Type:      job.DownloadType,
+			Bandwidth: job.Bandwidth.Load(),
+		}
+		if !job.Deadline.IsZero() {
+			remaining := time.Until(job.Deadline)
+			if remaining < 0 {
+				remaining = 0
+			}
+			activeJob.RemainingDuration = remaining.Round(time.Second).String()
+		}
+
+		jobs = append(jobs, activeJob)
+		return true
+	})
+
+	return jobs
+}
+
 // GetJobMetrics returns metrics for all active jobs
 func (e *Executor) GetJobMetrics() []protocol.CommandMetrics {
 	var metrics []protocol.CommandMetrics
@@ -194,23 +291,17 @@ func (e *Executor) runMultiThreadDownload(ctx context.Context, cmd *protocol.Dow
 		}
 	}
 
-	// Calculate bandwidth per thread
-	bandwidthPerThread := cmd.Bandwidth / DefaultConcurrentDownloads
-	if bandwidthPerThread < 1 {
-		bandwidthPerThread = 1
-	}
-
 	e.logger.Infow("Starting download threads",
 		"command_id", cmd.CommandID,
 		"total_bandwidth", cmd.Bandwidth,
-		"bandwidth_per_thread", bandwidthPerThread,
+		"bandwidth_per_thread", threadBandwidth(job),
 		"threads", DefaultConcurrentDownloads,
 	)
 
 	// Start download threads
 	for i := 0; i < DefaultConcurrentDownloads; i++ {
 		job.wg.Add(1)
-		go e.downloadThread(ctx, cmd, job, i, bandwidthPerThread)
+		go e.downloadThread(ctx, cmd, job, i)
 	}
 
 	// Wait for all threads to complete (they will run until cancelled)
@@ -223,11 +314,9 @@ func (e *Executor) runMultiThreadDownload(ctx context.Context, cmd *protocol.Dow
 }
 
 // downloadThread runs a single download thread that loops continuously
-func (e *Executor) downloadThread(ctx context.Context, cmd *protocol.DownloadCommand, job *Job, threadID int, bandwidthMbps int64) {
+func (e *Executor) downloadThread(ctx context.Context, cmd *protocol.DownloadCommand, job *Job, threadID int) {
 	defer job.wg.Done()
 
-	limitRate := fmt.Sprintf("%dM", bandwidthMbps)
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -239,8 +328,9 @@ func (e *Executor) downloadThread(ctx context.Context, cmd *protocol.DownloadCom
 		default:
 		}
 
-		// Create a new wget command for this iteration
+		// Create a new wget command for this iteration, at the current limit
 		threadCtx, threadCancel := context.WithCancel(ctx)
+		limitRate := fmt.Sprintf("%dM", threadBandwidth(job))
 
 		wgetCmd := exec.CommandContext(threadCtx, "wget",
 			"--limit-rate", limitRate,
@@ -332,24 +422,18 @@ func (e *Executor) runYtDlpDownload(ctx context.Context, cmd *protocol.DownloadC
 		}
 	}
 
-	// Calculate bandwidth per thread (use same threading as wget)
-	bandwidthPerThread := cmd.Bandwidth / DefaultConcurrentDownloads
-	if bandwidthPerThread < 1 {
-		bandwidthPerThread = 1
-	}
-
 	e.logger.Infow("Starting yt-dlp download threads",
 		"command_id", cmd.CommandID,
 		"url", cmd.URL,
 		"total_bandwidth", cmd.Bandwidth,
-		"bandwidth_per_thread", bandwidthPerThread,
+		"bandwidth_per_thread", threadBandwidth(job),
 		"threads", DefaultConcurrentDownloads,
 	)
 
-	// Start multiple yt-dlp threads
+	// Start multiple yt-dlp threads (same threading as wget)
 	for i := 0; i < DefaultConcurrentDownloads; i++ {
 		job.wg.Add(1)
-		go e.ytdlpThread(ctx, cmd, job, i, bandwidthPerThread)
+		go e.ytdlpThread(ctx, cmd, job, i)
 	}
 
 	// Wait for all threads to complete
@@ -362,17 +446,9 @@ func (e *Executor) runYtDlpDownload(ctx context.Context, cmd *protocol.DownloadC
 }
 
 // ytdlpThread runs a single yt-dlp download thread that loops continuously
-func (e *Executor) ytdlpThread(ctx context.Context, cmd *protocol.DownloadCommand, job *Job, threadID int, bandwidthMbps int64) {
+func (e *Executor) ytdlpThread(ctx context.Context, cmd *protocol.DownloadCommand, job *Job, threadID int) {
 	defer job.wg.Done()
 
-	// Calculate limit rate for yt-dlp
-	// yt-dlp uses bytes/s with K/M suffix, we convert Mbps to MB/s (approximate)
-	// Mbps / 8 = MB/s, but yt-dlp M suffix means MiB, so we use a factor
-	limitRate := fmt.Sprintf("%dM", bandwidthMbps/8)
-	if bandwidthMbps < 8 {
-		limitRate = fmt.Sprintf("%dK", bandwidthMbps*125) // Mbps * 125 = KB/s
-	}
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -387,6 +463,15 @@ func (e *Executor) ytdlpThread(ctx context.Context, cmd *protocol.DownloadComman
 		// Create a new yt-dlp command for this iteration
 		threadCtx, threadCancel := context.WithCancel(ctx)
 
+		// Calculate limit rate for yt-dlp
+		// yt-dlp uses bytes/s with K/M suffix, we convert Mbps to MB/s (approximate)
+		// Mbps / 8 = MB/s, but yt-dlp M suffix means MiB, so we use a factor
+		bandwidthMbps := threadBandwidth(job)
+		limitRate := fmt.Sprintf("%dM", bandwidthMbps/8)
+		if bandwidthMbps < 8 {
+			limitRate = fmt.Sprintf("%dK", bandwidthMbps*125) // Mbps * 125 = KB/s
+		}
+
 		ytdlpCmd := exec.CommandContext(threadCtx, "yt-dlp",
 			// Output to /dev/null - don't save the file
 			"-o", "/dev/null",
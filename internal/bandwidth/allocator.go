@@ -0,0 +1,213 @@
+package bandwidth
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Limits are the floor and ceiling of an agent's allocation in Mbps
+type Limits struct {
+	Min int64
+	Max int64
+}
+
+// Allocation is the result of water-filling a target across agents
+type Allocation struct {
+	Bandwidth []int64 // Per agent, in the order of the limits
+	Target    int64
+	Total     int64
+	Feasible  bool
+	Shortfall int64 // Target above the sum of ceilings
+	Excess    int64 // Sum of floors above the target
+}
+
+// RandomWeights generates allocation weights between 0.5 and 1.5, varied by
+// a further ±randomness
+func RandomWeights(n int, randomness float64) []float64 {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = (0.5 + rand.Float64()) * (1 + randomness*(rand.Float64()*2-1))
+		if weights[i] <= 0 {
+			weights[i] = 0.01
+		}
+	}
+	return weights
+}
+
+// WaterFill splits target across agents in proportion to their weights
+// while keeping every agent within its limits. Bandwidth an agent cannot
+// take because of its ceiling or floor is redistributed to the others, so
+// the total meets the target exactly whenever the limits allow. Otherwise
+// every agent is held at its ceiling (or floor) and the result reports the
+// shortfall (or excess).
+func WaterFill(target int64, weights []float64, limits []Limits) Allocation {
+	n := len(limits)
+	result := Allocation{
+		Bandwidth: make([]int64, n),
+		Target:    target,
+	}
+	if n == 0 {
+		result.Shortfall = Max64(target, 0)
+		result.Feasible = result.Shortfall == 0
+		return result
+	}
+
+	var sumMin, sumMax int64
+	for _, l := range limits {
+		sumMin += l.Min
+		sumMax += Max64(l.Max, l.Min)
+	}
+
+	switch {
+	case target >= sumMax:
+		for i, l := range limits {
+			result.Bandwidth[i] = Max64(l.Max, l.Min)
+		}
+		result.Shortfall = target - sumMax
+	case target <= sumMin:
+		for i, l := range limits {
+			result.Bandwidth[i] = l.Min
+		}
+		result.Excess = sumMin - target
+	default:
+		fill(target, weights, limits, result.Bandwidth)
+	}
+
+	for _, bw := range result.Bandwidth {
+		result.Total += bw
+	}
+	result.Feasible = result.Shortfall == 0 && result.Excess == 0
+	return result
+}
+
+// fill finds the water level at which the clamped weighted shares add up
+// to target, then rounds the shares to whole Mbps. target must lie strictly
+// between the sums of floors and ceilings.
+func fill(target int64, weights []float64, limits []Limits, out []int64) {
+	n := len(limits)
+	weight := func(i int) float64 {
+		if i < len(weights) && weights[i] > 0 {
+			return weights[i]
+		}
+		return 1
+	}
+	share := func(i int, level float64) float64 {
+		l := limits[i]
+		return ClampFloat(level*weight(i), float64(l.Min), float64(Max64(l.Max, l.Min)))
+	}
+
+	// The total is continuous and non-decreasing in the level
+	low, high := 0.0, 0.0
+	for i, l := range limits {
+		high = math.Max(high, float64(Max64(l.Max, l.Min))/weight(i))
+	}
+	for iter := 0; iter < 100; iter++ {
+		level := (low + high) / 2
+		total := 0.0
+		for i := 0; i < n; i++ {
+			total += share(i, level)
+		}
+		if total < float64(target) {
+			low = level
+		} else {
+			high = level
+		}
+	}
+
+	// Round down, then hand the leftover Mbps to the largest remainders that
+	// still have room
+	shares := make([]float64, n)
+	var total int64
+	for i := 0; i < n; i++ {
+		shares[i] = share(i, high)
+		out[i] = int64(math.Floor(shares[i]))
+		total += out[i]
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return shares[order[a]]-math.Floor(shares[order[a]]) > shares[order[b]]-math.Floor(shares[order[b]])
+	})
+
+	for total != target {
+		moved := false
+		for _, i := range order {
+			if total == target {
+				break
+			}
+			ceiling := Max64(limits[i].Max, limits[i].Min)
+			if total < target && out[i] < ceiling {
+				out[i]++
+				total++
+				moved = true
+			} else if total > target && out[i] > limits[i].Min {
+				out[i]--
+				total--
+				moved = true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+}
+
+// WaterFillGroups water-fills target across groups of agents, keeping each
+// group within its own limits, then across the agents within each group.
+// groups[i] is the group of agent i. A group's limits are narrowed to what
+// its agents can take together; a group Max of math.MaxInt64 means no limit.
+// It also returns the total of each group.
+func WaterFillGroups(target int64, weights []float64, limits []Limits, groups []int, groupLimits []Limits) (Allocation, []int64) {
+	members := make([][]int, len(groupLimits))
+	for i, g := range groups {
+		members[g] = append(members[g], i)
+	}
+
+	groupWeights := make([]float64, len(groupLimits))
+	bounded := make([]Limits, len(groupLimits))
+	for g, agents := range members {
+		var sumMin, sumMax int64
+		for _, i := range agents {
+			sumMin += limits[i].Min
+			sumMax += Max64(limits[i].Max, limits[i].Min)
+			if i < len(weights) && weights[i] > 0 {
+				groupWeights[g] += weights[i]
+			} else {
+				groupWeights[g]++
+			}
+		}
+		bounded[g] = Limits{
+			Min: Clamp64(groupLimits[g].Min, sumMin, sumMax),
+			Max: Clamp64(groupLimits[g].Max, sumMin, sumMax),
+		}
+		if bounded[g].Max < bounded[g].Min {
+			bounded[g].Max = bounded[g].Min
+		}
+	}
+
+	top := WaterFill(target, groupWeights, bounded)
+
+	result := top
+	result.Bandwidth = make([]int64, len(limits))
+	for g, agents := range members {
+		agentWeights := make([]float64, len(agents))
+		agentLimits := make([]Limits, len(agents))
+		for j, i := range agents {
+			if i < len(weights) {
+				agentWeights[j] = weights[i]
+			}
+			agentLimits[j] = limits[i]
+		}
+
+		within := WaterFill(top.Bandwidth[g], agentWeights, agentLimits)
+		for j, i := range agents {
+			result.Bandwidth[i] = within.Bandwidth[j]
+		}
+	}
+
+	return result, top.Bandwidth
+}
@@ -0,0 +1,100 @@
+package bandwidth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWaterFill(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    int64
+		weights   []float64
+		limits    []Limits
+		want      []int64
+		shortfall int64
+		excess    int64
+	}{
+		{
+			name:    "split by weight",
+			target:  1200,
+			weights: []float64{1, 2},
+			limits:  []Limits{{0, 1000}, {0, 1000}},
+			want:    []int64{400, 800},
+		},
+		{
+			name:    "ceiling redistributed",
+			target:  1500,
+			weights: []float64{1, 1},
+			limits:  []Limits{{0, 500}, {0, 2000}},
+			want:    []int64{500, 1000},
+		},
+		{
+			name:    "floor redistributed",
+			target:  1000,
+			weights: []float64{1, 9},
+			limits:  []Limits{{400, 1000}, {0, 1000}},
+			want:    []int64{400, 600},
+		},
+		{
+			name:      "shortfall at ceilings",
+			target:    3000,
+			weights:   []float64{1, 1},
+			limits:    []Limits{{0, 1000}, {0, 1200}},
+			want:      []int64{1000, 1200},
+			shortfall: 800,
+		},
+		{
+			name:    "excess at floors",
+			target:  500,
+			weights: []float64{1, 1},
+			limits:  []Limits{{400, 1000}, {400, 1000}},
+			want:    []int64{400, 400},
+			excess:  300,
+		},
+		{
+			name:      "no agents",
+			target:    100,
+			want:      []int64{},
+			shortfall: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WaterFill(tt.target, tt.weights, tt.limits)
+			for i := range tt.want {
+				if got.Bandwidth[i] != tt.want[i] {
+					t.Fatalf("bandwidth = %v, want %v", got.Bandwidth, tt.want)
+				}
+			}
+			if got.Shortfall != tt.shortfall || got.Excess != tt.excess {
+				t.Fatalf("shortfall %d excess %d, want %d and %d", got.Shortfall, got.Excess, tt.shortfall, tt.excess)
+			}
+			if feasible := tt.shortfall == 0 && tt.excess == 0; got.Feasible != feasible {
+				t.Fatalf("feasible = %v, want %v", got.Feasible, feasible)
+			}
+			if got.Feasible && got.Total != tt.target {
+				t.Fatalf("total = %d, want %d", got.Total, tt.target)
+			}
+		})
+	}
+}
+
+func TestWaterFillGroups(t *testing.T) {
+	weights := []float64{1, 1, 1}
+	limits := []Limits{{0, 1000}, {0, 1000}, {0, 1000}}
+	groups := []int{0, 0, 1}
+	groupLimits := []Limits{{0, 600}, {0, math.MaxInt64}}
+
+	got, totals := WaterFillGroups(1500, weights, limits, groups, groupLimits)
+	if totals[0] != 600 || totals[1] != 900 {
+		t.Fatalf("group totals = %v, want [600 900]", totals)
+	}
+	want := []int64{300, 300, 900}
+	for i := range want {
+		if got.Bandwidth[i] != want[i] {
+			t.Fatalf("bandwidth = %v, want %v", got.Bandwidth, want)
+		}
+	}
+}
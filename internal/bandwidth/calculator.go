@@ -1,7 +1,6 @@
 package bandwidth
 
 import (
-	"math"
 	"math/rand"
 )
 
@@ -54,26 +53,10 @@ func Max64(a, b int64) int64 {
 	return b
 }
 
-// CalculateConcurrency calculates the number of concurrent servers using sine waves
+// CalculateConcurrency calculates the number of concurrent servers using the
+// default sine wave profile
 func CalculateConcurrency(elapsedSeconds float64, min, max int, randomness float64) int {
-	// Multiple overlapping sine waves for complex pattern
-	wave1 := math.Sin(elapsedSeconds / 300) // 5-minute cycle
-	wave2 := math.Sin(elapsedSeconds / 180) // 3-minute cycle
-	wave3 := math.Sin(elapsedSeconds / 420) // 7-minute cycle
-
-	combined := (wave1 + wave2*0.5 + wave3*0.3) / 1.8
-
-	// Map to concurrent range (0 to 1)
-	normalized := (combined + 1) / 2
-
-	// Calculate base concurrency
-	concurrent := min + int(float64(max-min)*normalized)
-
-	// Add randomness
-	randomAdjust := rand.Intn(3) - 1 // -1, 0, or 1
-	concurrent += randomAdjust
-
-	return Clamp(concurrent, min, max)
+	return Concurrency(&SineProfile{}, elapsedSeconds, min, max, randomness)
 }
 
 // WeightedRandomSelection selects N items from a list using weighted random selection
@@ -141,53 +124,19 @@ func WeightedRandomSelection(count int, weights []float64) []int {
 	return selected
 }
 
-// AllocateBandwidth allocates bandwidth across N agents with variance
+// AllocateBandwidth allocates bandwidth across N agents with variance, each
+// within the same limits. See WaterFill for agents with individual limits.
 func AllocateBandwidth(targetTotal float64, numAgents int, minPerAgent, maxPerAgent int64, randomnessFactor float64) []int64 {
 	if numAgents == 0 {
 		return []int64{}
 	}
 
-	allocations := make([]int64, numAgents)
-
-	// Generate random weights
-	weights := make([]float64, numAgents)
-	totalWeight := 0.0
-
-	for i := 0; i < numAgents; i++ {
-		// Base weight with randomness (0.5 to 1.5)
-		weight := 0.5 + rand.Float64()
-		weights[i] = weight
-		totalWeight += weight
-	}
-
-	// Allocate bandwidth proportionally
-	actualTotal := int64(0)
-	for i := 0; i < numAgents; i++ {
-		proportion := weights[i] / totalWeight
-		allocated := int64(proportion * targetTotal)
-
-		// Apply constraints
-		allocated = Clamp64(allocated, minPerAgent, maxPerAgent)
-
-		// Add small random variation
-		variation := float64(allocated) * randomnessFactor * (rand.Float64()*2 - 1)
-		allocated = int64(float64(allocated) + variation)
-		allocated = Clamp64(allocated, minPerAgent, maxPerAgent)
-
-		allocations[i] = allocated
-		actualTotal += allocated
-	}
-
-	// Normalize to hit target exactly
-	if actualTotal != int64(targetTotal) && actualTotal > 0 {
-		ratio := targetTotal / float64(actualTotal)
-		for i := 0; i < numAgents; i++ {
-			allocations[i] = int64(float64(allocations[i]) * ratio)
-			allocations[i] = Clamp64(allocations[i], minPerAgent, maxPerAgent)
-		}
+	limits := make([]Limits, numAgents)
+	for i := range limits {
+		limits[i] = Limits{Min: minPerAgent, Max: maxPerAgent}
 	}
 
-	return allocations
+	return WaterFill(int64(targetTotal), RandomWeights(numAgents, randomnessFactor), limits).Bandwidth
 }
 
 // CalculateStagger calculates staggered delays for smooth transitions
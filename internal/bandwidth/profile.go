@@ -0,0 +1,324 @@
+package bandwidth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConcurrencyProfile shapes the number of concurrent agents over time
+type ConcurrencyProfile interface {
+	// Target returns the desired concurrency at the given elapsed time,
+	// before jitter. Values outside [min, max] are clamped by the caller.
+	Target(elapsedSeconds float64, min, max int) float64
+}
+
+// Concurrency evaluates a profile and applies jitter proportional to
+// randomness, returning a concurrency within [min, max]
+func Concurrency(profile ConcurrencyProfile, elapsedSeconds float64, min, max int, randomness float64) int {
+	target := ClampFloat(profile.Target(elapsedSeconds, min, max), float64(min), float64(max))
+
+	// Jitter of up to half the range at randomness 1.0
+	spread := randomness * float64(max-min) / 2
+	target += spread * (rand.Float64()*2 - 1)
+
+	return Clamp(int(math.Round(target)), min, max)
+}
+
+// ConstantProfile holds concurrency at a fixed number of agents
+type ConstantProfile struct {
+	Count int // Zero means halfway between min and max
+}
+
+// Target implements ConcurrencyProfile
+func (p *ConstantProfile) Target(elapsedSeconds float64, min, max int) float64 {
+	if p.Count == 0 {
+		return float64(min+max) / 2
+	}
+	return float64(p.Count)
+}
+
+// SineWave is one component of a SineProfile
+type SineWave struct {
+	Period time.Duration
+	Weight float64
+	Phase  float64 // Radians
+}
+
+// SineProfile overlays sine waves and maps the result onto [min, max]
+type SineProfile struct {
+	Waves []SineWave
+}
+
+// DefaultSineWaves returns the original built-in pattern. Its waves were
+// written as sin(t/300), sin(t/180) and sin(t/420), so the periods are
+// 2π times those time constants.
+func DefaultSineWaves() []SineWave {
+	return []SineWave{
+		{Period: sinePeriod(300 * time.Second), Weight: 1.0},
+		{Period: sinePeriod(180 * time.Second), Weight: 0.5},
+		{Period: sinePeriod(420 * time.Second), Weight: 0.3},
+	}
+}
+
+// sinePeriod converts a time constant to the period of sin(t/constant)
+func sinePeriod(constant time.Duration) time.Duration {
+	return time.Duration(2 * math.Pi * float64(constant))
+}
+
+// Target implements ConcurrencyProfile
+func (p *SineProfile) Target(elapsedSeconds float64, min, max int) float64 {
+	waves := p.Waves
+	if len(waves) == 0 {
+		waves = DefaultSineWaves()
+	}
+
+	combined, totalWeight := 0.0, 0.0
+	for _, wave := range waves {
+		if wave.Period <= 0 {
+			continue
+		}
+		combined += wave.Weight * math.Sin(2*math.Pi*elapsedSeconds/wave.Period.Seconds()+wave.Phase)
+		totalWeight += math.Abs(wave.Weight)
+	}
+	if totalWeight == 0 {
+		return float64(min+max) / 2
+	}
+
+	// Map -1..1 onto min..max
+	normalized := (combined/totalWeight + 1) / 2
+	return float64(min) + float64(max-min)*normalized
+}
+
+// ProfilePoint is a point on a piecewise concurrency curve
+type ProfilePoint struct {
+	At          time.Duration
+	Concurrency float64
+}
+
+// PiecewiseProfile follows a curve defined by a table of points
+type PiecewiseProfile struct {
+	Points []ProfilePoint // Sorted by At
+	Period time.Duration  // Repeat the curve with this period; zero holds the last value
+	Step   bool           // Hold each value until the next point instead of interpolating
+}
+
+// NewPiecewiseProfile creates a piecewise profile from unsorted points
+func NewPiecewiseProfile(points []ProfilePoint, period time.Duration, step bool) (*PiecewiseProfile, error) {
+	if len(points) == 0 {
+		return nil, fmt.Errorf("piecewise profile needs at least one point")
+	}
+
+	sorted := make([]ProfilePoint, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].At < sorted[j].At })
+
+	for i, point := range sorted {
+		if point.At < 0 {
+			return nil, fmt.Errorf("piecewise profile point %d has negative offset", i)
+		}
+		if i > 0 && point.At == sorted[i-1].At {
+			return nil, fmt.Errorf("piecewise profile has duplicate offset %s", point.At)
+		}
+	}
+	if period > 0 && sorted[len(sorted)-1].At >= period {
+		return nil, fmt.Errorf("piecewise profile points must lie within the period %s", period)
+	}
+
+	return &PiecewiseProfile{Points: sorted, Period: period, Step: step}, nil
+}
+
+// Target implements ConcurrencyProfile
+func (p *PiecewiseProfile) Target(elapsedSeconds float64, min, max int) float64 {
+	at := time.Duration(elapsedSeconds * float64(time.Second))
+	if p.Period > 0 {
+		at %= p.Period
+	}
+
+	// Index of the first point after at
+	i := sort.Search(len(p.Points), func(i int) bool { return p.Points[i].At > at })
+
+	if i == 0 {
+		if p.Period == 0 {
+			return p.Points[0].Concurrency
+		}
+		// Wrap around from the last point of the previous cycle
+		last := p.Points[len(p.Points)-1]
+		prev := ProfilePoint{At: last.At - p.Period, Concurrency: last.Concurrency}
+		return p.between(prev, p.Points[0], at)
+	}
+
+	prev := p.Points[i-1]
+	if i == len(p.Points) {
+		if p.Period == 0 {
+			return prev.Concurrency
+		}
+		first := p.Points[0]
+		next := ProfilePoint{At: first.At + p.Period, Concurrency: first.Concurrency}
+		return p.between(prev, next, at)
+	}
+
+	return p.between(prev, p.Points[i], at)
+}
+
+// between returns the value at `at` on the segment from prev to next
+func (p *PiecewiseProfile) between(prev, next ProfilePoint, at time.Duration) float64 {
+	if p.Step || next.At == prev.At {
+		return prev.Concurrency
+	}
+	fraction := float64(at-prev.At) / float64(next.At-prev.At)
+	return prev.Concurrency + (next.Concurrency-prev.Concurrency)*fraction
+}
+
+// LoadProfilePoints reads a piecewise curve from a CSV or YAML file. CSV
+// rows are "offset,concurrency"; YAML is a list of {at, concurrency}.
+// Offsets are durations such as "90m" or plain seconds.
+func LoadProfilePoints(path string) ([]ProfilePoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile table: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return parseProfileCSV(string(data))
+	case ".yaml", ".yml":
+		return parseProfileYAML(data)
+	default:
+		return nil, fmt.Errorf("unsupported profile table format %q (use .csv, .yaml or .yml)", filepath.Ext(path))
+	}
+}
+
+// parseProfileCSV parses "offset,concurrency" rows, skipping a header row
+func parseProfileCSV(data string) ([]ProfilePoint, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 2
+
+	var points []ProfilePoint
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile table: %w", err)
+		}
+
+		at, err := ParseOffset(record[0])
+		if err != nil {
+			if line == 1 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("profile table row %d: %w", line, err)
+		}
+
+		concurrency, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("profile table row %d: invalid concurrency %q", line, record[1])
+		}
+
+		points = append(points, ProfilePoint{At: at, Concurrency: concurrency})
+	}
+
+	return points, nil
+}
+
+// parseProfileYAML parses a list of {at, concurrency} entries
+func parseProfileYAML(data []byte) ([]ProfilePoint, error) {
+	var rows []struct {
+		At          string  `yaml:"at"`
+		Concurrency float64 `yaml:"concurrency"`
+	}
+	if err := yaml.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("invalid profile table: %w", err)
+	}
+
+	points := make([]ProfilePoint, 0, len(rows))
+	for i, row := range rows {
+		at, err := ParseOffset(row.At)
+		if err != nil {
+			return nil, fmt.Errorf("profile table entry %d: %w", i+1, err)
+		}
+		points = append(points, ProfilePoint{At: at, Concurrency: row.Concurrency})
+	}
+
+	return points, nil
+}
+
+// ParseOffset parses a duration such as "90m" or a number of seconds
+func ParseOffset(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	return d, nil
+}
+
+// RandomWalkProfile wanders between min and max in bounded random steps,
+// drifting back towards the middle of the range
+type RandomWalkProfile struct {
+	StepInterval time.Duration // Time between steps
+	MaxStep      float64       // Largest change per step, in agents
+	Reversion    float64       // Pull towards the middle per step, 0..1
+
+	mu       sync.Mutex
+	value    float64
+	lastStep float64 // Elapsed seconds of the last step
+	started  bool
+}
+
+// Target implements ConcurrencyProfile
+func (p *RandomWalkProfile) Target(elapsedSeconds float64, min, max int) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lo, hi := float64(min), float64(max)
+	middle := (lo + hi) / 2
+
+	if !p.started {
+		p.value = middle
+		p.lastStep = elapsedSeconds
+		p.started = true
+		return p.value
+	}
+
+	interval := p.StepInterval.Seconds()
+	if interval <= 0 {
+		interval = 60
+	}
+
+	steps := int((elapsedSeconds - p.lastStep) / interval)
+	for i := 0; i < steps; i++ {
+		p.value += p.MaxStep * (rand.Float64()*2 - 1)
+		p.value += (middle - p.value) * p.Reversion
+
+		// Reflect off the bounds so the walk does not stick to them
+		if p.value > hi {
+			p.value = hi - (p.value - hi)
+		}
+		if p.value < lo {
+			p.value = lo + (lo - p.value)
+		}
+		p.value = ClampFloat(p.value, lo, hi)
+	}
+	p.lastStep += float64(steps) * interval
+
+	return p.value
+}
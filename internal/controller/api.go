@@ -2,7 +2,9 @@ package controller
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -12,23 +14,24 @@ import (
 	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
 )
 
-// APIServer provides HTTP API for monitoring
+// APIServer provides HTTP API for monitoring. Pool-scoped endpoints take a
+// pool query parameter and default to the first pool.
 type APIServer struct {
-	config    *Config
-	server    *Server
-	scheduler *Scheduler
-	metrics   *MetricsAggregator
-	logger    *logger.Logger
+	config  *Config
+	server  *Server
+	metrics *MetricsAggregator
+	audit   *AuditLog
+	logger  *logger.Logger
 }
 
 // NewAPIServer creates a new API server
-func NewAPIServer(config *Config, server *Server, scheduler *Scheduler, metrics *MetricsAggregator, log *logger.Logger) *APIServer {
+func NewAPIServer(config *Config, server *Server, metrics *MetricsAggregator, log *logger.Logger) *APIServer {
 	return &APIServer{
-		config:    config,
-		server:    server,
-		scheduler: scheduler,
-		metrics:   metrics,
-		logger:    log,
+		config:  config,
+		server:  server,
+		metrics: metrics,
+		audit:   NewAuditLog(500, log),
+		logger:  log,
 	}
 }
 
@@ -39,10 +42,28 @@ func (a *APIServer) Start(ctx context.Context) error {
 	// Register API routes
 	mux.HandleFunc("/metrics", a.handleMetrics)
 	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/pools", a.handlePools)
 	mux.HandleFunc("/agents", a.handleAgents)
+	mux.HandleFunc("/agents/calibrate", a.handleCalibrate)
+	mux.HandleFunc("/agents/mode", a.handleAgentMode)
 	mux.HandleFunc("/history", a.handleHistory)
 	mux.HandleFunc("/stats", a.handleStats)
 	mux.HandleFunc("/health", a.handleHealth)
+	mux.HandleFunc("/control", a.handleControl)
+	mux.HandleFunc("/rotation", a.handleRotation)
+	mux.HandleFunc("/rotation/cancel", a.handleRotationCancel)
+	mux.HandleFunc("/rotation/now", a.handleRotateNow)
+	mux.HandleFunc("/rotation/next", a.handleNextRotation)
+	mux.HandleFunc("/scheduler/pause", a.handlePause)
+	mux.HandleFunc("/scheduler/resume", a.handleResume)
+	mux.HandleFunc("/audit", a.handleAudit)
+	mux.HandleFunc("/transfer", a.handleTransfer)
+	mux.HandleFunc("/p95", a.handleP95)
+	mux.HandleFunc("/emergency-stop", a.handleEmergencyStop)
+	mux.HandleFunc("/emergency-stop/rearm", a.handleRearm)
+	mux.HandleFunc("/events", a.handleEvents)
+	mux.HandleFunc("/model", a.handleModel)
+	mux.HandleFunc("/overrides", a.handleOverrides)
 
 	// Register dashboard routes
 	dashboardHandler, err := dashboard.NewHandler()
@@ -86,7 +107,23 @@ func (a *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics := a.metrics.GetAggregated()
+	agentIDs, schedulers, ok := a.poolScope(w, r)
+	if !ok {
+		return
+	}
+	metrics := a.metrics.GetAggregatedFor(agentIDs)
+	target := combinedTarget(schedulers, (*Scheduler).LiveTarget)
+	configured := combinedTarget(schedulers, (*Scheduler).EffectiveTarget)
+
+	queueStats := a.server.GetSendQueueStats(agentIDs)
+	queueDepth := 0
+	degradedAgents := 0
+	for _, stats := range queueStats {
+		queueDepth += stats.Depth
+		if stats.Degraded {
+			degradedAgents++
+		}
+	}
 
 	response := map[string]interface{}{
 		"total_bandwidth_mbps": metrics.TotalBandwidth,
@@ -95,8 +132,14 @@ func (a *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		"total_agents":         metrics.TotalAgents,
 		"agent_breakdown":      metrics.AgentBreakdown,
 		"timestamp":            metrics.Timestamp,
-		"target_bandwidth_gbps": a.config.Bandwidth.TargetGbps,
-		"target_percentage":    (metrics.TotalBandwidth / a.config.GetTargetBandwidthMbps()) * 100,
+		"target_bandwidth_gbps": target.TargetMbps / 1000.0,
+		"configured_target_bandwidth_gbps": configured.TargetMbps / 1000.0,
+		"target_percentage":    percentOf(metrics.TotalBandwidth, target.TargetMbps),
+		"target_tolerance":     target.Tolerance,
+		"target_window":        target.Window,
+		"send_queue_depth":     queueDepth,
+		"degraded_agents":      degradedAgents,
+		"send_queues":          queueStats,
 	}
 
 	a.sendJSON(w, response)
@@ -109,32 +152,59 @@ func (a *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := a.scheduler.GetState()
-	metrics := a.metrics.GetAggregated()
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	state := scheduler.GetState()
+	metrics := scheduler.aggregated()
+	target := scheduler.EffectiveTarget()
 
 	// Build active allocations info
 	activeAllocations := make([]map[string]interface{}, 0)
 	for agentID, alloc := range state.ActiveAgents {
 		activeAllocations = append(activeAllocations, map[string]interface{}{
-			"agent_id":   agentID,
-			"bandwidth":  alloc.AllocatedBW,
-			"start_time": alloc.StartTime,
-			"url":        alloc.URL,
-			"command_id": alloc.CurrentCommand,
+			"agent_id":        agentID,
+			"bandwidth":       alloc.AllocatedBW,
+			"boost_bandwidth": alloc.BoostBandwidth(),
+			"start_time":      alloc.StartTime,
+			"url":             alloc.URL,
+			"command_id":      alloc.CurrentCommand,
+			"commands":        alloc.Commands,
 		})
 	}
 
 	response := map[string]interface{}{
+		"pool":                 scheduler.Pool(),
 		"phase":                state.Phase,
 		"active_agents":        len(state.ActiveAgents),
 		"next_rotation":        state.NextRotation,
 		"time_until_rotation":  time.Until(state.NextRotation).Round(time.Second).String(),
 		"last_rotation":        state.LastRotation,
 		"rotation_count":       state.RotationCount,
+		"planned_bandwidth":    state.PlannedTotalBW,
+		"plan_shortfall":       state.PlanShortfall,
+		"region_constraints":   scheduler.GetRegionConstraints(),
+		"topology":             scheduler.GetTopology(),
+		"overrides":            scheduler.GetOverrides(),
+		"pause":                scheduler.GetPause(),
+		"transfer":             scheduler.GetTransferUsage().Pool,
+		"emergency_stop":       a.server.GetFence(),
 		"active_allocations":   activeAllocations,
 		"target_bandwidth":     state.TargetTotalBW,
+		"configured_target_bandwidth": target.TargetMbps,
+		"target_tolerance":     target.Tolerance,
+		"target_window":        target.Window,
 		"actual_bandwidth":     metrics.TotalBandwidth,
-		"bandwidth_percentage": (metrics.TotalBandwidth / state.TargetTotalBW) * 100,
+		"bandwidth_percentage": percentOf(metrics.TotalBandwidth, state.TargetTotalBW),
+		"leadership":           a.server.GetLeaderInfo(),
+	}
+	if p95, ok := scheduler.GetP95(); ok {
+		response["p95"] = p95
+	}
+	if volume, ok := scheduler.GetVolume(); ok {
+		response["volume"] = volume
 	}
 
 	a.sendJSON(w, response)
@@ -147,6 +217,12 @@ func (a *APIServer) handleAgents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pool := r.URL.Query().Get("pool")
+	if _, ok := a.server.GetScheduler(pool); pool != "" && !ok {
+		http.Error(w, "Unknown pool", http.StatusNotFound)
+		return
+	}
+
 	connectedAgents := a.server.GetConnectedAgents()
 	connectedMap := make(map[string]bool)
 	for _, id := range connectedAgents {
@@ -154,14 +230,33 @@ func (a *APIServer) handleAgents(w http.ResponseWriter, r *http.Request) {
 	}
 
 	agents := make([]map[string]interface{}, 0)
+	capacities := make(map[string]AgentCapacity)
+	for _, scheduler := range a.server.GetSchedulers() {
+		for agentID, capacity := range scheduler.GetCapacities() {
+			capacities[agentID] = capacity
+		}
+	}
 
+	connected := 0
 	for _, agent := range a.config.Agents {
+		scheduler := a.server.schedulerFor(agent.ID)
+		if pool != "" && (scheduler == nil || scheduler.Pool() != pool) {
+			continue
+		}
+
 		isConnected := connectedMap[agent.ID]
+		if isConnected {
+			connected++
+		}
 		var lastSeen *time.Time
 		var currentBandwidth float64
 
+		var queueStats *SendQueueStats
+
 		if client, ok := a.server.GetClient(agent.ID); ok {
 			lastSeen = &client.LastSeen
+			stats := client.Queue.Stats()
+			queueStats = &stats
 		}
 
 		if agentMetrics := a.metrics.GetAgentMetrics(agent.ID); agentMetrics != nil {
@@ -174,6 +269,7 @@ func (a *APIServer) handleAgents(w http.ResponseWriter, r *http.Request) {
 			"host":              agent.Host,
 			"max_bandwidth":     agent.MaxBandwidth,
 			"region":            agent.Region,
+			"labels":            agent.Labels,
 			"connected":         isConnected,
 			"current_bandwidth": currentBandwidth,
 		}
@@ -182,19 +278,114 @@ func (a *APIServer) handleAgents(w http.ResponseWriter, r *http.Request) {
 			agentInfo["last_seen"] = lastSeen
 		}
 
+		if capacity, ok := capacities[agent.ID]; ok {
+			agentInfo["calibration"] = capacity
+		}
+		if scheduler != nil {
+			agentInfo["pool"] = scheduler.Pool()
+			agentInfo["usable_bandwidth"] = scheduler.capacity(agent)
+			agentInfo["mode"] = scheduler.GetAgentMode(agent.ID)
+			agentInfo["transfer"] = scheduler.GetTransferUsage().Agents[agent.ID]
+		}
+
+		if queueStats != nil {
+			agentInfo["send_queue_depth"] = queueStats.Depth
+			agentInfo["degraded"] = queueStats.Degraded
+		}
+
 		agents = append(agents, agentInfo)
 	}
 
 	response := map[string]interface{}{
 		"agents":       agents,
 		"total":        len(agents),
-		"connected":    len(connectedAgents),
-		"disconnected": len(agents) - len(connectedAgents),
+		"connected":    connected,
+		"disconnected": len(agents) - connected,
 	}
 
 	a.sendJSON(w, response)
 }
 
+// handleCalibrate starts a capacity calibration of an idle agent
+func (a *APIServer) handleCalibrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "Missing agent_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	scheduler := a.server.schedulerFor(agentID)
+	if scheduler == nil {
+		http.Error(w, "Agent is not in any pool", http.StatusNotFound)
+		return
+	}
+
+	err := scheduler.StartCalibration(agentID)
+	a.record(r, caller, err, AuditEntry{Action: "calibrate", Pool: scheduler.Pool(), Target: agentID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, map[string]interface{}{
+		"status":   "calibrating",
+		"agent_id": agentID,
+		"duration": a.config.Calibration.Duration.String(),
+	})
+}
+
+// handleAgentMode cordons, drains or reactivates an agent
+func (a *APIServer) handleAgentMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	agentID := r.URL.Query().Get("agent_id")
+	mode := r.URL.Query().Get("mode")
+	if agentID == "" || mode == "" {
+		http.Error(w, "Missing agent_id or mode parameter", http.StatusBadRequest)
+		return
+	}
+
+	scheduler := a.server.schedulerFor(agentID)
+	if scheduler == nil {
+		http.Error(w, "Agent is not in any pool", http.StatusNotFound)
+		return
+	}
+
+	err := scheduler.SetAgentMode(agentID, mode)
+	a.record(r, caller, err, AuditEntry{Action: "set_agent_mode", Pool: scheduler.Pool(), Target: agentID, Detail: mode})
+	if err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, ErrUnknownAgentMode) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	a.sendJSON(w, map[string]interface{}{
+		"agent_id": agentID,
+		"mode":     scheduler.GetAgentMode(agentID),
+	})
+}
+
 // handleHistory returns historical bandwidth data
 func (a *APIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -214,7 +405,11 @@ func (a *APIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	history := a.metrics.GetHistory(duration)
+	agentIDs, _, ok := a.poolScope(w, r)
+	if !ok {
+		return
+	}
+	history := a.metrics.GetHistoryFor(duration, agentIDs)
 
 	response := map[string]interface{}{
 		"history":       history,
@@ -244,7 +439,12 @@ func (a *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats := a.metrics.GetStats(duration)
+	agentIDs, schedulers, ok := a.poolScope(w, r)
+	if !ok {
+		return
+	}
+	stats := a.metrics.GetStatsFor(duration, agentIDs)
+	target := combinedTarget(schedulers, (*Scheduler).EffectiveTarget)
 
 	response := map[string]interface{}{
 		"duration":            duration.String(),
@@ -253,18 +453,651 @@ func (a *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
 		"max_bandwidth":       stats.Max,
 		"std_deviation":       stats.StandardDeviation,
 		"sample_count":        stats.SampleCount,
-		"target_bandwidth":    a.config.GetTargetBandwidthMbps(),
-		"average_vs_target":   (stats.Average / a.config.GetTargetBandwidthMbps()) * 100,
+		"target_bandwidth":    target.TargetMbps,
+		"average_vs_target":   percentOf(stats.Average, target.TargetMbps),
 	}
 
 	a.sendJSON(w, response)
 }
 
+// handleControl returns the bandwidth controller internals for debugging
+func (a *APIServer) handleControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	state := scheduler.GetState()
+	control := scheduler.config.Scheduler.Control
+
+	allocations := make([]map[string]interface{}, 0, len(state.ActiveAgents))
+	for agentID, alloc := range state.ActiveAgents {
+		allocations = append(allocations, map[string]interface{}{
+			"agent_id":     agentID,
+			"allocated_bw": alloc.AllocatedBW,
+			"commanded_bw": alloc.CommandedBW,
+			"command_id":   alloc.CurrentCommand,
+		})
+	}
+
+	response := map[string]interface{}{
+		"mode":                control.Mode,
+		"phase":               state.Phase,
+		"max_correction":      control.MaxCorrection,
+		"deadband":            control.Deadband,
+		"min_agent_bandwidth": control.MinAgentBandwidth,
+		"pid":                 scheduler.GetControlState(),
+		"allocations":         allocations,
+	}
+
+	a.sendJSON(w, response)
+}
+
+// handleRotation returns the rotation in progress and recent scheduler events
+func (a *APIServer) handleRotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"pool":        scheduler.Pool(),
+		"in_progress": false,
+		"events":      scheduler.Events().Recent(),
+	}
+	if rotation, ok := scheduler.GetRotation(); ok {
+		response["in_progress"] = true
+		response["rotation"] = rotation
+	}
+
+	a.sendJSON(w, response)
+}
+
+// handleRotationCancel aborts the rotation in progress
+func (a *APIServer) handleRotationCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	err := scheduler.CancelRotation("cancelled by " + caller)
+	a.record(r, caller, err, AuditEntry{Action: "cancel_rotation", Pool: scheduler.Pool()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, map[string]interface{}{"status": "cancelling"})
+}
+
+// handleRotateNow begins a rotation on the next evaluation
+func (a *APIServer) handleRotateNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	err := scheduler.RotateNow(caller)
+	a.record(r, caller, err, AuditEntry{Action: "rotate_now", Pool: scheduler.Pool()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, map[string]interface{}{"status": "rotating"})
+}
+
+// handleNextRotation sets when the next rotation begins, at a time (at,
+// RFC 3339) or after a delay (in, e.g. 10m)
+func (a *APIServer) handleNextRotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var at time.Time
+	if s := r.URL.Query().Get("at"); s != "" {
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid at parameter, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		at = parsed
+	} else if s := r.URL.Query().Get("in"); s != "" {
+		delay, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "Invalid in parameter", http.StatusBadRequest)
+			return
+		}
+		at = time.Now().Add(delay)
+	} else {
+		http.Error(w, "Missing at or in parameter", http.StatusBadRequest)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	err := scheduler.SetNextRotation(at, caller)
+	a.record(r, caller, err, AuditEntry{Action: "set_next_rotation", Pool: scheduler.Pool(), Detail: at.Format(time.RFC3339)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, map[string]interface{}{"next_rotation": at})
+}
+
+// handlePause freezes the current allocation
+func (a *APIServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	err := scheduler.Pause(caller, reason)
+	a.record(r, caller, err, AuditEntry{Action: "pause", Pool: scheduler.Pool(), Detail: reason})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, scheduler.GetPause())
+}
+
+// handleResume lets a paused scheduler act again
+func (a *APIServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	err := scheduler.Resume(caller)
+	a.record(r, caller, err, AuditEntry{Action: "resume", Pool: scheduler.Pool()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, scheduler.GetPause())
+}
+
+// handleEmergencyStop returns the emergency stop fence (GET), or engages it
+// (POST), stopping every agent until re-armed
+func (a *APIServer) handleEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.sendJSON(w, a.server.GetFence())
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	err := a.server.EmergencyStop(caller, reason)
+	a.record(r, caller, err, AuditEntry{Action: "emergency_stop", Detail: reason})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, a.server.GetFence())
+}
+
+// handleRearm lifts the emergency stop fence
+func (a *APIServer) handleRearm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := a.server.Rearm(caller)
+	a.record(r, caller, err, AuditEntry{Action: "rearm"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.sendJSON(w, a.server.GetFence())
+}
+
+// handleTransfer returns the bytes downloaded by a pool and its agents in
+// their billing periods
+func (a *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	a.sendJSON(w, scheduler.GetTransferUsage())
+}
+
+// handleP95 returns a pool's 95th percentile so far and projected for the
+// billing month, in p95 target mode
+func (a *APIServer) handleP95(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	p95, ok := scheduler.GetP95()
+	if !ok {
+		http.Error(w, "Pool is not in p95 target mode", http.StatusNotFound)
+		return
+	}
+	a.sendJSON(w, p95)
+}
+
+// handleAudit returns the recent operator actions
+func (a *APIServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := a.principal(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	entries := a.audit.Recent()
+	a.sendJSON(w, map[string]interface{}{
+		"entries": entries,
+		"total":   len(entries),
+	})
+}
+
+// handleModel returns the learned throughput model
+func (a *APIServer) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	model := scheduler.GetThroughputModel()
+	efficiencies, capacities := scheduler.GetDeliverableCapacities()
+	config := scheduler.config.Scheduler.ThroughputModel
+
+	a.sendJSON(w, map[string]interface{}{
+		"pool":                  scheduler.Pool(),
+		"enabled":               !config.Disabled,
+		"half_life":             config.HalfLife.String(),
+		"min_samples":           config.MinSamples,
+		"efficiency":            efficiencies,
+		"deliverable_bandwidth": capacities,
+		"agents":                model.Agents,
+		"urls":                  model.URLs,
+	})
+}
+
+// handleEvents streams scheduler events as server-sent events
+func (a *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	scheduler, ok := a.poolScheduler(w, r)
+	if !ok {
+		return
+	}
+
+	events, unsubscribe := scheduler.Events().Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				a.logger.Errorw("Failed to encode event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// overrideRequest is the body of a request to add an override
+type overrideRequest struct {
+	Kind      string            `json:"kind"`
+	AgentIDs  []string          `json:"agent_ids"`
+	Selector  map[string]string `json:"selector"`
+	Bandwidth int64             `json:"bandwidth"`
+	Duration  string            `json:"duration"`
+	Reason    string            `json:"reason"`
+}
+
+// handleOverrides lists (GET), adds (POST) or removes (DELETE) selection
+// overrides
+func (a *APIServer) handleOverrides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := a.principal(r)
+	if r.Method != http.MethodGet && !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		scheduler, ok := a.poolScheduler(w, r)
+		if !ok {
+			return
+		}
+		a.sendJSON(w, map[string]interface{}{
+			"pool":      scheduler.Pool(),
+			"overrides": scheduler.GetOverrides(),
+		})
+
+	case http.MethodPost:
+		scheduler, ok := a.poolScheduler(w, r)
+		if !ok {
+			return
+		}
+
+		var req overrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+
+		override, err := scheduler.AddOverride(Override{
+			Kind:      req.Kind,
+			AgentIDs:  req.AgentIDs,
+			Selector:  req.Selector,
+			Bandwidth: req.Bandwidth,
+			Reason:    req.Reason,
+		}, duration)
+		a.record(r, caller, err, AuditEntry{
+			Action: "add_override",
+			Pool:   scheduler.Pool(),
+			Target: override.ID,
+			Detail: fmt.Sprintf("%s %v %v for %s: %s", req.Kind, req.AgentIDs, req.Selector, duration, req.Reason),
+		})
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrNotLeader) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		a.sendJSON(w, override)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+			return
+		}
+
+		for _, scheduler := range a.server.GetSchedulers() {
+			err := scheduler.RemoveOverride(id)
+			if errors.Is(err, ErrOverrideNotFound) {
+				continue
+			}
+			a.record(r, caller, err, AuditEntry{Action: "remove_override", Pool: scheduler.Pool(), Target: id})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
+			a.sendJSON(w, map[string]interface{}{
+				"status": "removed",
+				"id":     id,
+			})
+			return
+		}
+		http.Error(w, ErrOverrideNotFound.Error(), http.StatusNotFound)
+	}
+}
+
+// handlePools lists the pools with a summary of each
+func (a *APIServer) handlePools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	connected := make(map[string]bool)
+	for _, agentID := range a.server.GetConnectedAgents() {
+		connected[agentID] = true
+	}
+
+	pools := make([]map[string]interface{}, 0, len(a.server.GetSchedulers()))
+	for _, scheduler := range a.server.GetSchedulers() {
+		state := scheduler.GetState()
+		metrics := scheduler.aggregated()
+		target := scheduler.EffectiveTarget()
+
+		agentIDs := scheduler.AgentIDs()
+		connectedCount := 0
+		for _, agentID := range agentIDs {
+			if connected[agentID] {
+				connectedCount++
+			}
+		}
+
+		pools = append(pools, map[string]interface{}{
+			"name":                 scheduler.Pool(),
+			"agents":               agentIDs,
+			"connected_agents":     connectedCount,
+			"active_agents":        len(state.ActiveAgents),
+			"phase":                state.Phase,
+			"target_bandwidth":     state.TargetTotalBW,
+			"configured_target_bandwidth": target.TargetMbps,
+			"actual_bandwidth":     metrics.TotalBandwidth,
+			"bandwidth_percentage": percentOf(metrics.TotalBandwidth, state.TargetTotalBW),
+		})
+	}
+
+	a.sendJSON(w, map[string]interface{}{
+		"pools": pools,
+		"total": len(pools),
+	})
+}
+
+// poolScheduler returns the scheduler of the pool named in the request, or
+// of the first pool if none is named. It answers the request itself and
+// returns false if the pool does not exist.
+func (a *APIServer) poolScheduler(w http.ResponseWriter, r *http.Request) (*Scheduler, bool) {
+	pool := r.URL.Query().Get("pool")
+	if pool == "" {
+		return a.server.GetSchedulers()[0], true
+	}
+
+	scheduler, ok := a.server.GetScheduler(pool)
+	if !ok {
+		http.Error(w, "Unknown pool", http.StatusNotFound)
+		return nil, false
+	}
+	return scheduler, true
+}
+
+// poolScope returns the agents and scheduler of the pool named in the
+// request. Without a pool it returns nil, meaning all agents, and every
+// scheduler. It answers the request itself and returns false if the pool
+// does not exist.
+func (a *APIServer) poolScope(w http.ResponseWriter, r *http.Request) ([]string, []*Scheduler, bool) {
+	if r.URL.Query().Get("pool") != "" {
+		scheduler, ok := a.poolScheduler(w, r)
+		if !ok {
+			return nil, nil, false
+		}
+		return scheduler.AgentIDs(), []*Scheduler{scheduler}, true
+	}
+	return nil, a.server.GetSchedulers(), true
+}
+
+// combinedTarget adds up a target of the given pools, such as
+// (*Scheduler).LiveTarget. The tolerance is the target-weighted mean of the
+// pools' tolerances.
+func combinedTarget(schedulers []*Scheduler, targetOf func(*Scheduler) EffectiveTarget) EffectiveTarget {
+	if len(schedulers) == 1 {
+		return targetOf(schedulers[0])
+	}
+
+	combined := EffectiveTarget{Window: "combined"}
+	for _, scheduler := range schedulers {
+		target := targetOf(scheduler)
+		combined.TargetMbps += target.TargetMbps
+		combined.Tolerance += target.TargetMbps * target.Tolerance
+	}
+	if combined.TargetMbps > 0 {
+		combined.Tolerance /= combined.TargetMbps
+	}
+	return combined
+}
+
+// percentOf returns value as a percentage of target, or 0 without a target
+func percentOf(value, target float64) float64 {
+	if target <= 0 {
+		return 0
+	}
+	return value / target * 100
+}
+
+// principal checks the bearer token of a request that changes state and
+// returns the name of the token
+func (a *APIServer) principal(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Server.AuthToken)) == 1 {
+		return AdminPrincipal, true
+	}
+	for _, t := range a.config.Server.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t.Name, true
+		}
+	}
+	return "", false
+}
+
+// record adds an operator action to the audit log
+func (a *APIServer) record(r *http.Request, caller string, err error, entry AuditEntry) {
+	entry.Principal = caller
+	entry.RemoteAddr = r.RemoteAddr
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	a.audit.Record(entry)
+}
+
 // handleHealth returns health check
 func (a *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":    "healthy",
 		"timestamp": time.Now(),
+		"role":      a.server.GetLeaderInfo()["role"],
 	}
 
 	a.sendJSON(w, response)
@@ -307,7 +1140,7 @@ func (a *APIServer) corsMiddleware(next http.Handler) http.Handler {
 		origin := r.Header.Get("Origin")
 		if origin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		}
 
@@ -331,10 +1164,17 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // PrintDashboard prints a console dashboard
 func (a *APIServer) PrintDashboard() {
 	metrics := a.metrics.GetAggregated()
-	state := a.scheduler.GetState()
+	schedulers := a.server.GetSchedulers()
 
 	// Clear screen
 	fmt.Print("\033[H\033[2J")
@@ -343,18 +1183,25 @@ func (a *APIServer) PrintDashboard() {
 	fmt.Println("          Google Bandwidth Controller Dashboard")
 	fmt.Println("═══════════════════════════════════════════════════════════════")
 
-	targetGbps := a.config.Bandwidth.TargetGbps
+	targetGbps := combinedTarget(schedulers, (*Scheduler).LiveTarget).TargetMbps / 1000.0
 	currentGbps := metrics.TotalBandwidth / 1000.0
-	percentage := (currentGbps / targetGbps) * 100
+	percentage := percentOf(currentGbps, targetGbps)
 
 	fmt.Printf("\nTarget: %.2f Gbps | Current: %.2f Gbps (%.1f%%)\n",
 		targetGbps, currentGbps, percentage)
 
 	fmt.Printf("Active Agents: %d/%d\n", metrics.ActiveAgents, metrics.TotalAgents)
-	fmt.Printf("Next Rotation: %s (%s)\n",
-		state.NextRotation.Format("15:04:05"),
-		time.Until(state.NextRotation).Round(time.Second))
-	fmt.Printf("Phase: %s | Rotations: %d\n\n", state.Phase, state.RotationCount)
+	for _, scheduler := range schedulers {
+		state := scheduler.GetState()
+		if len(schedulers) > 1 {
+			fmt.Printf("\nPool: %s\n", scheduler.Pool())
+		}
+		fmt.Printf("Next Rotation: %s (%s)\n",
+			state.NextRotation.Format("15:04:05"),
+			time.Until(state.NextRotation).Round(time.Second))
+		fmt.Printf("Phase: %s | Rotations: %d\n", state.Phase, state.RotationCount)
+	}
+	fmt.Println()
 
 	fmt.Println("Agent Bandwidth Breakdown:")
 	fmt.Println("─────────────────────────────────────────────────────────────")
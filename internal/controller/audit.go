@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
+)
+
+// AuditEntry records an operator action taken through the API
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"` // Name of the API token used
+	RemoteAddr string    `json:"remote_addr"`
+	Action     string    `json:"action"`
+	Pool       string    `json:"pool,omitempty"`
+	Target     string    `json:"target,omitempty"` // Agent or override acted on
+	Detail     string    `json:"detail,omitempty"`
+	Error      string    `json:"error,omitempty"` // Set if the action failed
+}
+
+// AuditLog keeps the most recent operator actions and writes every action
+// to the controller log
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	limit   int
+	logger  *logger.Logger
+}
+
+// NewAuditLog creates an audit log that remembers up to limit entries
+func NewAuditLog(limit int, log *logger.Logger) *AuditLog {
+	return &AuditLog{
+		limit:  limit,
+		logger: log,
+	}
+}
+
+// Record adds an entry
+func (l *AuditLog) Record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.limit {
+		l.entries = l.entries[len(l.entries)-l.limit:]
+	}
+	l.mu.Unlock()
+
+	l.logger.Infow("Audit",
+		"principal", entry.Principal,
+		"remote_addr", entry.RemoteAddr,
+		"action", entry.Action,
+		"pool", entry.Pool,
+		"target", entry.Target,
+		"detail", entry.Detail,
+		"error", entry.Error,
+	)
+}
+
+// Recent returns the remembered entries, oldest first
+func (l *AuditLog) Recent() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
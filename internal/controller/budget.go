@@ -0,0 +1,356 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+// Store key for transfer usage
+const keyTransferUsage = "transfer_usage"
+
+// billingPeriod is a parsed TransferBudgetConfig
+type billingPeriod struct {
+	limit     int64 // Bytes; 0 = unlimited
+	anchorDay int
+	location  *time.Location
+}
+
+// parseBillingPeriod validates and parses a transfer budget
+func parseBillingPeriod(bc TransferBudgetConfig) (billingPeriod, error) {
+	if bc.LimitGB < 0 {
+		return billingPeriod{}, fmt.Errorf("limit_gb must not be negative")
+	}
+	if bc.AnchorDay < 0 || bc.AnchorDay > 28 {
+		return billingPeriod{}, fmt.Errorf("anchor_day must be between 0 (= 1) and 28")
+	}
+
+	period := billingPeriod{
+		limit:     int64(bc.LimitGB * 1e9),
+		anchorDay: bc.AnchorDay,
+		location:  time.Local,
+	}
+	if period.anchorDay == 0 {
+		period.anchorDay = 1
+	}
+	if bc.Timezone != "" {
+		location, err := time.LoadLocation(bc.Timezone)
+		if err != nil {
+			return billingPeriod{}, fmt.Errorf("invalid timezone: %w", err)
+		}
+		period.location = location
+	}
+	return period, nil
+}
+
+// bounds returns the start and end of the billing period containing t
+func (p billingPeriod) bounds(t time.Time) (time.Time, time.Time) {
+	local := t.In(p.location)
+	start := time.Date(local.Year(), local.Month(), p.anchorDay, 0, 0, 0, 0, p.location)
+	if start.After(local) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// TransferUsage is the bytes an agent or pool downloaded in its current
+// billing period
+type TransferUsage struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Bytes       int64     `json:"bytes"`
+	LimitBytes  int64     `json:"limit_bytes,omitempty"` // 0 = unlimited
+	Pace        float64   `json:"pace"`                  // Share of its bandwidth the budget allows now
+	Exhausted   bool      `json:"exhausted"`
+}
+
+// TransferStatus is the transfer usage of a pool and its agents
+type TransferStatus struct {
+	Pool   TransferUsage            `json:"pool"`
+	Agents map[string]TransferUsage `json:"agents"`
+}
+
+// transferMeter counts the bytes of an agent or pool in its billing period
+type transferMeter struct {
+	period billingPeriod
+	usage  TransferUsage
+}
+
+// newTransferMeter creates a meter for the period containing now
+func newTransferMeter(period billingPeriod, now time.Time) *transferMeter {
+	m := &transferMeter{period: period}
+	m.roll(now)
+	return m
+}
+
+// roll starts a new billing period if the current one has ended. It
+// returns true if it did.
+func (m *transferMeter) roll(now time.Time) bool {
+	if now.Before(m.usage.PeriodEnd) {
+		return false
+	}
+	start, end := m.period.bounds(now)
+	m.usage = TransferUsage{PeriodStart: start, PeriodEnd: end}
+	return true
+}
+
+// exhausted reports whether the budget is used up
+func (m *transferMeter) exhausted() bool {
+	return m.period.limit > 0 && m.usage.Bytes >= m.period.limit
+}
+
+// pace returns the share of its bandwidth the budget allows now: the share
+// of the budget left over the share of the period left, at most 1. A meter
+// ahead of an even spend is slowed down so the budget lasts the period.
+func (m *transferMeter) pace(now time.Time) float64 {
+	if m.period.limit <= 0 {
+		return 1
+	}
+	left := 1 - float64(m.usage.Bytes)/float64(m.period.limit)
+	if left <= 0 {
+		return 0
+	}
+	periodLeft := m.usage.PeriodEnd.Sub(now).Seconds() / m.usage.PeriodEnd.Sub(m.usage.PeriodStart).Seconds()
+	if periodLeft <= 0 || left >= periodLeft {
+		return 1
+	}
+	return left / periodLeft
+}
+
+// status returns the meter's usage
+func (m *transferMeter) status(now time.Time) TransferUsage {
+	usage := m.usage
+	usage.LimitBytes = m.period.limit
+	usage.Pace = m.pace(now)
+	usage.Exhausted = m.exhausted()
+	return usage
+}
+
+// transferBudgets meters the bytes downloaded by a pool and its agents. It
+// has its own lock so selection can consult it with or without s.mu held.
+type transferBudgets struct {
+	mu       sync.Mutex
+	pool     *transferMeter
+	agents   map[string]*transferMeter
+	counters map[string]int64 // Last cumulative byte count each agent reported
+}
+
+// newTransferBudgets creates meters for a pool and its agents. Budgets were
+// validated with the config, so any that fail to parse are unlimited.
+func newTransferBudgets(config *Config) *transferBudgets {
+	now := time.Now()
+	meter := func(bc TransferBudgetConfig) *transferMeter {
+		period, err := parseBillingPeriod(bc)
+		if err != nil {
+			period = billingPeriod{anchorDay: 1, location: time.Local}
+		}
+		return newTransferMeter(period, now)
+	}
+
+	b := &transferBudgets{
+		pool:     meter(config.Scheduler.TransferBudget),
+		agents:   make(map[string]*transferMeter, len(config.Agents)),
+		counters: make(map[string]int64),
+	}
+	for _, agent := range config.Agents {
+		b.agents[agent.ID] = meter(agent.TransferBudget)
+	}
+	return b
+}
+
+// transferSnapshot is the persisted transfer usage
+type transferSnapshot struct {
+	Pool     TransferUsage            `json:"pool"`
+	Agents   map[string]TransferUsage `json:"agents"`
+	Counters map[string]int64         `json:"counters"`
+}
+
+// OnAgentMetrics counts the bytes an agent reports towards its transfer
+// budget and the pool's, and towards the pool's volume target. Agents report
+// a cumulative count, which starts over when the agent restarts.
+func (s *Scheduler) OnAgentMetrics(agentID string, metrics *protocol.MetricsPayload) {
+	now := time.Now()
+	b := s.transfer
+
+	b.mu.Lock()
+	meter, ok := b.agents[agentID]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+
+	// Unseen agents count from zero: the counter starts with the agent
+	// process, and counters seen before a restart are restored
+	last := b.counters[agentID]
+	b.counters[agentID] = metrics.BytesDownloaded
+	delta := metrics.BytesDownloaded - last
+	if delta < 0 {
+		delta = metrics.BytesDownloaded // The agent restarted
+	}
+
+	meter.roll(now)
+	b.pool.roll(now)
+
+	agentWasExhausted, poolWasExhausted := meter.exhausted(), b.pool.exhausted()
+	meter.usage.Bytes += delta
+	b.pool.usage.Bytes += delta
+	agentExhausted, poolExhausted := meter.exhausted(), b.pool.exhausted()
+	used, poolUsed := meter.usage.Bytes, b.pool.usage.Bytes
+	b.mu.Unlock()
+
+	s.countVolume(delta)
+
+	if agentExhausted && !agentWasExhausted {
+		s.logger.Warnw("Agent transfer budget exhausted, taking it out of rotation",
+			"agent_id", agentID,
+			"bytes", used,
+		)
+	}
+	if poolExhausted && !poolWasExhausted {
+		s.logger.Warnw("Pool transfer budget exhausted, stopping all agents", "bytes", poolUsed)
+	}
+}
+
+// budgetExhausted reports whether an agent or its pool has used up its
+// transfer budget
+func (s *Scheduler) budgetExhausted(agentID string) bool {
+	now := time.Now()
+	b := s.transfer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pool.roll(now)
+	if b.pool.exhausted() {
+		return true
+	}
+	meter, ok := b.agents[agentID]
+	if !ok {
+		return false
+	}
+	meter.roll(now)
+	return meter.exhausted()
+}
+
+// agentPace returns the share of its bandwidth an agent's transfer budget
+// allows now
+func (s *Scheduler) agentPace(agentID string) float64 {
+	b := s.transfer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meter, ok := b.agents[agentID]
+	if !ok {
+		return 1
+	}
+	now := time.Now()
+	meter.roll(now)
+	return meter.pace(now)
+}
+
+// poolPace returns the share of its target the pool's transfer budget
+// allows now
+func (s *Scheduler) poolPace() float64 {
+	b := s.transfer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.pool.roll(now)
+	return b.pool.pace(now)
+}
+
+// GetTransferUsage returns the transfer usage of the pool and its agents (for API)
+func (s *Scheduler) GetTransferUsage() TransferStatus {
+	now := time.Now()
+	b := s.transfer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pool.roll(now)
+	status := TransferStatus{
+		Pool:   b.pool.status(now),
+		Agents: make(map[string]TransferUsage, len(b.agents)),
+	}
+	for agentID, meter := range b.agents {
+		meter.roll(now)
+		status.Agents[agentID] = meter.status(now)
+	}
+	return status
+}
+
+// saveTransferUsage persists the transfer usage
+func (s *Scheduler) saveTransferUsage() {
+	if s.store == nil {
+		return
+	}
+
+	b := s.transfer
+	b.mu.Lock()
+	snapshot := transferSnapshot{
+		Pool:     b.pool.usage,
+		Agents:   make(map[string]TransferUsage, len(b.agents)),
+		Counters: make(map[string]int64, len(b.counters)),
+	}
+	for agentID, meter := range b.agents {
+		snapshot.Agents[agentID] = meter.usage
+	}
+	for agentID, counter := range b.counters {
+		snapshot.Counters[agentID] = counter
+	}
+	b.mu.Unlock()
+
+	if err := s.store.Put(bucketScheduler, s.storeKey(keyTransferUsage), snapshot); err != nil {
+		s.logger.Warnw("Failed to persist transfer usage", "error", err)
+	}
+}
+
+// restoreTransferUsage loads the transfer usage counted before a restart or
+// under a previous leader, unless more has been counted since. Usage from a
+// billing period that has since ended is dropped.
+func (s *Scheduler) restoreTransferUsage() {
+	if s.store == nil {
+		return
+	}
+
+	var snapshot transferSnapshot
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keyTransferUsage), &snapshot)
+	if err != nil {
+		s.logger.Warnw("Failed to restore transfer usage", "error", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	now := time.Now()
+	restore := func(meter *transferMeter, usage TransferUsage) {
+		if now.Before(usage.PeriodStart) || !now.Before(usage.PeriodEnd) {
+			return
+		}
+		if usage.Bytes > meter.usage.Bytes {
+			meter.usage.Bytes = usage.Bytes
+		}
+	}
+
+	b := s.transfer
+	b.mu.Lock()
+	restore(b.pool, snapshot.Pool)
+	for agentID, usage := range snapshot.Agents {
+		if meter, ok := b.agents[agentID]; ok {
+			restore(meter, usage)
+		}
+	}
+	for agentID, counter := range snapshot.Counters {
+		if _, seen := b.counters[agentID]; !seen {
+			b.counters[agentID] = counter
+		}
+	}
+	poolBytes := b.pool.usage.Bytes
+	b.mu.Unlock()
+
+	s.logger.Infow("Restored transfer usage", "pool_bytes", poolBytes)
+}
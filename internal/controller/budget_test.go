@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBillingPeriodBounds(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		anchorDay int
+		timezone  string
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "calendar month",
+			timezone:  "UTC",
+			now:       time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "after the anchor day",
+			anchorDay: 15,
+			timezone:  "UTC",
+			now:       time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "before the anchor day",
+			anchorDay: 15,
+			timezone:  "UTC",
+			now:       time.Date(2026, 3, 14, 23, 59, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "across the year",
+			anchorDay: 28,
+			timezone:  "UTC",
+			now:       time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2025, 12, 28, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 1, 28, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "already started in the time zone",
+			timezone:  "Asia/Tokyo",
+			now:       time.Date(2026, 3, 31, 16, 0, 0, 0, time.UTC), // April 1st 01:00 in Tokyo
+			wantStart: time.Date(2026, 4, 1, 0, 0, 0, 0, tokyo),
+			wantEnd:   time.Date(2026, 5, 1, 0, 0, 0, 0, tokyo),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, err := parseBillingPeriod(TransferBudgetConfig{AnchorDay: tt.anchorDay, Timezone: tt.timezone})
+			if err != nil {
+				t.Fatalf("parseBillingPeriod: %v", err)
+			}
+			start, end := period.bounds(tt.now)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Fatalf("bounds = %s - %s, want %s - %s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestParseBillingPeriodDefaultsToLocalTime(t *testing.T) {
+	period, err := parseBillingPeriod(TransferBudgetConfig{})
+	if err != nil {
+		t.Fatalf("parseBillingPeriod: %v", err)
+	}
+	if period.location != time.Local || period.anchorDay != 1 {
+		t.Fatalf("period = %+v, want anchor day 1 in local time", period)
+	}
+}
@@ -0,0 +1,354 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+// calibrationGrace is how long past the probe duration a result is awaited
+const calibrationGrace = 30 * time.Second
+
+// calibrationRetry is how long to wait before retrying a failed calibration
+// when no recalibration interval is configured
+const calibrationRetry = time.Hour
+
+// AgentCapacity is the learned download capacity of an agent
+type AgentCapacity struct {
+	AgentID       string    `json:"agent_id"`
+	SustainedMbps float64   `json:"sustained_mbps"` // 0 if never measured
+	PeakMbps      float64   `json:"peak_mbps"`
+	MeasuredAt    time.Time `json:"measured_at"`
+	LastAttempt   time.Time `json:"last_attempt"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// calibrationRun is a calibration awaiting its result
+type calibrationRun struct {
+	id       string
+	started  time.Time
+	deadline time.Time
+}
+
+// calibrations tracks learned capacities and calibrations in progress. It
+// has its own lock so capacities can be read with or without s.mu held.
+type calibrations struct {
+	mu         sync.RWMutex
+	capacities map[string]*AgentCapacity
+	running    map[string]*calibrationRun
+}
+
+func newCalibrations() *calibrations {
+	return &calibrations{
+		capacities: make(map[string]*AgentCapacity),
+		running:    make(map[string]*calibrationRun),
+	}
+}
+
+// get returns a copy of an agent's capacity record
+func (c *calibrations) get(agentID string) (AgentCapacity, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	capacity, ok := c.capacities[agentID]
+	if !ok {
+		return AgentCapacity{}, false
+	}
+	return *capacity, true
+}
+
+// isRunning reports whether an agent is being calibrated
+func (c *calibrations) isRunning(agentID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.running[agentID]
+	return ok
+}
+
+// runningAgents returns the agents being calibrated
+func (c *calibrations) runningAgents() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	agentIDs := make([]string, 0, len(c.running))
+	for agentID := range c.running {
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs
+}
+
+// record returns an agent's capacity record, creating it if needed.
+// Must be called with c.mu held.
+func (c *calibrations) record(agentID string) *AgentCapacity {
+	capacity, ok := c.capacities[agentID]
+	if !ok {
+		capacity = &AgentCapacity{AgentID: agentID}
+		c.capacities[agentID] = capacity
+	}
+	return capacity
+}
+
+// ErrCalibrationBusy is returned when an agent cannot be calibrated right now
+var ErrCalibrationBusy = errors.New("agent is busy")
+
+// capacity returns the bandwidth the scheduler may give an agent: the
+// learned capacity scaled by the configured utilization, or the configured
+// max_bandwidth if the agent has not been calibrated
+func (s *Scheduler) capacity(agent AgentConfig) int64 {
+	learned, ok := s.calibrations.get(agent.ID)
+	if !ok || learned.SustainedMbps <= 0 {
+		return agent.MaxBandwidth
+	}
+	return int64(learned.SustainedMbps * s.config.Calibration.Utilization)
+}
+
+// StartCalibration asks an idle agent to measure its capacity
+func (s *Scheduler) StartCalibration(agentID string) error {
+	if _, ok := s.agentConfig(agentID); !ok {
+		return fmt.Errorf("unknown agent %s", agentID)
+	}
+	if _, connected := s.server.GetClient(agentID); !connected {
+		return fmt.Errorf("agent %s is not connected", agentID)
+	}
+	if s.server.Fenced() {
+		return ErrFenced
+	}
+
+	s.mu.RLock()
+	_, active := s.state.ActiveAgents[agentID]
+	planned := s.rotation != nil && s.rotation.planned(agentID)
+	_, ramping := s.drains[agentID]
+	draining := s.draining
+	s.mu.RUnlock()
+	if active || planned || ramping || draining {
+		return ErrCalibrationBusy
+	}
+
+	urls := s.config.Calibration.URLs
+	if len(urls) == 0 {
+		urls = s.config.URLs
+	}
+
+	run := &calibrationRun{
+		id:       uuid.New().String(),
+		started:  time.Now(),
+		deadline: time.Now().Add(s.config.Calibration.Duration + calibrationGrace),
+	}
+
+	s.calibrations.mu.Lock()
+	if _, running := s.calibrations.running[agentID]; running {
+		s.calibrations.mu.Unlock()
+		return ErrCalibrationBusy
+	}
+	s.calibrations.running[agentID] = run
+	s.calibrations.record(agentID).LastAttempt = run.started
+	s.calibrations.mu.Unlock()
+
+	cmd := protocol.CalibrateCommand{
+		CalibrationID: run.id,
+		URLs:          urls,
+		Streams:       s.config.Calibration.Streams,
+		Duration:      s.config.Calibration.Duration.String(),
+		Warmup:        s.config.Calibration.Warmup.String(),
+	}
+
+	msg, err := protocol.NewMessage(protocol.MsgTypeCalibrateCommand, agentID, cmd)
+	if err == nil {
+		err = s.server.SendToAgent(agentID, msg)
+	}
+	if err != nil {
+		s.calibrations.mu.Lock()
+		delete(s.calibrations.running, agentID)
+		s.calibrations.mu.Unlock()
+		return fmt.Errorf("failed to send calibrate command: %w", err)
+	}
+
+	s.logger.Infow("Calibrating agent capacity",
+		"agent_id", agentID,
+		"calibration_id", run.id,
+		"duration", s.config.Calibration.Duration,
+	)
+	return nil
+}
+
+// OnCalibrationResult is called when an agent reports a capacity probe
+func (s *Scheduler) OnCalibrationResult(agentID string, result *protocol.CalibrationResult) {
+	s.calibrations.mu.Lock()
+	run, ok := s.calibrations.running[agentID]
+	if !ok || run.id != result.CalibrationID {
+		s.calibrations.mu.Unlock()
+		s.logger.Warnw("Ignoring unexpected calibration result",
+			"agent_id", agentID,
+			"calibration_id", result.CalibrationID,
+		)
+		return
+	}
+	delete(s.calibrations.running, agentID)
+
+	capacity := s.calibrations.record(agentID)
+	if result.Success {
+		capacity.SustainedMbps = result.SustainedMbps
+		capacity.PeakMbps = result.PeakMbps
+		capacity.MeasuredAt = time.Now()
+		capacity.LastError = ""
+	} else {
+		capacity.LastError = result.Message
+	}
+	saved := *capacity
+	s.calibrations.mu.Unlock()
+
+	if !result.Success {
+		s.logger.Warnw("Agent calibration failed",
+			"agent_id", agentID,
+			"message", result.Message,
+		)
+	} else {
+		configured := int64(0)
+		if agent, ok := s.agentConfig(agentID); ok {
+			configured = agent.MaxBandwidth
+		}
+		s.logger.Infow("Agent capacity calibrated",
+			"agent_id", agentID,
+			"sustained_mbps", result.SustainedMbps,
+			"peak_mbps", result.PeakMbps,
+			"configured_max_bandwidth", configured,
+		)
+	}
+
+	s.saveCapacity(saved)
+}
+
+// adoptReportedCapacity takes over a capacity the agent measured for a
+// previous controller, if none has been learned here
+func (s *Scheduler) adoptReportedCapacity(agentID string, info *protocol.RegisterPayload) {
+	if info.MaxBandwidth <= 0 || info.CalibratedAt.IsZero() {
+		return
+	}
+
+	s.calibrations.mu.Lock()
+	capacity := s.calibrations.record(agentID)
+	if !capacity.MeasuredAt.Before(info.CalibratedAt) {
+		s.calibrations.mu.Unlock()
+		return
+	}
+	capacity.SustainedMbps = float64(info.MaxBandwidth)
+	capacity.MeasuredAt = info.CalibratedAt
+	saved := *capacity
+	s.calibrations.mu.Unlock()
+
+	s.logger.Infow("Using capacity reported by agent",
+		"agent_id", agentID,
+		"sustained_mbps", info.MaxBandwidth,
+		"calibrated_at", info.CalibratedAt,
+	)
+	s.saveCapacity(saved)
+}
+
+// calibrateDue expires calibrations that never reported back and starts new
+// ones for idle agents that were never measured or whose measurement is
+// older than the interval
+func (s *Scheduler) calibrateDue() {
+	if s.config.Calibration.Disabled || s.paused() || s.server.Fenced() {
+		return
+	}
+
+	now := time.Now()
+	s.calibrations.mu.Lock()
+	for agentID, run := range s.calibrations.running {
+		if now.After(run.deadline) {
+			s.logger.Warnw("Agent calibration timed out", "agent_id", agentID, "calibration_id", run.id)
+			s.calibrations.record(agentID).LastError = "timed out"
+			delete(s.calibrations.running, agentID)
+		}
+	}
+	slots := s.config.Calibration.MaxParallel - len(s.calibrations.running)
+	s.calibrations.mu.Unlock()
+
+	retry := s.config.Calibration.Interval
+	if retry <= 0 {
+		retry = calibrationRetry
+	}
+
+	for _, agentID := range s.connectedAgents() {
+		if slots <= 0 {
+			return
+		}
+
+		s.mu.RLock()
+		schedulable := s.schedulable(agentID, now)
+		s.mu.RUnlock()
+		if !schedulable {
+			continue // Cordoned agents are not probed either
+		}
+
+		capacity, _ := s.calibrations.get(agentID)
+		if now.Sub(capacity.LastAttempt) < retry && capacity.LastError != "" {
+			continue
+		}
+		measured := !capacity.MeasuredAt.IsZero()
+		if measured && (s.config.Calibration.Interval <= 0 || now.Sub(capacity.MeasuredAt) < s.config.Calibration.Interval) {
+			continue
+		}
+
+		if err := s.StartCalibration(agentID); err != nil {
+			continue
+		}
+		slots--
+	}
+}
+
+// measuredBandwidth returns the aggregated total without agents running a
+// capacity probe, whose traffic is not part of the schedule
+func (s *Scheduler) measuredBandwidth(agg AggregatedMetrics) float64 {
+	total := agg.TotalBandwidth
+	for _, agentID := range s.calibrations.runningAgents() {
+		total -= agg.AgentBreakdown[agentID]
+	}
+	return total
+}
+
+// saveCapacity persists an agent's capacity record
+func (s *Scheduler) saveCapacity(capacity AgentCapacity) {
+	if s.store == nil || !s.store.IsOpen() {
+		return
+	}
+	if err := s.store.Put(bucketCapacity, capacity.AgentID, capacity); err != nil {
+		s.logger.Warnw("Failed to persist agent capacity", "agent_id", capacity.AgentID, "error", err)
+	}
+}
+
+// restoreCapacities loads learned capacities from the store
+func (s *Scheduler) restoreCapacities() {
+	if s.store == nil {
+		return
+	}
+
+	s.calibrations.mu.Lock()
+	defer s.calibrations.mu.Unlock()
+
+	for _, agent := range s.config.Agents {
+		var saved AgentCapacity
+		found, err := s.store.Get(bucketCapacity, agent.ID, &saved)
+		if err != nil {
+			s.logger.Warnw("Failed to restore agent capacity", "agent_id", agent.ID, "error", err)
+			continue
+		}
+		if found {
+			s.calibrations.capacities[agent.ID] = &saved
+		}
+	}
+}
+
+// GetCapacities returns the learned capacity of every agent (for API)
+func (s *Scheduler) GetCapacities() map[string]AgentCapacity {
+	s.calibrations.mu.RLock()
+	defer s.calibrations.mu.RUnlock()
+
+	capacities := make(map[string]AgentCapacity, len(s.calibrations.capacities))
+	for agentID, capacity := range s.calibrations.capacities {
+		capacities[agentID] = *capacity
+	}
+	return capacities
+}
@@ -2,37 +2,147 @@ package controller
 
 import (
 	"fmt"
+	"maps"
+	"math"
 	"os"
 	"time"
 
+	"github.com/mashiro/google-bandwidth-controller/internal/bandwidth"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents controller configuration
 type Config struct {
-	Server      ServerConfig    `yaml:"server"`
-	Bandwidth   BandwidthConfig `yaml:"bandwidth"`
-	Scheduler   SchedulerConfig `yaml:"scheduler"`
-	Agents      []AgentConfig   `yaml:"agents"`
-	URLs        []string        `yaml:"download_urls"`
-	YouTubeURLs []string        `yaml:"youtube_urls"`
-	URLMix      URLMixConfig    `yaml:"url_mix"`
-	Metrics     MetricsConfig   `yaml:"metrics"`
-	Logging     LoggingConfig   `yaml:"logging"`
+	Server      ServerConfig      `yaml:"server"`
+	Bandwidth   BandwidthConfig   `yaml:"bandwidth"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+	Agents      []AgentConfig     `yaml:"agents"`
+	URLs        []string          `yaml:"download_urls"`
+	YouTubeURLs []string          `yaml:"youtube_urls"`
+	URLMix      URLMixConfig      `yaml:"url_mix"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Persistence PersistenceConfig `yaml:"persistence"`
+	Calibration CalibrationConfig `yaml:"calibration"`
+	HA          HAConfig          `yaml:"ha"`
+	Logging     LoggingConfig     `yaml:"logging"`
+
+	// Pools, parsed on top of the top-level settings (see parsePools)
+	Pools []PoolConfig `yaml:"-"`
+}
+
+// DefaultPool names the single pool made of the top-level settings when no
+// pools are configured
+const DefaultPool = "default"
+
+// PoolConfig is a group of agents scheduled independently towards its own
+// target
+type PoolConfig struct {
+	Name        string
+	Agents      []string // Agent IDs
+	Bandwidth   BandwidthConfig
+	Scheduler   SchedulerConfig
+	URLs        []string
+	YouTubeURLs []string
+	URLMix      URLMixConfig
+}
+
+// poolYAML is a pool as written in the config file. Sections it leaves out
+// are inherited from the top level, and sections it gives override only the
+// fields they set.
+type poolYAML struct {
+	Name        string    `yaml:"name"`
+	Agents      []string  `yaml:"agents"`
+	Bandwidth   yaml.Node `yaml:"bandwidth"`
+	Scheduler   yaml.Node `yaml:"scheduler"`
+	URLs        []string  `yaml:"download_urls"`
+	YouTubeURLs []string  `yaml:"youtube_urls"`
+	URLMix      yaml.Node `yaml:"url_mix"`
 }
 
 // ServerConfig contains server settings
 type ServerConfig struct {
-	Host      string `yaml:"host"`
-	WSPort    int    `yaml:"ws_port"`
-	HTTPPort  int    `yaml:"http_port"`
-	AuthToken string `yaml:"auth_token"`
+	Host            string        `yaml:"host"`
+	WSPort          int           `yaml:"ws_port"`
+	HTTPPort        int           `yaml:"http_port"`
+	AuthToken       string        `yaml:"auth_token"`
+	SendQueueSize   int           `yaml:"send_queue_size"`    // Max pending messages per agent
+	SendQueueMaxLag time.Duration `yaml:"send_queue_max_lag"` // Wait after which an agent is marked degraded
+
+	APITokens []APITokenConfig `yaml:"api_tokens"` // Named operator tokens; auth_token is accepted as "admin"
+}
+
+// AdminPrincipal is the name the API records for callers using auth_token
+const AdminPrincipal = "admin"
+
+// APITokenConfig is an operator's token for the HTTP API. Actions taken
+// with it are recorded under its name.
+type APITokenConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
 }
 
 // BandwidthConfig contains bandwidth target settings
 type BandwidthConfig struct {
-	TargetGbps float64 `yaml:"target_gbps"`
-	Tolerance  float64 `yaml:"tolerance"`
+	TargetGbps float64              `yaml:"target_gbps"` // Default target outside schedule windows
+	Tolerance  float64              `yaml:"tolerance"`
+	Mode       string               `yaml:"mode"` // rate, p95 to steer the month's 95th percentile, or volume
+	Schedule   TargetScheduleConfig `yaml:"schedule"`
+	P95        P95Config            `yaml:"p95"`
+	Volume     VolumeConfig         `yaml:"volume"`
+}
+
+// Target modes
+const (
+	// TargetModeRate holds the total bandwidth at the target
+	TargetModeRate = "rate"
+	// TargetModeP95 lands the 95th percentile of the billing month's
+	// 5-minute averages at the target, bursting only as often as needed
+	TargetModeP95 = "p95"
+	// TargetModeVolume delivers a number of bytes per day or week, at the
+	// rate the bytes left need in the time left
+	TargetModeVolume = "volume"
+)
+
+// Volume periods
+const (
+	VolumePeriodDay  = "day"
+	VolumePeriodWeek = "week" // Starts on Monday
+)
+
+// VolumeConfig tunes the volume target mode
+type VolumeConfig struct {
+	TargetTB float64 `yaml:"target_tb"` // Bytes to deliver each period, in TB
+	Period   string  `yaml:"period"`    // day or week
+	Timezone string  `yaml:"timezone"`  // IANA name periods start in; empty = local time
+	MinGbps  float64 `yaml:"min_gbps"`  // Lowest rate, also once the volume is delivered
+	MaxGbps  float64 `yaml:"max_gbps"`  // Highest rate; 0 = target_gbps
+}
+
+// P95Config tunes the p95 target mode
+type P95Config struct {
+	AnchorDay    int           `yaml:"anchor_day"`    // Day of the month the billing month starts, 1-28; 0 = 1
+	Timezone     string        `yaml:"timezone"`      // IANA name; empty = local time
+	BaseGbps     float64       `yaml:"base_gbps"`     // Target between bursts
+	Headroom     float64       `yaml:"headroom"`      // Bursts aim this far above the target, as a fraction
+	SafetyMargin float64       `yaml:"safety_margin"` // Samples at the target to collect beyond those needed, as a fraction
+	Block        time.Duration `yaml:"block"`         // Bursts are decided for blocks this long
+}
+
+// TargetScheduleConfig varies the bandwidth target by time of day and weekday
+type TargetScheduleConfig struct {
+	Timezone   string               `yaml:"timezone"`   // IANA name, e.g. "Asia/Tokyo"; empty = local time
+	Transition time.Duration        `yaml:"transition"` // Ramp between windows over this period; 0 = switch instantly
+	Windows    []TargetWindowConfig `yaml:"windows"`    // First matching window wins
+}
+
+// TargetWindowConfig is a recurring window with its own target
+type TargetWindowConfig struct {
+	Name       string   `yaml:"name"`
+	Days       []string `yaml:"days"`  // mon..sun; empty = every day
+	Start      string   `yaml:"start"` // HH:MM; empty = 00:00
+	End        string   `yaml:"end"`   // HH:MM; empty = 24:00. Before start wraps past midnight
+	TargetGbps float64  `yaml:"target_gbps"`
+	Tolerance  float64  `yaml:"tolerance"` // 0 = bandwidth.tolerance
 }
 
 // SchedulerConfig contains scheduling parameters
@@ -47,6 +157,247 @@ type SchedulerConfig struct {
 	RampDownDuration     time.Duration `yaml:"ramp_down_duration"`
 	TimingRandomness     float64       `yaml:"timing_randomness"`
 	BandwidthRandomness  float64       `yaml:"bandwidth_randomness"`
+	ReconcileWindow      time.Duration `yaml:"reconcile_window"` // Wait for agents to reconnect before the first rotation
+	AdoptionPolicy       string        `yaml:"adoption_policy"`  // adopt or stop jobs agents report when reconnecting
+	DrainTimeout         time.Duration `yaml:"drain_timeout"`    // Wait for stop acknowledgements on shutdown
+	RotationMode         string        `yaml:"rotation_mode"`    // sequential or overlap
+	OverlapTimeout       time.Duration `yaml:"overlap_timeout"`  // Longest overlap after ramp-up before departing agents are stopped anyway
+
+	ConcurrencyProfile ConcurrencyProfileConfig `yaml:"concurrency_profile"`
+	Control            ControlConfig            `yaml:"control"`
+	ThroughputModel    ThroughputModelConfig    `yaml:"throughput_model"`
+	Regions            RegionsConfig            `yaml:"regions"`
+	Topology           TopologyConfig           `yaml:"topology"`
+	TransferBudget     TransferBudgetConfig     `yaml:"transfer_budget"` // Bytes the pool may download per billing period
+}
+
+// TransferBudgetConfig caps the bytes downloaded in a monthly billing period
+type TransferBudgetConfig struct {
+	LimitGB   float64 `yaml:"limit_gb"`   // 0 = unlimited
+	AnchorDay int     `yaml:"anchor_day"` // Day of the month the period starts, 1-28; 0 = 1
+	Timezone  string  `yaml:"timezone"`   // IANA name; empty = local time
+}
+
+// Limited reports whether a budget is set
+func (b TransferBudgetConfig) Limited() bool {
+	return b.LimitGB > 0
+}
+
+// TopologyConfig constrains which agents are active together, by label
+type TopologyConfig struct {
+	Rules []TopologyRule `yaml:"rules"` // Unset = none; agents outside an overused region are favoured instead
+}
+
+// TopologyRule limits the active agents that match Selector, grouped by the
+// value of their Key label. Agents without the label are not constrained.
+type TopologyRule struct {
+	Key       string            `yaml:"key" json:"key"`
+	Selector  map[string]string `yaml:"selector,omitempty" json:"selector,omitempty"`     // Labels an agent must have for the rule to apply
+	MaxActive int               `yaml:"max_active,omitempty" json:"max_active,omitempty"` // Active agents per value, 0 = no limit
+	MaxSkew   int               `yaml:"max_skew,omitempty" json:"max_skew,omitempty"`     // Largest difference in active agents between values, 0 = no limit
+}
+
+// Matches reports whether an agent is subject to the rule
+func (r TopologyRule) Matches(agent AgentConfig) bool {
+	if agent.Label(r.Key) == "" {
+		return false
+	}
+	for key, value := range r.Selector {
+		if agent.Label(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// RegionsConfig constrains how the target is spread across agent regions
+type RegionsConfig struct {
+	MinActive int                    `yaml:"min_active"` // Distinct regions with an active agent
+	Quotas    map[string]RegionQuota `yaml:"quotas"`     // By region name
+}
+
+// Constrained reports whether any region constraint is configured
+func (c RegionsConfig) Constrained() bool {
+	return c.MinActive > 1 || len(c.Quotas) > 0
+}
+
+// RegionQuota bounds the bandwidth of a region. Shares are fractions of the
+// target and absolute limits are in Mbps; where both are set the stricter
+// one applies. Zero means no limit.
+type RegionQuota struct {
+	MinShare float64 `yaml:"min_share"`
+	MaxShare float64 `yaml:"max_share"`
+	MinMbps  int64   `yaml:"min_mbps"`
+	MaxMbps  int64   `yaml:"max_mbps"`
+}
+
+// Limits returns the quota's bounds in Mbps at the given target. Max is
+// math.MaxInt64 if the region has no upper limit.
+func (q RegionQuota) Limits(target float64) bandwidth.Limits {
+	limits := bandwidth.Limits{
+		Min: bandwidth.Max64(int64(q.MinShare*target), q.MinMbps),
+		Max: math.MaxInt64,
+	}
+	if q.MaxShare > 0 {
+		limits.Max = int64(q.MaxShare * target)
+	}
+	if q.MaxMbps > 0 {
+		limits.Max = bandwidth.Min64(limits.Max, q.MaxMbps)
+	}
+	// A minimum share can outgrow an absolute maximum as the target rises
+	limits.Min = bandwidth.Min64(limits.Min, limits.Max)
+	return limits
+}
+
+// ThroughputModelConfig tunes the model of how much of its commanded
+// bandwidth each agent, and each agent and URL pair, actually delivers
+type ThroughputModelConfig struct {
+	Disabled      bool          `yaml:"disabled"`
+	HalfLife      time.Duration `yaml:"half_life"`      // Age at which an observation counts half as much
+	MinSamples    int           `yaml:"min_samples"`    // Observations before an estimate is used
+	MinEfficiency float64       `yaml:"min_efficiency"` // Lowest efficiency the scheduler assumes
+	Warmup        time.Duration `yaml:"warmup"`         // Ignore commands younger than this
+}
+
+// Rotation modes
+const (
+	// RotationModeSequential ramps departing agents down before new ones start
+	RotationModeSequential = "sequential"
+	// RotationModeOverlap starts new agents first and stops departing ones
+	// as the newcomers' throughput arrives
+	RotationModeOverlap = "overlap"
+)
+
+// Bandwidth control modes
+const (
+	ControlModePID   = "pid"
+	ControlModeBoost = "boost"
+)
+
+// ControlConfig tunes the closed-loop control of total bandwidth
+type ControlConfig struct {
+	Mode              string  `yaml:"mode"`                // pid, or boost for the legacy top-up jobs
+	Kp                float64 `yaml:"kp"`                  // Proportional gain
+	Ki                float64 `yaml:"ki"`                  // Integral gain, per second
+	Kd                float64 `yaml:"kd"`                  // Derivative gain, in seconds
+	MaxCorrection     float64 `yaml:"max_correction"`      // Largest correction as a fraction of the target
+	Deadband          float64 `yaml:"deadband"`            // Relative change below which agents are not adjusted
+	MinAgentBandwidth int64   `yaml:"min_agent_bandwidth"` // Floor when correcting downwards, Mbps
+
+	// Boost mode
+	MaxBoostPerAgent int64         `yaml:"max_boost_per_agent"` // Total boost bandwidth per agent, Mbps
+	BoostSettle      time.Duration `yaml:"boost_settle"`        // Wait after a boost before adding another
+}
+
+// Concurrency profile types
+const (
+	ProfileConstant   = "constant"
+	ProfileSine       = "sine"
+	ProfilePiecewise  = "piecewise"
+	ProfileRandomWalk = "random_walk"
+)
+
+// ConcurrencyProfileConfig selects and parameterizes the concurrency profile
+type ConcurrencyProfileConfig struct {
+	Type       string                 `yaml:"type"` // constant, sine, piecewise or random_walk
+	Constant   ConstantProfileConfig  `yaml:"constant"`
+	Sine       SineProfileConfig      `yaml:"sine"`
+	Piecewise  PiecewiseProfileConfig `yaml:"piecewise"`
+	RandomWalk RandomWalkConfig       `yaml:"random_walk"`
+}
+
+// ConstantProfileConfig configures the constant profile
+type ConstantProfileConfig struct {
+	Count int `yaml:"count"` // 0 = halfway between min and max concurrent
+}
+
+// SineProfileConfig configures the multi-sine profile
+type SineProfileConfig struct {
+	Waves []SineWaveConfig `yaml:"waves"` // Empty = built-in pattern
+}
+
+// SineWaveConfig is one wave of the sine profile
+type SineWaveConfig struct {
+	Period time.Duration `yaml:"period"`
+	Weight float64       `yaml:"weight"`
+	Phase  float64       `yaml:"phase"` // Radians
+}
+
+// PiecewiseProfileConfig configures a curve given as a table of points
+type PiecewiseProfileConfig struct {
+	File          string               `yaml:"file"` // CSV or YAML table; alternative to points
+	Points        []ProfilePointConfig `yaml:"points"`
+	Period        time.Duration        `yaml:"period"`        // Repeat the curve; 0 = hold the last value
+	Interpolation string               `yaml:"interpolation"` // linear (default) or step
+}
+
+// ProfilePointConfig is a point of a piecewise curve
+type ProfilePointConfig struct {
+	At          string  `yaml:"at"` // Offset from start, e.g. "90m" or seconds
+	Concurrency float64 `yaml:"concurrency"`
+}
+
+// RandomWalkConfig configures the bounded random walk profile
+type RandomWalkConfig struct {
+	StepInterval time.Duration `yaml:"step_interval"`
+	MaxStep      float64       `yaml:"max_step"`  // Largest change per step, in agents
+	Reversion    float64       `yaml:"reversion"` // Pull towards the middle per step, 0..1
+}
+
+// Build creates the configured concurrency profile
+func (p ConcurrencyProfileConfig) Build() (bandwidth.ConcurrencyProfile, error) {
+	switch p.Type {
+	case ProfileConstant:
+		return &bandwidth.ConstantProfile{Count: p.Constant.Count}, nil
+
+	case ProfileSine:
+		waves := make([]bandwidth.SineWave, 0, len(p.Sine.Waves))
+		for i, wave := range p.Sine.Waves {
+			if wave.Period <= 0 {
+				return nil, fmt.Errorf("sine wave %d: period must be positive", i+1)
+			}
+			waves = append(waves, bandwidth.SineWave{
+				Period: wave.Period,
+				Weight: wave.Weight,
+				Phase:  wave.Phase,
+			})
+		}
+		return &bandwidth.SineProfile{Waves: waves}, nil
+
+	case ProfilePiecewise:
+		var points []bandwidth.ProfilePoint
+		if p.Piecewise.File != "" {
+			loaded, err := bandwidth.LoadProfilePoints(p.Piecewise.File)
+			if err != nil {
+				return nil, err
+			}
+			points = loaded
+		}
+		for i, point := range p.Piecewise.Points {
+			at, err := bandwidth.ParseOffset(point.At)
+			if err != nil {
+				return nil, fmt.Errorf("piecewise point %d: %w", i+1, err)
+			}
+			points = append(points, bandwidth.ProfilePoint{At: at, Concurrency: point.Concurrency})
+		}
+
+		switch p.Piecewise.Interpolation {
+		case "", "linear", "step":
+		default:
+			return nil, fmt.Errorf("piecewise interpolation must be linear or step")
+		}
+		return bandwidth.NewPiecewiseProfile(points, p.Piecewise.Period, p.Piecewise.Interpolation == "step")
+
+	case ProfileRandomWalk:
+		return &bandwidth.RandomWalkProfile{
+			StepInterval: p.RandomWalk.StepInterval,
+			MaxStep:      p.RandomWalk.MaxStep,
+			Reversion:    p.RandomWalk.Reversion,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown concurrency profile type %q", p.Type)
+	}
 }
 
 // AgentConfig contains agent pool configuration
@@ -56,6 +407,33 @@ type AgentConfig struct {
 	Name         string `yaml:"name"`
 	MaxBandwidth int64  `yaml:"max_bandwidth"` // Mbps
 	Region       string `yaml:"region,omitempty"`
+
+	Labels      map[string]string         `yaml:"labels,omitempty"`      // e.g. provider, datacenter, rack
+	Maintenance []MaintenanceWindowConfig `yaml:"maintenance,omitempty"` // Periods the agent is cordoned and drained
+
+	TransferBudget TransferBudgetConfig `yaml:"transfer_budget,omitempty"` // Bytes the agent may download per billing period
+}
+
+// MaintenanceWindowConfig is a recurring period during which an agent is
+// taken out of rotation
+type MaintenanceWindowConfig struct {
+	Name     string   `yaml:"name"`
+	Days     []string `yaml:"days"`     // mon..sun; empty = every day
+	Start    string   `yaml:"start"`    // HH:MM; empty = 00:00
+	End      string   `yaml:"end"`      // HH:MM; empty = 24:00. Before start wraps past midnight
+	Timezone string   `yaml:"timezone"` // IANA name; empty = local time
+}
+
+// Label returns the value of a label. The region is also the "region" label
+// unless labels set it.
+func (a AgentConfig) Label(key string) string {
+	if value, ok := a.Labels[key]; ok {
+		return value
+	}
+	if key == "region" {
+		return a.Region
+	}
+	return ""
 }
 
 // MetricsConfig contains metrics settings
@@ -65,6 +443,37 @@ type MetricsConfig struct {
 	AggregationWindow  string `yaml:"aggregation_window"`
 }
 
+// PersistenceConfig contains state persistence settings
+type PersistenceConfig struct {
+	Disabled         bool          `yaml:"disabled"`
+	Path             string        `yaml:"path"`              // bbolt database file
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"` // How often scheduler state is saved
+}
+
+// CalibrationConfig controls measurement of agent capacity. Agents are
+// calibrated when they first enroll and, if an interval is set, again once
+// their measurement is older than that.
+type CalibrationConfig struct {
+	Disabled    bool          `yaml:"disabled"`
+	Interval    time.Duration `yaml:"interval"`     // Recalibrate idle agents this often; 0 = only on enrollment
+	Duration    time.Duration `yaml:"duration"`     // Probe length, including the warm-up
+	Warmup      time.Duration `yaml:"warmup"`       // Start of the probe left out of the result
+	Streams     int           `yaml:"streams"`      // Parallel unthrottled downloads
+	URLs        []string      `yaml:"urls"`         // Empty = download_urls
+	Utilization float64       `yaml:"utilization"`  // Fraction of the learned capacity the scheduler may use
+	MaxParallel int           `yaml:"max_parallel"` // Agents probed at the same time
+}
+
+// HAConfig contains active/standby high availability settings
+type HAConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	InstanceID    string        `yaml:"instance_id"`   // Defaults to the hostname
+	LeaseBackend  string        `yaml:"lease_backend"` // Currently only "file"
+	LeasePath     string        `yaml:"lease_path"`
+	LeaseTTL      time.Duration `yaml:"lease_ttl"`
+	RenewInterval time.Duration `yaml:"renew_interval"`
+}
+
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
@@ -78,6 +487,12 @@ type URLMixConfig struct {
 	YtDlpPercent int `yaml:"ytdlp_percent"` // Percentage of yt-dlp tasks (0-100)
 }
 
+// Adoption policies for jobs reported by reconnecting agents
+const (
+	AdoptionPolicyAdopt = "adopt" // Take running jobs over into the schedule
+	AdoptionPolicyStop  = "stop"  // Stop running jobs and start clean
+)
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -100,12 +515,33 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Server.HTTPPort == 0 {
 		config.Server.HTTPPort = 9090
 	}
+	if config.Server.SendQueueSize == 0 {
+		config.Server.SendQueueSize = 256
+	}
+	if config.Server.SendQueueMaxLag == 0 {
+		config.Server.SendQueueMaxLag = 10 * time.Second
+	}
 	if config.Bandwidth.TargetGbps == 0 {
 		config.Bandwidth.TargetGbps = 10.0
 	}
 	if config.Bandwidth.Tolerance == 0 {
 		config.Bandwidth.Tolerance = 0.15
 	}
+	if config.Bandwidth.Mode == "" {
+		config.Bandwidth.Mode = TargetModeRate
+	}
+	if config.Bandwidth.P95.Headroom == 0 {
+		config.Bandwidth.P95.Headroom = 0.1
+	}
+	if config.Bandwidth.P95.SafetyMargin == 0 {
+		config.Bandwidth.P95.SafetyMargin = 0.1
+	}
+	if config.Bandwidth.P95.Block == 0 {
+		config.Bandwidth.P95.Block = time.Hour
+	}
+	if config.Bandwidth.Volume.Period == "" {
+		config.Bandwidth.Volume.Period = VolumePeriodDay
+	}
 	if config.Scheduler.MinConcurrent == 0 {
 		config.Scheduler.MinConcurrent = 2
 	}
@@ -136,6 +572,69 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Scheduler.BandwidthRandomness == 0 {
 		config.Scheduler.BandwidthRandomness = 0.25
 	}
+	if config.Scheduler.ReconcileWindow == 0 {
+		config.Scheduler.ReconcileWindow = 15 * time.Second
+	}
+	if config.Scheduler.AdoptionPolicy == "" {
+		config.Scheduler.AdoptionPolicy = AdoptionPolicyAdopt
+	}
+	if config.Scheduler.DrainTimeout == 0 {
+		config.Scheduler.DrainTimeout = 30 * time.Second
+	}
+	if config.Scheduler.RotationMode == "" {
+		config.Scheduler.RotationMode = RotationModeSequential
+	}
+	if config.Scheduler.OverlapTimeout == 0 {
+		config.Scheduler.OverlapTimeout = 60 * time.Second
+	}
+	if config.Scheduler.Control.Mode == "" {
+		config.Scheduler.Control.Mode = ControlModePID
+	}
+	if config.Scheduler.Control.Kp == 0 {
+		config.Scheduler.Control.Kp = 0.4
+	}
+	if config.Scheduler.Control.Ki == 0 {
+		config.Scheduler.Control.Ki = 0.02
+	}
+	if config.Scheduler.Control.MaxCorrection == 0 {
+		config.Scheduler.Control.MaxCorrection = 0.5
+	}
+	if config.Scheduler.Control.Deadband == 0 {
+		config.Scheduler.Control.Deadband = 0.05
+	}
+	if config.Scheduler.Control.MinAgentBandwidth == 0 {
+		config.Scheduler.Control.MinAgentBandwidth = 50
+	}
+	if config.Scheduler.Control.MaxBoostPerAgent == 0 {
+		config.Scheduler.Control.MaxBoostPerAgent = 500
+	}
+	if config.Scheduler.Control.BoostSettle == 0 {
+		config.Scheduler.Control.BoostSettle = 30 * time.Second
+	}
+	if config.Scheduler.ThroughputModel.HalfLife == 0 {
+		config.Scheduler.ThroughputModel.HalfLife = 30 * time.Minute
+	}
+	if config.Scheduler.ThroughputModel.MinSamples == 0 {
+		config.Scheduler.ThroughputModel.MinSamples = 12
+	}
+	if config.Scheduler.ThroughputModel.MinEfficiency == 0 {
+		config.Scheduler.ThroughputModel.MinEfficiency = 0.25
+	}
+	if config.Scheduler.ThroughputModel.Warmup == 0 {
+		config.Scheduler.ThroughputModel.Warmup = 30 * time.Second
+	}
+	if config.Scheduler.ConcurrencyProfile.Type == "" {
+		config.Scheduler.ConcurrencyProfile.Type = ProfileSine
+	}
+	if config.Scheduler.ConcurrencyProfile.RandomWalk.StepInterval == 0 {
+		config.Scheduler.ConcurrencyProfile.RandomWalk.StepInterval = time.Minute
+	}
+	if config.Scheduler.ConcurrencyProfile.RandomWalk.MaxStep == 0 {
+		config.Scheduler.ConcurrencyProfile.RandomWalk.MaxStep = 1
+	}
+	if config.Scheduler.ConcurrencyProfile.RandomWalk.Reversion == 0 {
+		config.Scheduler.ConcurrencyProfile.RandomWalk.Reversion = 0.1
+	}
 	if config.Metrics.CollectionInterval == "" {
 		config.Metrics.CollectionInterval = "5s"
 	}
@@ -145,6 +644,43 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Metrics.AggregationWindow == "" {
 		config.Metrics.AggregationWindow = "1m"
 	}
+	if config.Persistence.Path == "" {
+		config.Persistence.Path = "/var/lib/bandwidth-controller/controller.db"
+	}
+	if config.Persistence.SnapshotInterval == 0 {
+		config.Persistence.SnapshotInterval = 30 * time.Second
+	}
+	if config.Calibration.Duration == 0 {
+		config.Calibration.Duration = 20 * time.Second
+	}
+	if config.Calibration.Warmup == 0 {
+		config.Calibration.Warmup = 5 * time.Second
+	}
+	if config.Calibration.Streams == 0 {
+		config.Calibration.Streams = 8
+	}
+	if config.Calibration.Utilization == 0 {
+		config.Calibration.Utilization = 0.9
+	}
+	if config.Calibration.MaxParallel == 0 {
+		config.Calibration.MaxParallel = 1
+	}
+	if config.HA.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		config.HA.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if config.HA.LeaseBackend == "" {
+		config.HA.LeaseBackend = "file"
+	}
+	if config.HA.LeasePath == "" {
+		config.HA.LeasePath = "/var/lib/bandwidth-controller/leader.lease"
+	}
+	if config.HA.LeaseTTL == 0 {
+		config.HA.LeaseTTL = 15 * time.Second
+	}
+	if config.HA.RenewInterval == 0 {
+		config.HA.RenewInterval = 5 * time.Second
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
@@ -158,14 +694,170 @@ func LoadConfig(path string) (*Config, error) {
 		config.URLMix.YtDlpPercent = 50
 	}
 
+	pools, err := parsePools(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	config.Pools = pools
+
 	return &config, nil
 }
 
+// parsePools decodes the pools of a config file on top of the top-level
+// settings, which must already have their defaults
+func parsePools(data []byte, config *Config) ([]PoolConfig, error) {
+	var raw struct {
+		Pools []poolYAML `yaml:"pools"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse pools: %w", err)
+	}
+
+	pools := make([]PoolConfig, 0, len(raw.Pools))
+	for _, p := range raw.Pools {
+		pool := PoolConfig{
+			Name:        p.Name,
+			Agents:      p.Agents,
+			Bandwidth:   config.Bandwidth,
+			Scheduler:   config.Scheduler,
+			URLs:        config.URLs,
+			YouTubeURLs: config.YouTubeURLs,
+			URLMix:      config.URLMix,
+		}
+		// Decoding adds to maps in place, so the pool needs its own
+		pool.Scheduler.Regions.Quotas = maps.Clone(config.Scheduler.Regions.Quotas)
+
+		if p.URLs != nil {
+			pool.URLs = p.URLs
+		}
+		if p.YouTubeURLs != nil {
+			pool.YouTubeURLs = p.YouTubeURLs
+		}
+
+		sections := []struct {
+			node *yaml.Node
+			out  interface{}
+		}{
+			{&p.Bandwidth, &pool.Bandwidth},
+			{&p.Scheduler, &pool.Scheduler},
+			{&p.URLMix, &pool.URLMix},
+		}
+		for _, section := range sections {
+			if section.node.IsZero() {
+				continue
+			}
+			if err := section.node.Decode(section.out); err != nil {
+				return nil, fmt.Errorf("failed to parse pool %q: %w", p.Name, err)
+			}
+		}
+
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// PoolConfigs returns the configured pools, or a single default pool made
+// of the top-level settings and every agent
+func (c *Config) PoolConfigs() []PoolConfig {
+	if len(c.Pools) > 0 {
+		return c.Pools
+	}
+
+	agentIDs := make([]string, len(c.Agents))
+	for i, agent := range c.Agents {
+		agentIDs[i] = agent.ID
+	}
+	return []PoolConfig{{
+		Name:        DefaultPool,
+		Agents:      agentIDs,
+		Bandwidth:   c.Bandwidth,
+		Scheduler:   c.Scheduler,
+		URLs:        c.URLs,
+		YouTubeURLs: c.YouTubeURLs,
+		URLMix:      c.URLMix,
+	}}
+}
+
+// ForPool returns a copy of the configuration scoped to a pool: the pool's
+// settings in place of the top-level ones, and only its agents
+func (c *Config) ForPool(pool PoolConfig) *Config {
+	scoped := *c
+	scoped.Bandwidth = pool.Bandwidth
+	scoped.Scheduler = pool.Scheduler
+	scoped.URLs = pool.URLs
+	scoped.YouTubeURLs = pool.YouTubeURLs
+	scoped.URLMix = pool.URLMix
+	scoped.Pools = nil
+
+	scoped.Agents = make([]AgentConfig, 0, len(pool.Agents))
+	for _, agent := range c.Agents {
+		if containsString(pool.Agents, agent.ID) {
+			scoped.Agents = append(scoped.Agents, agent)
+		}
+	}
+	return &scoped
+}
+
+// validatePools checks pool names and membership, and each pool's settings
+func (c *Config) validatePools() error {
+	agentIDs := make(map[string]bool, len(c.Agents))
+	for _, agent := range c.Agents {
+		agentIDs[agent.ID] = true
+	}
+
+	names := make(map[string]bool)
+	members := make(map[string]string)
+	for _, pool := range c.Pools {
+		if pool.Name == "" {
+			return fmt.Errorf("pool name is required")
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate pool name: %s", pool.Name)
+		}
+		names[pool.Name] = true
+
+		if len(pool.Agents) == 0 {
+			return fmt.Errorf("pool %s has no agents", pool.Name)
+		}
+		for _, agentID := range pool.Agents {
+			if !agentIDs[agentID] {
+				return fmt.Errorf("pool %s: unknown agent %s", pool.Name, agentID)
+			}
+			if other, ok := members[agentID]; ok {
+				return fmt.Errorf("agent %s is in pools %s and %s", agentID, other, pool.Name)
+			}
+			members[agentID] = pool.Name
+		}
+
+		if err := c.ForPool(pool).Validate(); err != nil {
+			return fmt.Errorf("pool %s: %w", pool.Name, err)
+		}
+	}
+
+	return nil
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
 	if c.Server.AuthToken == "" {
 		return fmt.Errorf("server.auth_token is required")
 	}
+	tokenNames := map[string]bool{AdminPrincipal: true}
+	tokens := map[string]bool{c.Server.AuthToken: true}
+	for i, t := range c.Server.APITokens {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("server.api_tokens[%d]: name and token are required", i)
+		}
+		if tokenNames[t.Name] {
+			return fmt.Errorf("server.api_tokens: duplicate name %s", t.Name)
+		}
+		if tokens[t.Token] {
+			return fmt.Errorf("server.api_tokens: token of %s is not unique", t.Name)
+		}
+		tokenNames[t.Name] = true
+		tokens[t.Token] = true
+	}
 	if len(c.Agents) == 0 {
 		return fmt.Errorf("at least one agent must be configured")
 	}
@@ -178,6 +870,85 @@ func (c *Config) Validate() error {
 	if c.Scheduler.MinConcurrent > len(c.Agents) {
 		return fmt.Errorf("scheduler.min_concurrent cannot be greater than number of agents")
 	}
+	if c.Scheduler.AdoptionPolicy != AdoptionPolicyAdopt && c.Scheduler.AdoptionPolicy != AdoptionPolicyStop {
+		return fmt.Errorf("scheduler.adoption_policy must be %q or %q", AdoptionPolicyAdopt, AdoptionPolicyStop)
+	}
+	if c.Scheduler.RotationMode != RotationModeSequential && c.Scheduler.RotationMode != RotationModeOverlap {
+		return fmt.Errorf("scheduler.rotation_mode must be %q or %q", RotationModeSequential, RotationModeOverlap)
+	}
+	if c.Scheduler.Control.Mode != ControlModePID && c.Scheduler.Control.Mode != ControlModeBoost {
+		return fmt.Errorf("scheduler.control.mode must be %q or %q", ControlModePID, ControlModeBoost)
+	}
+	if c.Scheduler.Control.Kp < 0 || c.Scheduler.Control.Ki < 0 || c.Scheduler.Control.Kd < 0 {
+		return fmt.Errorf("scheduler.control gains must not be negative")
+	}
+	if e := c.Scheduler.ThroughputModel.MinEfficiency; e <= 0 || e > 1 {
+		return fmt.Errorf("scheduler.throughput_model.min_efficiency must be between 0 and 1")
+	}
+	if err := c.validateRegions(); err != nil {
+		return fmt.Errorf("scheduler.regions: %w", err)
+	}
+	for i, rule := range c.Scheduler.Topology.Rules {
+		if rule.Key == "" {
+			return fmt.Errorf("scheduler.topology.rules[%d]: key is required", i)
+		}
+		if rule.MaxActive < 0 || rule.MaxSkew < 0 {
+			return fmt.Errorf("scheduler.topology.rules[%d]: limits must not be negative", i)
+		}
+		if rule.MaxActive == 0 && rule.MaxSkew == 0 {
+			return fmt.Errorf("scheduler.topology.rules[%d]: max_active or max_skew is required", i)
+		}
+	}
+	if _, err := parseBillingPeriod(c.Scheduler.TransferBudget); err != nil {
+		return fmt.Errorf("scheduler.transfer_budget: %w", err)
+	}
+	if _, err := NewTargetSchedule(c.Bandwidth); err != nil {
+		return fmt.Errorf("bandwidth.schedule: %w", err)
+	}
+	switch c.Bandwidth.Mode {
+	case TargetModeRate:
+	case TargetModeP95:
+		if err := c.Bandwidth.P95.validate(c.Bandwidth.TargetGbps); err != nil {
+			return err
+		}
+		// Pacing would hold bursts below the target, so the pool would
+		// keep bursting for the rest of the month
+		if c.Scheduler.TransferBudget.Limited() {
+			return fmt.Errorf("bandwidth.mode %s cannot be combined with scheduler.transfer_budget", TargetModeP95)
+		}
+	case TargetModeVolume:
+		if _, err := parseVolumeTarget(c.Bandwidth); err != nil {
+			return fmt.Errorf("bandwidth.volume: %w", err)
+		}
+	default:
+		return fmt.Errorf("bandwidth.mode must be %s, %s or %s", TargetModeRate, TargetModeP95, TargetModeVolume)
+	}
+	if _, err := c.Scheduler.ConcurrencyProfile.Build(); err != nil {
+		return fmt.Errorf("scheduler.concurrency_profile: %w", err)
+	}
+	if r := c.Scheduler.ConcurrencyProfile.RandomWalk.Reversion; r < 0 || r > 1 {
+		return fmt.Errorf("scheduler.concurrency_profile.random_walk.reversion must be between 0 and 1")
+	}
+
+	if c.Calibration.Warmup >= c.Calibration.Duration {
+		return fmt.Errorf("calibration.warmup must be shorter than calibration.duration")
+	}
+	if c.Calibration.Utilization <= 0 || c.Calibration.Utilization > 1 {
+		return fmt.Errorf("calibration.utilization must be between 0 and 1")
+	}
+
+	if c.HA.Enabled {
+		if c.HA.LeaseBackend != "file" {
+			return fmt.Errorf("ha.lease_backend %q is not supported", c.HA.LeaseBackend)
+		}
+		if c.HA.RenewInterval >= c.HA.LeaseTTL {
+			return fmt.Errorf("ha.renew_interval must be shorter than ha.lease_ttl")
+		}
+	}
+
+	if err := c.validatePools(); err != nil {
+		return err
+	}
 
 	// Validate agents
 	agentIDs := make(map[string]bool)
@@ -193,6 +964,54 @@ func (c *Config) Validate() error {
 		if agent.MaxBandwidth <= 0 {
 			return fmt.Errorf("agent %s must have max_bandwidth > 0", agent.ID)
 		}
+		for i, wc := range agent.Maintenance {
+			if _, err := parseMaintenanceWindow(wc); err != nil {
+				return fmt.Errorf("agent %s: maintenance window %d: %w", agent.ID, i+1, err)
+			}
+		}
+		if _, err := parseBillingPeriod(agent.TransferBudget); err != nil {
+			return fmt.Errorf("agent %s: transfer_budget: %w", agent.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// validateRegions checks that region quotas are consistent and name regions
+// agents are in
+func (c *Config) validateRegions() error {
+	regions := make(map[string]bool)
+	for _, agent := range c.Agents {
+		if agent.Region != "" {
+			regions[agent.Region] = true
+		}
+	}
+
+	if c.Scheduler.Regions.MinActive > len(regions) {
+		return fmt.Errorf("min_active is %d but agents are in %d regions", c.Scheduler.Regions.MinActive, len(regions))
+	}
+
+	minShares := 0.0
+	for region, quota := range c.Scheduler.Regions.Quotas {
+		if !regions[region] {
+			return fmt.Errorf("no agent is in region %q", region)
+		}
+		if quota.MinShare < 0 || quota.MinShare > 1 || quota.MaxShare < 0 || quota.MaxShare > 1 {
+			return fmt.Errorf("%s: shares must be between 0 and 1", region)
+		}
+		if quota.MinMbps < 0 || quota.MaxMbps < 0 {
+			return fmt.Errorf("%s: limits must not be negative", region)
+		}
+		if quota.MaxShare > 0 && quota.MinShare > quota.MaxShare {
+			return fmt.Errorf("%s: min_share exceeds max_share", region)
+		}
+		if quota.MaxMbps > 0 && quota.MinMbps > quota.MaxMbps {
+			return fmt.Errorf("%s: min_mbps exceeds max_mbps", region)
+		}
+		minShares += quota.MinShare
+	}
+	if minShares > 1 {
+		return fmt.Errorf("min_share values add up to more than 1")
 	}
 
 	return nil
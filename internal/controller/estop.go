@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+// Store key for the emergency stop fence. It covers every pool, so the key
+// is not prefixed with a pool.
+const keyFence = "fence"
+
+// Emergency stop confirmation of an agent
+const (
+	StopPending     = "pending"     // Stop sent, awaiting acknowledgement
+	StopConfirmed   = "confirmed"   // Agent reported everything stopped
+	StopFailed      = "failed"      // Stop could not be sent, or the agent failed to stop
+	StopUnconfirmed = "unconfirmed" // No acknowledgement before the drain timeout
+)
+
+var (
+	// ErrFenced is returned for work refused while the emergency stop is engaged
+	ErrFenced = errors.New("emergency stop engaged")
+	// ErrNotFenced is returned when re-arming without an emergency stop engaged
+	ErrNotFenced = errors.New("emergency stop not engaged")
+)
+
+// FenceStatus is the emergency stop fence. While engaged every agent is
+// stopped and nothing new is started, across restarts, until re-armed.
+type FenceStatus struct {
+	Engaged   bool              `json:"engaged"`
+	EngagedBy string            `json:"engaged_by,omitempty"`
+	EngagedAt time.Time         `json:"engaged_at,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Agents    map[string]string `json:"agents,omitempty"` // Stop confirmation by agent
+}
+
+// EmergencyStop engages the fence: every scheduler drops its schedule, and
+// every connected agent is told to stop everything, boosts and calibrations
+// included, and asked to confirm. Agents that reconnect while the fence is
+// engaged are stopped through a signed flag in their register ack.
+func (s *Server) EmergencyStop(by, reason string) error {
+	if !s.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.fenceMu.Lock()
+	if s.fence.Engaged {
+		s.fenceMu.Unlock()
+		return ErrFenced
+	}
+	s.fence = FenceStatus{
+		Engaged:   true,
+		EngagedBy: by,
+		EngagedAt: time.Now(),
+		Reason:    reason,
+		Agents:    make(map[string]string),
+	}
+	s.fenceMu.Unlock()
+
+	s.logger.Warnw("Emergency stop engaged", "by", by, "reason", reason)
+	s.saveFence()
+
+	for _, scheduler := range s.schedulers {
+		scheduler.halt(reason)
+	}
+	s.stopAllAgents()
+	return nil
+}
+
+// Rearm lifts the fence. The schedulers plan afresh on their next evaluation.
+func (s *Server) Rearm(by string) error {
+	if !s.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.fenceMu.Lock()
+	if !s.fence.Engaged {
+		s.fenceMu.Unlock()
+		return ErrNotFenced
+	}
+	unconfirmed := 0
+	for _, state := range s.fence.Agents {
+		if state != StopConfirmed {
+			unconfirmed++
+		}
+	}
+	engagedFor := time.Since(s.fence.EngagedAt)
+	s.fence = FenceStatus{}
+	s.fenceMu.Unlock()
+
+	s.logger.Warnw("Emergency stop re-armed",
+		"by", by,
+		"engaged_for", engagedFor.Round(time.Second),
+		"unconfirmed_agents", unconfirmed,
+	)
+	s.saveFence()
+
+	for _, scheduler := range s.schedulers {
+		scheduler.rearm()
+	}
+	return nil
+}
+
+// Fenced reports whether the emergency stop is engaged
+func (s *Server) Fenced() bool {
+	s.fenceMu.Lock()
+	defer s.fenceMu.Unlock()
+	return s.fence.Engaged
+}
+
+// GetFence returns the emergency stop fence (for API)
+func (s *Server) GetFence() FenceStatus {
+	s.fenceMu.Lock()
+	defer s.fenceMu.Unlock()
+
+	fence := s.fence
+	if s.fence.Agents != nil {
+		fence.Agents = make(map[string]string, len(s.fence.Agents))
+		for agentID, state := range s.fence.Agents {
+			fence.Agents[agentID] = state
+		}
+	}
+	return fence
+}
+
+// stopAllAgents sends an emergency stop to every connected agent, whether
+// or not it is in a pool, and tracks their confirmations
+func (s *Server) stopAllAgents() {
+	for _, agentID := range s.GetConnectedAgents() {
+		go s.confirmStop(agentID, func(requestID string) error {
+			cmd := protocol.StopCommand{
+				CommandID: "", // Empty = stop all
+				RequestID: requestID,
+				Emergency: true,
+			}
+			msg, err := protocol.NewMessage(protocol.MsgTypeStopCommand, agentID, cmd)
+			if err != nil {
+				return err
+			}
+			return s.SendToAgent(agentID, msg)
+		})
+	}
+}
+
+// sendRegisterAck answers an agent's registration, carrying the signed
+// emergency stop flag while the fence is engaged
+func (s *Server) sendRegisterAck(agentID, nonce string) {
+	send := func(requestID string) error {
+		ack := protocol.RegisterAck{
+			EmergencyStop: requestID != "",
+			RequestID:     requestID,
+			Nonce:         nonce,
+			IssuedAt:      time.Now(),
+		}
+		ack.Sign(agentID, s.config.Server.AuthToken)
+
+		msg, err := protocol.NewMessage(protocol.MsgTypeRegisterAck, agentID, ack)
+		if err != nil {
+			return err
+		}
+		return s.SendToAgent(agentID, msg)
+	}
+
+	if s.Fenced() {
+		s.logger.Warnw("Stopping agent that registered during emergency stop", "agent_id", agentID)
+		go s.confirmStop(agentID, send)
+		return
+	}
+
+	if err := send(""); err != nil {
+		s.logger.Debugw("Failed to send register ack", "agent_id", agentID, "error", err)
+	}
+}
+
+// confirmStop sends an emergency stop to an agent with send and records
+// whether the agent confirms it within the drain timeout
+func (s *Server) confirmStop(agentID string, send func(requestID string) error) {
+	requestID := uuid.New().String()
+	ack := s.AwaitAck(requestID)
+	defer s.CancelAck(requestID)
+
+	s.setStopState(agentID, StopPending)
+
+	if err := send(requestID); err != nil {
+		s.logger.Errorw("Failed to send emergency stop to agent", "agent_id", agentID, "error", err)
+		s.setStopState(agentID, StopFailed)
+		return
+	}
+
+	select {
+	case result := <-ack:
+		if !result.Success {
+			s.logger.Errorw("Agent failed to stop on emergency stop",
+				"agent_id", agentID,
+				"message", result.Message,
+			)
+			s.setStopState(agentID, StopFailed)
+			return
+		}
+		s.logger.Infow("Agent confirmed emergency stop", "agent_id", agentID)
+		s.setStopState(agentID, StopConfirmed)
+
+	case <-time.After(s.config.Scheduler.DrainTimeout):
+		s.logger.Errorw("Agent did not confirm emergency stop", "agent_id", agentID)
+		s.setStopState(agentID, StopUnconfirmed)
+	}
+}
+
+// setStopState records an agent's stop confirmation, unless the fence was
+// re-armed meanwhile
+func (s *Server) setStopState(agentID, state string) {
+	s.fenceMu.Lock()
+	if !s.fence.Engaged {
+		s.fenceMu.Unlock()
+		return
+	}
+	s.fence.Agents[agentID] = state
+	s.fenceMu.Unlock()
+
+	s.saveFence()
+}
+
+// saveFence persists the fence
+func (s *Server) saveFence() {
+	if s.store == nil {
+		return
+	}
+
+	fence := s.GetFence()
+	if err := s.store.Put(bucketScheduler, keyFence, fence); err != nil {
+		s.logger.Warnw("Failed to persist emergency stop", "error", err)
+	}
+}
+
+// restoreFence loads the fence engaged under a previous leader or before a
+// restart and stops the connected agents again. It runs once per takeover.
+func (s *Server) restoreFence() {
+	if s.store == nil {
+		return
+	}
+
+	var fence FenceStatus
+	found, err := s.store.Get(bucketScheduler, keyFence, &fence)
+	if err != nil {
+		s.logger.Warnw("Failed to restore emergency stop", "error", err)
+		return
+	}
+	if !found || !fence.Engaged {
+		return
+	}
+
+	s.fenceMu.Lock()
+	if s.fence.Engaged {
+		s.fenceMu.Unlock()
+		return
+	}
+	s.fence = fence
+	s.fence.Agents = make(map[string]string)
+	s.fenceMu.Unlock()
+
+	s.logger.Warnw("Restored emergency stop, agents stay stopped until re-armed",
+		"engaged_by", fence.EngagedBy,
+		"engaged_at", fence.EngagedAt,
+		"reason", fence.Reason,
+	)
+	s.stopAllAgents()
+}
+
+// startsWork reports whether a message starts new work on an agent
+func startsWork(msgType protocol.MessageType) bool {
+	return msgType == protocol.MsgTypeDownloadCommand || msgType == protocol.MsgTypeCalibrateCommand
+}
+
+// halt drops the schedule on an emergency stop. The agents themselves are
+// stopped by the server.
+func (s *Scheduler) halt(reason string) {
+	s.abandonRotation("emergency stop")
+
+	s.mu.Lock()
+	s.state.ActiveAgents = make(map[string]*AgentAllocation)
+	s.state.Phase = "fenced"
+	s.state.CurrentTotalBW = 0
+	s.pendingReconcile = make(map[string]bool)
+	s.drains = make(map[string]*agentDrain)
+	s.lost = nil
+	s.mu.Unlock()
+
+	s.pid.Reset()
+	s.events.Publish(Event{Type: EventEmergencyStop, Phase: "engaged", Reason: reason})
+	s.saveState()
+}
+
+// rearm lets the scheduler plan again once the fence is lifted
+func (s *Scheduler) rearm() {
+	s.mu.Lock()
+	s.state.Phase = "idle"
+	s.state.NextRotation = time.Now()
+	s.mu.Unlock()
+
+	s.events.Publish(Event{Type: EventEmergencyStop, Phase: "rearmed"})
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventRotationPhase = "rotation_phase"
+	EventAgentMode     = "agent_mode"
+	EventPause         = "pause"
+	EventEmergencyStop = "emergency_stop"
+)
+
+// Event is a scheduler event published on the event bus
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	RotationID string    `json:"rotation_id,omitempty"`
+	Phase      string    `json:"phase,omitempty"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	Mode       string    `json:"mode,omitempty"` // Agent mode
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// EventBus fans scheduler events out to subscribers and keeps the most
+// recent ones for late readers
+type EventBus struct {
+	mu          sync.Mutex
+	recent      []Event
+	limit       int
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates an event bus that remembers up to limit events
+func NewEventBus(limit int) *EventBus {
+	return &EventBus{
+		limit:       limit,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to subscribers. Subscribers that
+// are not keeping up miss the event rather than blocking the publisher.
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.recent = append(b.recent, event)
+	if len(b.recent) > b.limit {
+		b.recent = b.recent[len(b.recent)-b.limit:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving new events and a function that
+// ends the subscription
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Recent returns the remembered events, oldest first
+func (b *EventBus) Recent() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make([]Event, len(b.recent))
+	copy(events, b.recent)
+	return events
+}
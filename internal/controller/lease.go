@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
+)
+
+// LeaseRecord describes who holds the leadership lease
+type LeaseRecord struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LeaseBackend is a lease shared by controller instances contending for
+// leadership. Implementations must make TryAcquire atomic across instances.
+type LeaseBackend interface {
+	// TryAcquire acquires the lease for holder, or renews it if holder
+	// already has it. It returns true if holder holds the lease afterwards.
+	TryAcquire(holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder has it
+	Release(holder string) error
+	// Current returns the current lease record
+	Current() (LeaseRecord, error)
+}
+
+// FileLease is a LeaseBackend backed by a file guarded with flock. It works
+// for instances on the same host or on a shared filesystem with working locks.
+type FileLease struct {
+	path string
+}
+
+// NewFileLease creates a file lease at path
+func NewFileLease(path string) (*FileLease, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	return &FileLease{path: path}, nil
+}
+
+// TryAcquire implements LeaseBackend
+func (l *FileLease) TryAcquire(holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+
+	err := l.withLock(func() error {
+		record, err := l.read()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if record.Holder != "" && record.Holder != holder && now.Before(record.ExpiresAt) {
+			return nil // Held by someone else
+		}
+
+		if record.Holder != holder {
+			record.AcquiredAt = now
+		}
+		record.Holder = holder
+		record.RenewedAt = now
+		record.ExpiresAt = now.Add(ttl)
+
+		if err := l.write(record); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+
+	return acquired, err
+}
+
+// Release implements LeaseBackend
+func (l *FileLease) Release(holder string) error {
+	return l.withLock(func() error {
+		record, err := l.read()
+		if err != nil {
+			return err
+		}
+		if record.Holder != holder {
+			return nil
+		}
+
+		// Expire the lease so a standby can take over right away
+		record.ExpiresAt = time.Now()
+		return l.write(record)
+	})
+}
+
+// Current implements LeaseBackend
+func (l *FileLease) Current() (LeaseRecord, error) {
+	var record LeaseRecord
+	err := l.withLock(func() error {
+		var err error
+		record, err = l.read()
+		return err
+	})
+	return record, err
+}
+
+// withLock runs fn while holding an exclusive flock on the lock file
+func (l *FileLease) withLock(fn func() error) error {
+	lockFile, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open lease lock: %w", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock lease: %w", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// read loads the lease record, returning an empty record if none exists
+func (l *FileLease) read() (LeaseRecord, error) {
+	var record LeaseRecord
+
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return record, nil
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to read lease: %w", err)
+	}
+	if len(data) == 0 {
+		return record, nil
+	}
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return LeaseRecord{}, fmt.Errorf("failed to parse lease: %w", err)
+	}
+	return record, nil
+}
+
+// write atomically replaces the lease record
+func (l *FileLease) write(record LeaseRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Elector contends for the leadership lease and tracks whether this
+// controller instance is the leader
+type Elector struct {
+	backend       LeaseBackend
+	instanceID    string
+	ttl           time.Duration
+	renewInterval time.Duration
+	logger        *logger.Logger
+
+	leader    atomic.Bool
+	mu        sync.Mutex
+	lastRenew time.Time
+	changes   chan bool
+}
+
+// NewElector creates an elector for the given instance
+func NewElector(backend LeaseBackend, instanceID string, ttl, renewInterval time.Duration, log *logger.Logger) *Elector {
+	return &Elector{
+		backend:       backend,
+		instanceID:    instanceID,
+		ttl:           ttl,
+		renewInterval: renewInterval,
+		logger:        log,
+		changes:       make(chan bool, 1),
+	}
+}
+
+// Run contends for the lease until ctx is cancelled, then releases it
+func (e *Elector) Run(ctx context.Context) {
+	e.logger.Infow("Starting leader election",
+		"instance_id", e.instanceID,
+		"lease_ttl", e.ttl,
+	)
+
+	ticker := time.NewTicker(e.renewInterval)
+	defer ticker.Stop()
+
+	e.tick()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if e.leader.Load() {
+				if err := e.backend.Release(e.instanceID); err != nil {
+					e.logger.Warnw("Failed to release leadership lease", "error", err)
+				}
+				e.setLeader(false)
+			}
+			return
+
+		case <-ticker.C:
+			e.tick()
+		}
+	}
+}
+
+// tick acquires or renews the lease and publishes leadership changes
+func (e *Elector) tick() {
+	held, err := e.backend.TryAcquire(e.instanceID, e.ttl)
+	if err != nil {
+		e.logger.Warnw("Failed to renew leadership lease", "error", err)
+
+		// Keep leading only while the last renewal is certainly still valid
+		e.mu.Lock()
+		expired := time.Since(e.lastRenew) > e.ttl-e.renewInterval
+		e.mu.Unlock()
+		if e.leader.Load() && expired {
+			e.setLeader(false)
+		}
+		return
+	}
+
+	if held {
+		e.mu.Lock()
+		e.lastRenew = time.Now()
+		e.mu.Unlock()
+	}
+
+	e.setLeader(held)
+}
+
+// setLeader records a leadership state, publishing it if it changed
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+
+	if leader {
+		e.logger.Infow("Acquired leadership", "instance_id", e.instanceID)
+	} else {
+		e.logger.Warnw("Lost leadership", "instance_id", e.instanceID)
+	}
+
+	// Only the latest state matters to the consumer
+	select {
+	case <-e.changes:
+	default:
+	}
+	e.changes <- leader
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Changes returns a channel receiving the new state on leadership changes
+func (e *Elector) Changes() <-chan bool {
+	return e.changes
+}
+
+// InstanceID returns this instance's identity in the election
+func (e *Elector) InstanceID() string {
+	return e.instanceID
+}
+
+// Current returns the current lease record
+func (e *Elector) Current() (LeaseRecord, error) {
+	return e.backend.Current()
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
+)
+
+// Agent modes, set through the API. Maintenance windows cordon an agent
+// without changing its mode.
+const (
+	AgentModeActive   = "active"   // Scheduled as usual
+	AgentModeCordoned = "cordoned" // Left out of new schedules
+	AgentModeDraining = "draining" // Ramped down now, then cordoned
+)
+
+// drainSteps is the number of steps a drained agent is ramped down in
+const drainSteps = 10
+
+// ErrUnknownAgentMode is returned for a mode other than the agent modes
+var ErrUnknownAgentMode = errors.New("unknown agent mode")
+
+// maintenanceWindow is a parsed MaintenanceWindowConfig
+type maintenanceWindow struct {
+	recurringWindow
+	name     string
+	location *time.Location
+}
+
+// parseMaintenanceWindow validates and parses a maintenance window
+func parseMaintenanceWindow(wc MaintenanceWindowConfig) (*maintenanceWindow, error) {
+	recurring, err := parseRecurringWindow(wc.Days, wc.Start, wc.End)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &maintenanceWindow{
+		recurringWindow: recurring,
+		name:            wc.Name,
+		location:        time.Local,
+	}
+	if wc.Timezone != "" {
+		if window.location, err = time.LoadLocation(wc.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return window, nil
+}
+
+// parseMaintenance parses the maintenance windows of every agent. Windows
+// were validated with the config, so any that fail to parse are skipped.
+func parseMaintenance(agents []AgentConfig, log *logger.Logger) map[string][]*maintenanceWindow {
+	windows := make(map[string][]*maintenanceWindow)
+	for _, agent := range agents {
+		for i, wc := range agent.Maintenance {
+			window, err := parseMaintenanceWindow(wc)
+			if err != nil {
+				log.Errorw("Failed to parse maintenance window", "agent_id", agent.ID, "error", err)
+				continue
+			}
+			if window.name == "" {
+				window.name = fmt.Sprintf("maintenance-%d", i+1)
+			}
+			windows[agent.ID] = append(windows[agent.ID], window)
+		}
+	}
+	return windows
+}
+
+// AgentModeStatus is an agent's mode and whether it can be scheduled
+type AgentModeStatus struct {
+	Mode        string `json:"mode"`
+	Maintenance string `json:"maintenance,omitempty"` // Window in force
+	Draining    bool   `json:"draining"`              // Being ramped down
+	OverBudget  bool   `json:"over_budget"`           // Agent or pool transfer budget used up
+	Schedulable bool   `json:"schedulable"`
+}
+
+// agentDrain is an agent being ramped down outside a rotation
+type agentDrain struct {
+	alloc   *AgentAllocation
+	from    int64 // Commanded bandwidth when the drain began
+	started time.Time
+	step    int
+}
+
+// maintenance returns the name of the maintenance window an agent is in at
+// t, or "" if none
+func (s *Scheduler) maintenance(agentID string, t time.Time) string {
+	for _, window := range s.maintenanceWindows[agentID] {
+		if window.contains(t.In(window.location)) {
+			return window.name
+		}
+	}
+	return ""
+}
+
+// agentMode returns the mode set for an agent. Must be called with s.mu held.
+func (s *Scheduler) agentMode(agentID string) string {
+	if status, ok := s.agentStatus[agentID]; ok && status.Mode != "" {
+		return status.Mode
+	}
+	return AgentModeActive
+}
+
+// schedulable reports whether an agent may be picked for a schedule: it is
+// active, outside its maintenance windows, within its transfer budget and
+// not being ramped down. Must be called with s.mu held.
+func (s *Scheduler) schedulable(agentID string, now time.Time) bool {
+	_, ramping := s.drains[agentID]
+	return s.agentMode(agentID) == AgentModeActive && s.maintenance(agentID, now) == "" &&
+		!s.budgetExhausted(agentID) && !ramping
+}
+
+// mustStop reports whether a running agent is to be ramped down: it is
+// draining, in a maintenance window or out of transfer budget.
+// Must be called with s.mu held.
+func (s *Scheduler) mustStop(agentID string, now time.Time) bool {
+	return s.agentMode(agentID) == AgentModeDraining || s.maintenance(agentID, now) != "" ||
+		s.budgetExhausted(agentID)
+}
+
+// SetAgentMode sets an agent's mode. Draining an agent that is running ramps
+// it down between rotations, handing its bandwidth to the other agents, and
+// cordons it once it has stopped.
+func (s *Scheduler) SetAgentMode(agentID, mode string) error {
+	switch mode {
+	case AgentModeActive, AgentModeCordoned, AgentModeDraining:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownAgentMode, mode)
+	}
+	if !s.server.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.mu.Lock()
+	status, ok := s.agentStatus[agentID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown agent %s", agentID)
+	}
+	previous := s.agentMode(agentID)
+	status.Mode = mode
+	if mode == AgentModeActive {
+		status.Mode = ""
+	}
+	s.mu.Unlock()
+
+	s.logger.Infow("Agent mode changed",
+		"agent_id", agentID,
+		"from", previous,
+		"to", mode,
+	)
+	s.events.Publish(Event{Type: EventAgentMode, AgentID: agentID, Mode: mode})
+	s.saveState()
+	return nil
+}
+
+// GetAgentMode returns an agent's mode (for API)
+func (s *Scheduler) GetAgentMode(agentID string) AgentModeStatus {
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ramping := s.drains[agentID]
+	return AgentModeStatus{
+		Mode:        s.agentMode(agentID),
+		Maintenance: s.maintenance(agentID, now),
+		Draining:    ramping,
+		OverBudget:  s.budgetExhausted(agentID),
+		Schedulable: s.schedulable(agentID, now),
+	}
+}
+
+// drainAgents ramps down running agents that are draining or in a
+// maintenance window, in steps over the ramp-down duration, and hands their
+// bandwidth to the other agents. Drains begin between rotations, which pick
+// such agents no more, and not while the scheduler is paused.
+func (s *Scheduler) drainAgents() {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return
+	}
+
+	if s.rotation == nil && !s.pause.Paused {
+		for agentID, alloc := range s.state.ActiveAgents {
+			if !s.mustStop(agentID, now) {
+				continue
+			}
+
+			delete(s.state.ActiveAgents, agentID)
+			s.retireBoosts(alloc, float64(alloc.BoostBandwidth()), "agent draining")
+			s.drains[agentID] = &agentDrain{
+				alloc:   alloc,
+				from:    alloc.CommandedBW,
+				started: now,
+			}
+			s.lost = append(s.lost, lostAllocation{agentID: agentID, bandwidth: alloc.AllocatedBW})
+
+			reason := "drained by operator"
+			if window := s.maintenance(agentID, now); window != "" {
+				reason = "maintenance window " + window
+			} else if s.budgetExhausted(agentID) {
+				reason = "transfer budget exhausted"
+			}
+			s.logger.Infow("Ramping down agent",
+				"agent_id", agentID,
+				"reason", reason,
+				"bandwidth", alloc.CommandedBW,
+				"over", s.config.Scheduler.RampDownDuration,
+			)
+		}
+	}
+
+	rampDown := s.config.Scheduler.RampDownDuration
+	for agentID, drain := range s.drains {
+		elapsed := now.Sub(drain.started)
+		step := drainSteps
+		if rampDown > 0 {
+			step = int(float64(drainSteps) * elapsed.Seconds() / rampDown.Seconds())
+		}
+		if step == drain.step {
+			continue
+		}
+		drain.step = step
+
+		if step < drainSteps {
+			bw := drain.from * int64(drainSteps-step) / drainSteps
+			if err := s.adjustAgent(agentID, drain.alloc.CurrentCommand, bw); err == nil {
+				continue
+			}
+		}
+
+		s.stopAgent(agentID)
+		delete(s.drains, agentID)
+		s.logger.Infow("Agent ramped down", "agent_id", agentID)
+	}
+
+	// Draining agents that run nothing any more are cordoned
+	for agentID, status := range s.agentStatus {
+		if status.Mode != AgentModeDraining {
+			continue
+		}
+		_, active := s.state.ActiveAgents[agentID]
+		_, ramping := s.drains[agentID]
+		planned := s.rotation != nil && s.rotation.planned(agentID)
+		if !active && !ramping && !planned {
+			status.Mode = AgentModeCordoned
+			s.logger.Infow("Agent drained and cordoned", "agent_id", agentID)
+			s.events.Publish(Event{Type: EventAgentMode, AgentID: agentID, Mode: AgentModeCordoned})
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 type MetricsAggregator struct {
 	config       *Config
 	logger       *logger.Logger
+	store        *Store
 	mu           sync.RWMutex
 	agentMetrics map[string]*AgentMetrics
 	history      []AggregatedMetrics
@@ -39,15 +41,68 @@ type AggregatedMetrics struct {
 	AgentBreakdown   map[string]float64
 }
 
-// NewMetricsAggregator creates a new metrics aggregator
-func NewMetricsAggregator(config *Config, log *logger.Logger) *MetricsAggregator {
-	return &MetricsAggregator{
+// NewMetricsAggregator creates a new metrics aggregator. Snapshots are
+// persisted to the store while it is open.
+func NewMetricsAggregator(config *Config, store *Store, log *logger.Logger) *MetricsAggregator {
+	m := &MetricsAggregator{
 		config:       config,
 		logger:       log,
+		store:        store,
 		agentMetrics: make(map[string]*AgentMetrics),
 		history:      make([]AggregatedMetrics, 0),
 		maxHistory:   1440, // 24 hours at 1 minute intervals
 	}
+
+	return m
+}
+
+// RestoreHistory loads persisted snapshots that are still within retention,
+// keeping any newer snapshots recorded in memory since
+func (m *MetricsAggregator) RestoreHistory() {
+	if !m.store.IsOpen() {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(m.maxHistory) * time.Minute)
+	var restored []AggregatedMetrics
+	err := m.store.ForEach(bucketHistory, func(key string, data []byte) error {
+		var snapshot AggregatedMetrics
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil // Skip corrupt entries
+		}
+		if snapshot.Timestamp.After(cutoff) {
+			restored = append(restored, snapshot)
+		}
+		return nil
+	})
+	if err != nil {
+		m.logger.Warnw("Failed to restore metrics history", "error", err)
+		return
+	}
+
+	if len(restored) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last := restored[len(restored)-1].Timestamp
+	for _, snapshot := range m.history {
+		if snapshot.Timestamp.After(last) {
+			restored = append(restored, snapshot)
+		}
+	}
+
+	if len(restored) > m.maxHistory {
+		restored = restored[len(restored)-m.maxHistory:]
+	}
+	m.history = restored
+
+	m.logger.Infow("Restored metrics history",
+		"snapshots", len(m.history),
+		"oldest", m.history[0].Timestamp,
+	)
 }
 
 // UpdateAgentMetrics updates metrics for a specific agent
@@ -76,13 +131,18 @@ func (m *MetricsAggregator) RemoveAgent(agentID string) {
 
 // GetAggregated returns aggregated metrics from all agents
 func (m *MetricsAggregator) GetAggregated() AggregatedMetrics {
+	return m.GetAggregatedFor(nil)
+}
+
+// GetAggregatedFor returns aggregated metrics from the given agents, or from
+// all agents if agentIDs is nil
+func (m *MetricsAggregator) GetAggregatedFor(agentIDs []string) AggregatedMetrics {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	agg := AggregatedMetrics{
 		Timestamp:      time.Now(),
 		AgentBreakdown: make(map[string]float64),
-		TotalAgents:    len(m.agentMetrics),
 	}
 
 	totalBW := 0.0
@@ -90,6 +150,10 @@ func (m *MetricsAggregator) GetAggregated() AggregatedMetrics {
 	activeCount := 0
 
 	for agentID, metrics := range m.agentMetrics {
+		if agentIDs != nil && !containsString(agentIDs, agentID) {
+			continue
+		}
+		agg.TotalAgents++
 		agg.AgentBreakdown[agentID] = metrics.CurrentBandwidth
 		totalBW += metrics.CurrentBandwidth
 		totalAvgBW += metrics.AverageBandwidth
@@ -102,8 +166,8 @@ func (m *MetricsAggregator) GetAggregated() AggregatedMetrics {
 	agg.TotalBandwidth = totalBW
 	agg.ActiveAgents = activeCount
 
-	if len(m.agentMetrics) > 0 {
-		agg.AverageBandwidth = totalAvgBW / float64(len(m.agentMetrics))
+	if agg.TotalAgents > 0 {
+		agg.AverageBandwidth = totalAvgBW / float64(agg.TotalAgents)
 	}
 
 	return agg
@@ -137,14 +201,24 @@ func (m *MetricsAggregator) RecordSnapshot() {
 	agg := m.GetAggregated()
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.history = append(m.history, agg)
 
 	// Trim history if too large
 	if len(m.history) > m.maxHistory {
 		m.history = m.history[len(m.history)-m.maxHistory:]
 	}
+	oldest := m.history[0].Timestamp
+	m.mu.Unlock()
+
+	// Persist the snapshot and drop what fell out of the ring
+	if m.store != nil {
+		if err := m.store.Put(bucketHistory, timeKey(agg.Timestamp), agg); err != nil {
+			m.logger.Warnw("Failed to persist metrics snapshot", "error", err)
+		}
+		if err := m.store.DeleteBefore(bucketHistory, timeKey(oldest)); err != nil {
+			m.logger.Warnw("Failed to prune persisted metrics history", "error", err)
+		}
+	}
 }
 
 // GetHistory returns historical metrics
@@ -164,6 +238,45 @@ func (m *MetricsAggregator) GetHistory(duration time.Duration) []AggregatedMetri
 	return result
 }
 
+// GetHistoryFor returns historical metrics of the given agents only, or of
+// all agents if agentIDs is nil
+func (m *MetricsAggregator) GetHistoryFor(duration time.Duration, agentIDs []string) []AggregatedMetrics {
+	history := m.GetHistory(duration)
+	if agentIDs == nil {
+		return history
+	}
+
+	for i, snapshot := range history {
+		history[i] = scopeSnapshot(snapshot, agentIDs)
+	}
+	return history
+}
+
+// scopeSnapshot recomputes a snapshot from the breakdown of some agents.
+// Snapshots do not keep per-agent averages, so the average is of the
+// current bandwidth.
+func scopeSnapshot(snapshot AggregatedMetrics, agentIDs []string) AggregatedMetrics {
+	scoped := AggregatedMetrics{
+		Timestamp:      snapshot.Timestamp,
+		AgentBreakdown: make(map[string]float64),
+	}
+	for agentID, bw := range snapshot.AgentBreakdown {
+		if !containsString(agentIDs, agentID) {
+			continue
+		}
+		scoped.AgentBreakdown[agentID] = bw
+		scoped.TotalBandwidth += bw
+		scoped.TotalAgents++
+		if bw > 0 {
+			scoped.ActiveAgents++
+		}
+	}
+	if scoped.TotalAgents > 0 {
+		scoped.AverageBandwidth = scoped.TotalBandwidth / float64(scoped.TotalAgents)
+	}
+	return scoped
+}
+
 // GetRecentHistory returns the last N snapshots
 func (m *MetricsAggregator) GetRecentHistory(count int) []AggregatedMetrics {
 	m.mu.RLock()
@@ -186,7 +299,13 @@ func (m *MetricsAggregator) GetRecentHistory(count int) []AggregatedMetrics {
 
 // GetStats returns statistical information about bandwidth
 func (m *MetricsAggregator) GetStats(duration time.Duration) BandwidthStats {
-	history := m.GetHistory(duration)
+	return m.GetStatsFor(duration, nil)
+}
+
+// GetStatsFor returns statistical information about the bandwidth of the
+// given agents, or of all agents if agentIDs is nil
+func (m *MetricsAggregator) GetStatsFor(duration time.Duration, agentIDs []string) BandwidthStats {
+	history := m.GetHistoryFor(duration, agentIDs)
 
 	if len(history) == 0 {
 		return BandwidthStats{}
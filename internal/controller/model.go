@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/bandwidth"
+)
+
+// Store key for the throughput model
+const keyThroughputModel = "throughput_model"
+
+// staleMetrics is the age beyond which agent metrics are not learned from
+const staleMetrics = 30 * time.Second
+
+// maxObservedEfficiency bounds single observations, which can overshoot
+// briefly when a limiter catches up
+const maxObservedEfficiency = 1.5
+
+// ThroughputEstimate is a time-decayed average of the share of its commanded
+// bandwidth an agent, or an agent on one URL, delivers
+type ThroughputEstimate struct {
+	Efficiency    float64   `json:"efficiency"`     // Delivered over commanded
+	DeliveredMbps float64   `json:"delivered_mbps"` // Average delivered bandwidth
+	Weight        float64   `json:"weight"`         // Decayed number of observations
+	Samples       int       `json:"samples"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// observe folds an observation into the estimate. Earlier observations lose
+// half their weight every half-life.
+func (e *ThroughputEstimate) observe(efficiency, delivered float64, now time.Time, halfLife time.Duration) {
+	decay := 0.0
+	if e.Samples > 0 {
+		elapsed := now.Sub(e.UpdatedAt)
+		decay = math.Exp(-math.Ln2 * elapsed.Seconds() / halfLife.Seconds())
+	}
+
+	previous := e.Weight * decay
+	e.Weight = previous + 1
+	e.Efficiency = (e.Efficiency*previous + efficiency) / e.Weight
+	e.DeliveredMbps = (e.DeliveredMbps*previous + delivered) / e.Weight
+	e.Samples++
+	e.UpdatedAt = now
+}
+
+// ThroughputSnapshot is a copy of the model (for API and persistence)
+type ThroughputSnapshot struct {
+	Agents map[string]ThroughputEstimate            `json:"agents"`
+	URLs   map[string]map[string]ThroughputEstimate `json:"urls"` // Agent ID, then URL
+}
+
+// ThroughputModel learns how much of their commanded bandwidth agents
+// deliver, overall and per URL. It has its own lock so the scheduler can
+// consult it with or without s.mu held.
+type ThroughputModel struct {
+	config ThroughputModelConfig
+	mu     sync.RWMutex
+	agents map[string]*ThroughputEstimate
+	urls   map[string]map[string]*ThroughputEstimate
+}
+
+// NewThroughputModel creates an empty model
+func NewThroughputModel(config ThroughputModelConfig) *ThroughputModel {
+	return &ThroughputModel{
+		config: config,
+		agents: make(map[string]*ThroughputEstimate),
+		urls:   make(map[string]map[string]*ThroughputEstimate),
+	}
+}
+
+// ObserveAgent records what an agent delivered against what it was commanded
+func (m *ThroughputModel) ObserveAgent(agentID string, delivered, commanded float64, now time.Time) {
+	if m.config.Disabled || commanded <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	estimate, ok := m.agents[agentID]
+	if !ok {
+		estimate = &ThroughputEstimate{}
+		m.agents[agentID] = estimate
+	}
+	estimate.observe(observedEfficiency(delivered, commanded), delivered, now, m.config.HalfLife)
+}
+
+// ObserveURL records what a single command on url delivered against what it
+// was commanded
+func (m *ThroughputModel) ObserveURL(agentID, url string, delivered, commanded float64, now time.Time) {
+	if m.config.Disabled || commanded <= 0 || url == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byURL, ok := m.urls[agentID]
+	if !ok {
+		byURL = make(map[string]*ThroughputEstimate)
+		m.urls[agentID] = byURL
+	}
+	estimate, ok := byURL[url]
+	if !ok {
+		estimate = &ThroughputEstimate{}
+		byURL[url] = estimate
+	}
+	estimate.observe(observedEfficiency(delivered, commanded), delivered, now, m.config.HalfLife)
+}
+
+func observedEfficiency(delivered, commanded float64) float64 {
+	return bandwidth.ClampFloat(delivered/commanded, 0, maxObservedEfficiency)
+}
+
+// AgentEfficiency returns the share of its commanded bandwidth an agent is
+// expected to deliver, between the configured minimum and 1. Agents with too
+// few observations are assumed to deliver in full.
+func (m *ThroughputModel) AgentEfficiency(agentID string) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.efficiency(m.agents[agentID])
+}
+
+// URLEfficiency returns the expected efficiency of an agent on url, like
+// AgentEfficiency
+func (m *ThroughputModel) URLEfficiency(agentID, url string) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.efficiency(m.urls[agentID][url])
+}
+
+// efficiency applies the sample threshold and bounds to an estimate.
+// Must be called with m.mu held.
+func (m *ThroughputModel) efficiency(estimate *ThroughputEstimate) float64 {
+	if m.config.Disabled || estimate == nil || estimate.Samples < m.config.MinSamples {
+		return 1
+	}
+	return bandwidth.ClampFloat(estimate.Efficiency, m.config.MinEfficiency, 1)
+}
+
+// Snapshot returns a copy of every estimate
+func (m *ThroughputModel) Snapshot() ThroughputSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := ThroughputSnapshot{
+		Agents: make(map[string]ThroughputEstimate, len(m.agents)),
+		URLs:   make(map[string]map[string]ThroughputEstimate, len(m.urls)),
+	}
+	for agentID, estimate := range m.agents {
+		snapshot.Agents[agentID] = *estimate
+	}
+	for agentID, byURL := range m.urls {
+		urls := make(map[string]ThroughputEstimate, len(byURL))
+		for url, estimate := range byURL {
+			urls[url] = *estimate
+		}
+		snapshot.URLs[agentID] = urls
+	}
+	return snapshot
+}
+
+// Restore replaces the estimates with a snapshot
+func (m *ThroughputModel) Restore(snapshot ThroughputSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.agents = make(map[string]*ThroughputEstimate, len(snapshot.Agents))
+	for agentID, estimate := range snapshot.Agents {
+		estimateCopy := estimate
+		m.agents[agentID] = &estimateCopy
+	}
+	m.urls = make(map[string]map[string]*ThroughputEstimate, len(snapshot.URLs))
+	for agentID, urls := range snapshot.URLs {
+		byURL := make(map[string]*ThroughputEstimate, len(urls))
+		for url, estimate := range urls {
+			estimateCopy := estimate
+			byURL[url] = &estimateCopy
+		}
+		m.urls[agentID] = byURL
+	}
+}
+
+// observeThroughput teaches the model what each active agent and each of its
+// commands delivered. Ramps, fresh commands and calibrating agents are left
+// out. Must be called with s.mu held.
+func (s *Scheduler) observeThroughput(agg AggregatedMetrics) {
+	if s.state.Phase != "stable" || s.rotation != nil {
+		return
+	}
+
+	now := time.Now()
+	warmup := s.config.Scheduler.ThroughputModel.Warmup
+
+	for agentID, alloc := range s.state.ActiveAgents {
+		if alloc.CurrentCommand == "" || s.calibrations.isRunning(agentID) {
+			continue
+		}
+		agentMetrics := s.metrics.GetAgentMetrics(agentID)
+		if agentMetrics == nil || now.Sub(agentMetrics.LastUpdate) > staleMetrics {
+			continue
+		}
+
+		settled := true
+		for _, cmd := range alloc.Commands {
+			if now.Sub(cmd.StartedAt) < warmup {
+				settled = false
+				continue
+			}
+			for _, measured := range agentMetrics.CommandMetrics {
+				if measured.CommandID == cmd.CommandID {
+					s.model.ObserveURL(agentID, cmd.URL, measured.CurrentSpeed, float64(cmd.Bandwidth), now)
+					break
+				}
+			}
+		}
+
+		if settled {
+			commanded := float64(alloc.CommandedBW + alloc.BoostBandwidth())
+			s.model.ObserveAgent(agentID, agg.AgentBreakdown[agentID], commanded, now)
+		}
+	}
+}
+
+// saveModel persists the throughput model
+func (s *Scheduler) saveModel() {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Put(bucketScheduler, s.storeKey(keyThroughputModel), s.model.Snapshot()); err != nil {
+		s.logger.Warnw("Failed to persist throughput model", "error", err)
+	}
+}
+
+// restoreModel loads the throughput model learned by a previous leader
+func (s *Scheduler) restoreModel() {
+	if s.store == nil {
+		return
+	}
+
+	var snapshot ThroughputSnapshot
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keyThroughputModel), &snapshot)
+	if err != nil {
+		s.logger.Warnw("Failed to restore throughput model", "error", err)
+		return
+	}
+	if found {
+		s.model.Restore(snapshot)
+		s.logger.Infow("Restored throughput model", "agents", len(snapshot.Agents))
+	}
+}
+
+// deliverableCapacity returns the bandwidth an agent can be expected to
+// deliver: its usable capacity scaled by its learned efficiency, and held
+// back while it is ahead of its transfer budget
+func (s *Scheduler) deliverableCapacity(agent AgentConfig) int64 {
+	return int64(float64(s.capacity(agent)) * s.model.AgentEfficiency(agent.ID) * s.agentPace(agent.ID))
+}
+
+// GetThroughputModel returns the learned throughput estimates (for API)
+func (s *Scheduler) GetThroughputModel() ThroughputSnapshot {
+	return s.model.Snapshot()
+}
+
+// GetDeliverableCapacities returns the efficiency the scheduler assumes for
+// each agent and the bandwidth it will plan for it at most (for API)
+func (s *Scheduler) GetDeliverableCapacities() (map[string]float64, map[string]int64) {
+	efficiencies := make(map[string]float64, len(s.config.Agents))
+	capacities := make(map[string]int64, len(s.config.Agents))
+	for _, agent := range s.config.Agents {
+		efficiencies[agent.ID] = s.model.AgentEfficiency(agent.ID)
+		capacities[agent.ID] = s.deliverableCapacity(agent)
+	}
+	return efficiencies, capacities
+}
@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store key for selection overrides
+const keyOverrides = "overrides"
+
+// Override kinds
+const (
+	OverridePin       = "pin"        // Always select the agents, at a fixed bandwidth
+	OverrideExclude   = "exclude"    // Never select the agents
+	OverrideActiveSet = "active_set" // Select exactly the agents
+)
+
+// ErrOverrideNotFound is returned when removing an override that is not in force
+var ErrOverrideNotFound = errors.New("override not found")
+
+// Override is an operator rule applied ahead of weighted random selection
+// until it expires. Agents are named by ID or, for exclusions, matched by
+// labels.
+type Override struct {
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	AgentIDs  []string          `json:"agent_ids,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty"`  // exclude: labels an agent must have
+	Bandwidth int64             `json:"bandwidth,omitempty"` // pin: Mbps per agent
+	Reason    string            `json:"reason,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// excludes reports whether an exclusion applies to an agent
+func (o Override) excludes(agent AgentConfig) bool {
+	if o.Kind != OverrideExclude {
+		return false
+	}
+	if containsString(o.AgentIDs, agent.ID) {
+		return true
+	}
+	if len(o.Selector) == 0 {
+		return false
+	}
+	for key, value := range o.Selector {
+		if agent.Label(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// overrides holds the overrides in force. It has its own lock so selection
+// and allocation can consult it with or without s.mu held.
+type overrides struct {
+	mu   sync.RWMutex
+	list []Override
+}
+
+// active returns the overrides that have not expired
+func (o *overrides) active(now time.Time) []Override {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	active := make([]Override, 0, len(o.list))
+	for _, override := range o.list {
+		if now.Before(override.ExpiresAt) {
+			active = append(active, override)
+		}
+	}
+	return active
+}
+
+// expire drops expired overrides and returns them
+func (o *overrides) expire(now time.Time) []Override {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var expired []Override
+	kept := o.list[:0]
+	for _, override := range o.list {
+		if now.Before(override.ExpiresAt) {
+			kept = append(kept, override)
+		} else {
+			expired = append(expired, override)
+		}
+	}
+	o.list = kept
+	return expired
+}
+
+// pin returns the bandwidth an agent is pinned at, if it is
+func (o *overrides) pin(agentID string) (int64, bool) {
+	now := time.Now()
+	for _, override := range o.active(now) {
+		if override.Kind == OverridePin && containsString(override.AgentIDs, agentID) {
+			return override.Bandwidth, true
+		}
+	}
+	return 0, false
+}
+
+// excluded reports whether an exclusion in force applies to an agent
+func (o *overrides) excluded(agent AgentConfig) bool {
+	for _, override := range o.active(time.Now()) {
+		if override.excludes(agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeSet returns the agents of the active set in force, or nil if none
+func (o *overrides) activeSet() []string {
+	for _, override := range o.active(time.Now()) {
+		if override.Kind == OverrideActiveSet {
+			return override.AgentIDs
+		}
+	}
+	return nil
+}
+
+// AddOverride validates an override and puts it in force for the given
+// duration. A rotation follows so it takes effect straight away.
+func (s *Scheduler) AddOverride(override Override, duration time.Duration) (Override, error) {
+	if duration <= 0 {
+		return override, fmt.Errorf("duration must be > 0")
+	}
+
+	switch override.Kind {
+	case OverridePin, OverrideActiveSet:
+		if len(override.AgentIDs) == 0 {
+			return override, fmt.Errorf("%s requires agent_ids", override.Kind)
+		}
+		if len(override.Selector) > 0 {
+			return override, fmt.Errorf("%s takes agent_ids, not a selector", override.Kind)
+		}
+		if override.Kind == OverridePin && override.Bandwidth <= 0 {
+			return override, fmt.Errorf("pin requires a bandwidth > 0")
+		}
+	case OverrideExclude:
+		if len(override.AgentIDs) == 0 && len(override.Selector) == 0 {
+			return override, fmt.Errorf("exclude requires agent_ids or a selector")
+		}
+	default:
+		return override, fmt.Errorf("unknown override kind %q", override.Kind)
+	}
+	for _, agentID := range override.AgentIDs {
+		if _, ok := s.agentConfig(agentID); !ok {
+			return override, fmt.Errorf("unknown agent %s", agentID)
+		}
+	}
+	if !s.server.IsLeader() {
+		return override, ErrNotLeader
+	}
+
+	now := time.Now()
+	override.ID = uuid.New().String()
+	override.CreatedAt = now
+	override.ExpiresAt = now.Add(duration)
+
+	s.overrides.mu.Lock()
+	for _, existing := range s.overrides.list {
+		if override.Kind == OverrideActiveSet && existing.Kind == OverrideActiveSet && now.Before(existing.ExpiresAt) {
+			s.overrides.mu.Unlock()
+			return override, fmt.Errorf("active set %s is already in force", existing.ID)
+		}
+	}
+	s.overrides.list = append(s.overrides.list, override)
+	s.overrides.mu.Unlock()
+
+	s.logger.Infow("Override added",
+		"override_id", override.ID,
+		"kind", override.Kind,
+		"agents", override.AgentIDs,
+		"selector", override.Selector,
+		"bandwidth", override.Bandwidth,
+		"expires_at", override.ExpiresAt,
+		"reason", override.Reason,
+	)
+	s.overridesChanged()
+	return override, nil
+}
+
+// RemoveOverride lifts an override before it expires
+func (s *Scheduler) RemoveOverride(id string) error {
+	if !s.server.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.overrides.mu.Lock()
+	removed := false
+	for i, override := range s.overrides.list {
+		if override.ID == id {
+			s.overrides.list = append(s.overrides.list[:i], s.overrides.list[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	s.overrides.mu.Unlock()
+	if !removed {
+		return ErrOverrideNotFound
+	}
+
+	s.logger.Infow("Override removed", "override_id", id)
+	s.overridesChanged()
+	return nil
+}
+
+// GetOverrides returns the overrides in force (for API)
+func (s *Scheduler) GetOverrides() []Override {
+	return s.overrides.active(time.Now())
+}
+
+// expireOverrides drops overrides that ran out. Must be called with s.mu held.
+func (s *Scheduler) expireOverrides() {
+	expired := s.overrides.expire(time.Now())
+	if len(expired) == 0 {
+		return
+	}
+	for _, override := range expired {
+		s.logger.Infow("Override expired", "override_id", override.ID, "kind", override.Kind)
+	}
+	s.state.NextRotation = time.Now()
+	s.saveOverrides()
+}
+
+// overridesChanged persists the overrides and brings the next rotation
+// forward so the schedule reflects them
+func (s *Scheduler) overridesChanged() {
+	s.mu.Lock()
+	s.state.NextRotation = time.Now()
+	s.mu.Unlock()
+	s.saveOverrides()
+}
+
+// saveOverrides persists the overrides
+func (s *Scheduler) saveOverrides() {
+	if s.store == nil {
+		return
+	}
+	s.overrides.mu.RLock()
+	list := append([]Override(nil), s.overrides.list...)
+	s.overrides.mu.RUnlock()
+
+	if err := s.store.Put(bucketScheduler, s.storeKey(keyOverrides), list); err != nil {
+		s.logger.Warnw("Failed to persist overrides", "error", err)
+	}
+}
+
+// restoreOverrides loads the overrides set under a previous leader
+func (s *Scheduler) restoreOverrides() {
+	if s.store == nil {
+		return
+	}
+
+	var list []Override
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keyOverrides), &list)
+	if err != nil {
+		s.logger.Warnw("Failed to restore overrides", "error", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	s.overrides.mu.Lock()
+	s.overrides.list = list
+	s.overrides.mu.Unlock()
+	if active := s.overrides.active(time.Now()); len(active) > 0 {
+		s.logger.Infow("Restored overrides", "count", len(active))
+	}
+}
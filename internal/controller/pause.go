@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrPaused is returned for operations the paused scheduler holds off
+	ErrPaused = errors.New("scheduler is paused")
+	// ErrNotPaused is returned when resuming a scheduler that is not paused
+	ErrNotPaused = errors.New("scheduler is not paused")
+	// ErrRotationInProgress is returned when a rotation cannot be requested
+	// because one is running
+	ErrRotationInProgress = errors.New("rotation in progress")
+)
+
+// PauseStatus reports whether the scheduler is paused, and by whom
+type PauseStatus struct {
+	Paused   bool      `json:"paused"`
+	PausedBy string    `json:"paused_by,omitempty"`
+	PausedAt time.Time `json:"paused_at,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Pause freezes the current allocation: running jobs are held as they are,
+// and no rotations, boosts, control corrections, reallocations or drains
+// begin until Resume. A rotation in progress is cancelled where it is.
+func (s *Scheduler) Pause(by, reason string) error {
+	if !s.server.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.mu.Lock()
+	if s.pause.Paused {
+		s.mu.Unlock()
+		return ErrPaused
+	}
+	s.pause = PauseStatus{
+		Paused:   true,
+		PausedBy: by,
+		PausedAt: time.Now(),
+		Reason:   reason,
+	}
+	s.mu.Unlock()
+
+	if err := s.CancelRotation("scheduler paused"); err != nil && !errors.Is(err, ErrNoRotation) {
+		s.logger.Warnw("Failed to cancel rotation", "error", err)
+	}
+
+	s.logger.Infow("Scheduler paused", "by", by, "reason", reason)
+	s.events.Publish(Event{Type: EventPause, Phase: "paused", Reason: reason})
+	s.saveState()
+	return nil
+}
+
+// Resume lets the scheduler act again. A rotation that fell due while it
+// was paused begins on the next evaluation.
+func (s *Scheduler) Resume(by string) error {
+	if !s.server.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.mu.Lock()
+	if !s.pause.Paused {
+		s.mu.Unlock()
+		return ErrNotPaused
+	}
+	pausedFor := time.Since(s.pause.PausedAt)
+	s.pause = PauseStatus{}
+	s.mu.Unlock()
+
+	s.logger.Infow("Scheduler resumed", "by", by, "paused_for", pausedFor.Round(time.Second))
+	s.events.Publish(Event{Type: EventPause, Phase: "resumed"})
+	s.saveState()
+	return nil
+}
+
+// RotateNow brings the next rotation forward to the next evaluation
+func (s *Scheduler) RotateNow(by string) error {
+	return s.SetNextRotation(time.Now(), by)
+}
+
+// SetNextRotation sets when the next rotation begins. Rotations after it
+// are scheduled at random intervals again.
+func (s *Scheduler) SetNextRotation(at time.Time, by string) error {
+	if !s.server.IsLeader() {
+		return ErrNotLeader
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pause.Paused {
+		return ErrPaused
+	}
+	if s.rotation != nil {
+		return ErrRotationInProgress
+	}
+	if at.Before(time.Now().Add(-time.Second)) {
+		return fmt.Errorf("next rotation must not be in the past")
+	}
+
+	s.state.NextRotation = at
+	s.logger.Infow("Next rotation set",
+		"by", by,
+		"next_time", at.Format("15:04:05"),
+	)
+	return nil
+}
+
+// GetPause returns whether the scheduler is paused (for API)
+func (s *Scheduler) GetPause() PauseStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pause
+}
+
+// paused reports whether the scheduler is paused
+func (s *Scheduler) paused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pause.Paused
+}
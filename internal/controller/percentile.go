@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store key for the p95 samples
+const keyP95Samples = "p95_samples"
+
+// p95SampleInterval is the interval transit is billed in: the month's
+// 95th percentile is taken over the averages of these intervals
+const p95SampleInterval = 5 * time.Minute
+
+// validate checks the p95 settings against the target they steer to. Errors
+// carry the settings' path in the config.
+func (c P95Config) validate(targetGbps float64) error {
+	if _, err := parseBillingPeriod(TransferBudgetConfig{AnchorDay: c.AnchorDay, Timezone: c.Timezone}); err != nil {
+		return fmt.Errorf("bandwidth.p95: %w", err)
+	}
+	if c.BaseGbps < 0 || c.BaseGbps >= targetGbps {
+		return fmt.Errorf("bandwidth.p95.base_gbps must be at least 0 and below bandwidth.target_gbps")
+	}
+	if c.Headroom < 0 {
+		return fmt.Errorf("bandwidth.p95.headroom must not be negative")
+	}
+	if c.SafetyMargin < 0 {
+		return fmt.Errorf("bandwidth.p95.safety_margin must not be negative")
+	}
+	if c.Block < p95SampleInterval || c.Block%p95SampleInterval != 0 {
+		return fmt.Errorf("bandwidth.p95.block must be a multiple of %s", p95SampleInterval)
+	}
+	return nil
+}
+
+// P95Status is the 95th percentile of the pool's 5-minute averages in the
+// current billing month
+type P95Status struct {
+	MonthStart   time.Time `json:"month_start"`
+	MonthEnd     time.Time `json:"month_end"`
+	TargetMbps   float64   `json:"target_mbps"`
+	Samples      int       `json:"samples"`       // 5-minute averages taken so far
+	MonthSamples int       `json:"month_samples"` // 5-minute intervals in the month
+	AtTarget     int       `json:"at_target"`     // Samples at or above the target
+	Required     int       `json:"required"`      // Samples at the target the plan collects, margin included
+	Bursting     bool      `json:"bursting"`
+	CurrentP95   float64   `json:"current_p95"`   // Of the samples so far, Mbps
+	ProjectedP95 float64   `json:"projected_p95"` // At month end if the remaining bursts reach the target, Mbps
+}
+
+// percentileMeter averages the measured total into 5-minute samples and
+// decides when the pool bursts to the target. Only enough samples reach the
+// target for the month's p95 to land on it; the rest run at the base. It
+// has its own lock so the API can read it without s.mu.
+type percentileMeter struct {
+	mu         sync.Mutex
+	config     P95Config
+	period     billingPeriod
+	start      time.Time // Billing month
+	end        time.Time
+	samples    []float64 // 5-minute averages of the month, Mbps
+	saved      int       // Samples persisted
+	interval   time.Time // Start of the interval being averaged
+	sum        float64
+	count      int
+	block      time.Time // Start of the block the burst decision is for
+	bursting   bool
+	targetMbps float64 // Target last steered to, before bursts add headroom
+}
+
+// newPercentileMeter creates a meter for the billing month containing now,
+// steering to targetMbps until told otherwise. The config was validated, so
+// a billing month that fails to parse is a calendar month in local time.
+func newPercentileMeter(config P95Config, targetMbps float64, now time.Time) *percentileMeter {
+	period, err := parseBillingPeriod(TransferBudgetConfig{AnchorDay: config.AnchorDay, Timezone: config.Timezone})
+	if err != nil {
+		period = billingPeriod{anchorDay: 1, location: time.Local}
+	}
+	m := &percentileMeter{config: config, period: period, targetMbps: targetMbps}
+	m.roll(now)
+	return m
+}
+
+// roll starts a new billing month if the current one has ended
+func (m *percentileMeter) roll(now time.Time) {
+	if now.Before(m.end) {
+		return
+	}
+	m.start, m.end = m.period.bounds(now)
+	m.samples = nil
+	m.saved = 0
+	m.sum, m.count = 0, 0
+	m.block = time.Time{}
+	m.bursting = false
+}
+
+// observe adds a measurement of the total to the current interval's
+// average, closing the previous interval if one has passed
+func (m *percentileMeter) observe(now time.Time, mbps float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	interval := now.Truncate(p95SampleInterval)
+	if !interval.Equal(m.interval) {
+		if m.count > 0 && !m.interval.Before(m.start) {
+			m.samples = append(m.samples, m.sum/float64(m.count))
+		}
+		m.interval = interval
+		m.sum, m.count = 0, 0
+	}
+	m.sum += mbps
+	m.count++
+}
+
+// steer returns the target to apply now for a p95 target, deciding at each
+// block whether to burst. It also returns true if that decision changed.
+func (m *percentileMeter) steer(now time.Time, targetMbps float64) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	m.targetMbps = targetMbps
+	changed := false
+	if block := now.Truncate(m.config.Block); !block.Equal(m.block) {
+		m.block = block
+		bursting := m.behind(block.Add(m.config.Block), targetMbps)
+		changed = bursting != m.bursting
+		m.bursting = bursting
+	}
+
+	if m.bursting {
+		return targetMbps * (1 + m.config.Headroom), changed
+	}
+	return m.config.BaseGbps * 1000, changed
+}
+
+// behind reports whether fewer samples reached the target than an even
+// spread over the month would have by until. Must be called with m.mu held.
+func (m *percentileMeter) behind(until time.Time, targetMbps float64) bool {
+	have, required := m.atTarget(targetMbps), m.required()
+	if have >= required {
+		return false
+	}
+	share := until.Sub(m.start).Seconds() / m.end.Sub(m.start).Seconds()
+	return float64(have) < float64(required)*share
+}
+
+// monthSamples returns the number of intervals in the billing month.
+// Must be called with m.mu held.
+func (m *percentileMeter) monthSamples() int {
+	return int(m.end.Sub(m.start) / p95SampleInterval)
+}
+
+// required returns the samples to collect at the target: one more than the
+// top 5% the p95 discards, plus the safety margin. Must be called with
+// m.mu held.
+func (m *percentileMeter) required() int {
+	needed := m.monthSamples()/20 + 1
+	return int(math.Ceil(float64(needed) * (1 + m.config.SafetyMargin)))
+}
+
+// atTarget counts the samples at or above the target. Must be called with
+// m.mu held.
+func (m *percentileMeter) atTarget(targetMbps float64) int {
+	n := 0
+	for _, sample := range m.samples {
+		if sample >= targetMbps {
+			n++
+		}
+	}
+	return n
+}
+
+// percentile95 returns the 95th percentile of samples the way transit is
+// billed: the highest sample once the top 5% are discarded
+func percentile95(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)-1-len(sorted)/20]
+}
+
+// status returns the month's p95 so far and projected, against the target
+// last steered to. The projection counts intervals without a sample as idle,
+// and assumes the bursts still to come reach the target and the rest of the
+// month runs at the base.
+func (m *percentileMeter) status(now time.Time) P95Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	targetMbps := m.targetMbps
+	total := m.monthSamples()
+	elapsed := int(now.Sub(m.start) / p95SampleInterval)
+	if elapsed < len(m.samples) {
+		elapsed = len(m.samples)
+	}
+	have, required := m.atTarget(targetMbps), m.required()
+
+	projected := make([]float64, 0, total)
+	projected = append(projected, m.samples...)
+	for i := len(m.samples); i < elapsed; i++ {
+		projected = append(projected, 0)
+	}
+	bursts := required - have
+	for i := elapsed; i < total; i++ {
+		if bursts > 0 {
+			projected = append(projected, targetMbps)
+			bursts--
+		} else {
+			projected = append(projected, m.config.BaseGbps*1000)
+		}
+	}
+
+	return P95Status{
+		MonthStart:   m.start,
+		MonthEnd:     m.end,
+		TargetMbps:   targetMbps,
+		Samples:      len(m.samples),
+		MonthSamples: total,
+		AtTarget:     have,
+		Required:     required,
+		Bursting:     m.bursting,
+		CurrentP95:   percentile95(m.samples),
+		ProjectedP95: percentile95(projected),
+	}
+}
+
+// p95Snapshot is the persisted p95 samples
+type p95Snapshot struct {
+	MonthStart time.Time `json:"month_start"`
+	Samples    []float64 `json:"samples"`
+}
+
+// observeP95 samples the measured total in p95 target mode.
+// Must be called with s.mu held.
+func (s *Scheduler) observeP95(agg AggregatedMetrics) {
+	if s.percentile != nil {
+		s.percentile.observe(time.Now(), agg.TotalBandwidth)
+	}
+}
+
+// GetP95 returns the pool's p95 for the billing month, and false if the
+// pool is not in p95 target mode (for API)
+func (s *Scheduler) GetP95() (P95Status, bool) {
+	if s.percentile == nil {
+		return P95Status{}, false
+	}
+	return s.percentile.status(time.Now()), true
+}
+
+// saveP95Samples persists the month's samples if more were taken since
+func (s *Scheduler) saveP95Samples() {
+	if s.store == nil || s.percentile == nil {
+		return
+	}
+
+	m := s.percentile
+	m.mu.Lock()
+	if len(m.samples) == m.saved {
+		m.mu.Unlock()
+		return
+	}
+	snapshot := p95Snapshot{
+		MonthStart: m.start,
+		Samples:    append([]float64(nil), m.samples...),
+	}
+	m.mu.Unlock()
+
+	if err := s.store.Put(bucketScheduler, s.storeKey(keyP95Samples), snapshot); err != nil {
+		s.logger.Warnw("Failed to persist p95 samples", "error", err)
+		return
+	}
+
+	m.mu.Lock()
+	if m.start.Equal(snapshot.MonthStart) && len(snapshot.Samples) > m.saved {
+		m.saved = len(snapshot.Samples)
+	}
+	m.mu.Unlock()
+}
+
+// restoreP95Samples loads the samples taken before a restart or under a
+// previous leader, unless more have been taken since. Samples from a
+// billing month that has since ended are dropped.
+func (s *Scheduler) restoreP95Samples() {
+	if s.store == nil || s.percentile == nil {
+		return
+	}
+
+	var snapshot p95Snapshot
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keyP95Samples), &snapshot)
+	if err != nil {
+		s.logger.Warnw("Failed to restore p95 samples", "error", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	m := s.percentile
+	m.mu.Lock()
+	m.roll(time.Now())
+	restored := m.start.Equal(snapshot.MonthStart) && len(snapshot.Samples) > len(m.samples)
+	if restored {
+		m.samples = snapshot.Samples
+		m.saved = len(snapshot.Samples)
+	}
+	m.mu.Unlock()
+
+	if restored {
+		s.logger.Infow("Restored p95 samples", "samples", len(snapshot.Samples))
+	}
+}
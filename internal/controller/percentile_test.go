@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile95(t *testing.T) {
+	series := func(n int) []float64 {
+		samples := make([]float64, n)
+		for i := range samples {
+			samples[n-1-i] = float64(i + 1) // Descending, to check sorting
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		samples []float64
+		want    float64
+	}{
+		{name: "empty", samples: nil, want: 0},
+		{name: "single", samples: []float64{42}, want: 42},
+		{name: "fewer than twenty keep the top", samples: series(19), want: 19},
+		{name: "twenty drop the top", samples: series(20), want: 19},
+		{name: "hundred drop the top five", samples: series(100), want: 95},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile95(tt.samples); got != tt.want {
+				t.Fatalf("percentile95 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentileMeterRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		now    time.Time
+		margin float64
+		want   int
+	}{
+		{name: "30-day month", now: time.Date(2026, 4, 10, 0, 0, 0, 0, time.UTC), want: 433},
+		{name: "31-day month", now: time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC), want: 447},
+		{name: "31-day month with margin", now: time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC), margin: 0.1, want: 492},
+		{name: "28-day month with margin", now: time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC), margin: 0.1, want: 445},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := P95Config{AnchorDay: 1, Timezone: "UTC", SafetyMargin: tt.margin, Block: time.Hour}
+			m := newPercentileMeter(config, 1000, tt.now)
+			if got := m.required(); got != tt.want {
+				t.Fatalf("required = %d, want %d", got, tt.want)
+			}
+
+			// Collecting the required samples at the target puts the p95 on it
+			samples := make([]float64, m.monthSamples())
+			for i := 0; i < tt.want; i++ {
+				samples[i] = 1000
+			}
+			if got := percentile95(samples); got != 1000 {
+				t.Fatalf("p95 with required samples = %v, want 1000", got)
+			}
+		})
+	}
+}
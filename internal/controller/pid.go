@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// PIDState exposes the controller internals for debugging
+type PIDState struct {
+	Kp           float64   `json:"kp"`
+	Ki           float64   `json:"ki"`
+	Kd           float64   `json:"kd"`
+	Setpoint     float64   `json:"setpoint"`
+	Measured     float64   `json:"measured"`
+	Error        float64   `json:"error"`
+	Proportional float64   `json:"proportional"`
+	Integral     float64   `json:"integral"`
+	Derivative   float64   `json:"derivative"`
+	Output       float64   `json:"output"`
+	OutputLimit  float64   `json:"output_limit"`
+	Saturated    bool      `json:"saturated"`
+	Updates      int64     `json:"updates"`
+	LastUpdate   time.Time `json:"last_update"`
+}
+
+// PIDController is a PID controller with output clamping and conditional
+// integration as anti-windup. The derivative acts on the measurement so
+// setpoint changes do not cause output spikes.
+type PIDController struct {
+	mu    sync.Mutex
+	state PIDState
+
+	integral     float64 // Accumulated error, in error-seconds
+	prevMeasured float64
+	primed       bool
+}
+
+// NewPIDController creates a PID controller with the given gains
+func NewPIDController(kp, ki, kd float64) *PIDController {
+	return &PIDController{
+		state: PIDState{Kp: kp, Ki: ki, Kd: kd},
+	}
+}
+
+// Update feeds a new measurement and returns the output, clamped to
+// ±outputLimit
+func (p *PIDController) Update(setpoint, measured, outputLimit float64, now time.Time) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &p.state
+	errorValue := setpoint - measured
+
+	dt := 0.0
+	if p.primed {
+		dt = now.Sub(s.LastUpdate).Seconds()
+	}
+
+	s.Proportional = s.Kp * errorValue
+
+	s.Derivative = 0
+	if p.primed && dt > 0 {
+		s.Derivative = -s.Kd * (measured - p.prevMeasured) / dt
+	}
+
+	// Only integrate as far as the output saturates, so a saturated output
+	// is not pushed further out but a large error still winds it up to it
+	candidate := p.integral + errorValue*dt
+	if s.Ki > 0 {
+		unclamped := s.Proportional + s.Ki*candidate + s.Derivative
+		if errorValue > 0 && unclamped > outputLimit {
+			candidate = math.Max(p.integral, (outputLimit-s.Proportional-s.Derivative)/s.Ki)
+		} else if errorValue < 0 && unclamped < -outputLimit {
+			candidate = math.Min(p.integral, (-outputLimit-s.Proportional-s.Derivative)/s.Ki)
+		}
+	}
+	p.integral = candidate
+
+	// Keep the integral term alone within the output range
+	if s.Ki > 0 {
+		limit := outputLimit / s.Ki
+		p.integral = math.Max(-limit, math.Min(limit, p.integral))
+	}
+	s.Integral = s.Ki * p.integral
+
+	output := s.Proportional + s.Integral + s.Derivative
+	s.Saturated = math.Abs(output) > outputLimit
+	output = math.Max(-outputLimit, math.Min(outputLimit, output))
+
+	s.Setpoint = setpoint
+	s.Measured = measured
+	s.Error = errorValue
+	s.Output = output
+	s.OutputLimit = outputLimit
+	s.Updates++
+	s.LastUpdate = now
+
+	p.prevMeasured = measured
+	p.primed = true
+
+	return output
+}
+
+// Hold keeps the current output without integrating, for periods where the
+// measurement is not meaningful, such as during ramps
+func (p *PIDController) Hold() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Restart the derivative and dt from the next update
+	p.primed = false
+	return p.state.Output
+}
+
+// Reset clears all accumulated state
+func (p *PIDController) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = PIDState{Kp: p.state.Kp, Ki: p.state.Ki, Kd: p.state.Kd}
+	p.integral = 0
+	p.prevMeasured = 0
+	p.primed = false
+}
+
+// State returns a snapshot of the controller internals
+func (p *PIDController) State() PIDState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
+)
+
+func TestPIDControllerUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		kp, ki   float64
+		setpoint float64
+		measured []float64
+		limit    float64
+		want     float64
+	}{
+		{name: "raises when below target", kp: 0.5, setpoint: 1000, measured: []float64{800}, limit: 500, want: 100},
+		{name: "lowers when above target", kp: 0.5, setpoint: 1000, measured: []float64{1200}, limit: 500, want: -100},
+		{name: "clamped to limit", kp: 1, setpoint: 1000, measured: []float64{0}, limit: 200, want: 200},
+		{name: "integral accumulates", ki: 0.1, setpoint: 1000, measured: []float64{900, 900, 900}, limit: 500, want: 20},
+		{name: "integral held at limit", ki: 1, setpoint: 1000, measured: []float64{0, 0, 0}, limit: 200, want: 200},
+		{name: "unwinds from saturation", ki: 1, setpoint: 1000, measured: []float64{0, 0, 1100}, limit: 200, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := NewPIDController(tt.kp, tt.ki, 0)
+			now := time.Unix(0, 0)
+			var got float64
+			for _, measured := range tt.measured {
+				got = pid.Update(tt.setpoint, measured, tt.limit, now)
+				now = now.Add(time.Second)
+			}
+			if got != tt.want {
+				t.Fatalf("output = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPIDControllerReset(t *testing.T) {
+	pid := NewPIDController(0, 1, 0)
+	now := time.Unix(0, 0)
+	pid.Update(1000, 900, 500, now)
+	pid.Update(1000, 900, 500, now.Add(time.Second))
+	pid.Reset()
+
+	if got := pid.Update(1000, 1000, 500, now.Add(2*time.Second)); got != 0 {
+		t.Fatalf("output after reset = %v, want 0", got)
+	}
+}
+
+// newTestScheduler builds the scheduler of a single pool with two agents
+// connected to a server without persistence
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "controller.yaml")
+	config := `
+server:
+  auth_token: "test"
+bandwidth:
+  target_gbps: 1.6
+urls:
+  - "https://example.com/file"
+scheduler:
+  server_bandwidth_min: 400
+  server_bandwidth_max: 1200
+agents:
+  - id: "agent-001"
+    host: "vps1.example.com"
+    max_bandwidth: 1500
+  - id: "agent-002"
+    host: "vps2.example.com"
+    max_bandwidth: 1000
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	server := NewServer(cfg, nil, nil, logger.NewDefault())
+	for _, agent := range cfg.Agents {
+		server.clients.Store(agent.ID, &Client{AgentID: agent.ID, Queue: NewSendQueue(16, 0)})
+	}
+	return server.schedulers[0]
+}
+
+func TestDistributeCorrection(t *testing.T) {
+	tests := []struct {
+		name       string
+		correction float64
+		want       map[string]int64
+	}{
+		{name: "raises above the plan", correction: 400, want: map[string]int64{"agent-001": 1000, "agent-002": 1000}},
+		{name: "raises up to agent limits", correction: 2000, want: map[string]int64{"agent-001": 1200, "agent-002": 1000}},
+		{name: "lowers below the plan", correction: -400, want: map[string]int64{"agent-001": 600, "agent-002": 600}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t)
+			for agentID := range tt.want {
+				s.state.ActiveAgents[agentID] = &AgentAllocation{
+					AgentID:        agentID,
+					AllocatedBW:    800,
+					CommandedBW:    800,
+					CurrentCommand: "cmd-" + agentID,
+				}
+			}
+
+			s.mu.Lock()
+			s.distributeCorrection(tt.correction)
+			s.mu.Unlock()
+
+			for agentID, want := range tt.want {
+				if got := s.state.ActiveAgents[agentID].CommandedBW; got != want {
+					t.Errorf("%s commanded %d, want %d", agentID, got, want)
+				}
+			}
+		})
+	}
+}
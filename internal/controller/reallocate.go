@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/bandwidth"
+)
+
+// lostAllocation is bandwidth left unserved by an agent that disconnected
+// or was drained
+type lostAllocation struct {
+	agentID   string
+	bandwidth int64
+}
+
+// recordLostAllocation notes the share of an agent that disconnected so the
+// main loop can hand it to other agents. Agents leaving in the current
+// rotation are not missed. Must be called with s.mu held.
+func (s *Scheduler) recordLostAllocation(agentID string, alloc *AgentAllocation, wasActive bool) {
+	if s.draining || !s.server.IsLeader() {
+		return
+	}
+
+	var lost int64
+	if r := s.rotation; r != nil {
+		next, planned := r.allocations[agentID]
+		if !planned {
+			return
+		}
+		lost = next.AllocatedBW
+		delete(r.allocations, agentID)
+	} else if wasActive {
+		lost = alloc.AllocatedBW
+	}
+
+	if lost > 0 {
+		s.lost = append(s.lost, lostAllocation{agentID: agentID, bandwidth: lost})
+	}
+}
+
+// reallocateLost hands the bandwidth of disconnected agents to the agents
+// still scheduled, within their capacity, and starts standby agents for
+// whatever does not fit
+func (s *Scheduler) reallocateLost() {
+	s.mu.Lock()
+	if s.pause.Paused {
+		// Held until the scheduler resumes
+		s.mu.Unlock()
+		return
+	}
+	lost := s.lost
+	s.lost = nil
+	if len(lost) == 0 || s.draining {
+		s.mu.Unlock()
+		return
+	}
+
+	var total int64
+	for _, l := range lost {
+		total += l.bandwidth
+		s.logger.Warnw("Reallocating bandwidth of departed agent",
+			"agent_id", l.agentID,
+			"bandwidth", l.bandwidth,
+		)
+	}
+
+	remaining := s.redistribute(total)
+	s.mu.Unlock()
+
+	remaining = s.startStandbyAgents(remaining)
+
+	s.logger.Infow("Reallocated lost bandwidth",
+		"lost", total,
+		"unplaced", remaining,
+	)
+
+	if remaining <= 0 {
+		return
+	}
+
+	// Agents a rotation has yet to stop are better kept running than the
+	// target missed
+	if err := s.CancelRotation("lost bandwidth could not be reallocated"); err != nil && !errors.Is(err, ErrNoRotation) {
+		s.logger.Warnw("Failed to cancel rotation", "error", err)
+	}
+}
+
+// scheduledAllocations returns the allocations sharing out lost bandwidth:
+// the new schedule during a rotation, otherwise the active agents.
+// Must be called with s.mu held.
+func (s *Scheduler) scheduledAllocations() map[string]*AgentAllocation {
+	if s.rotation != nil {
+		return s.rotation.allocations
+	}
+	return s.state.ActiveAgents
+}
+
+// redistribute raises scheduled allocations in proportion to their headroom
+// to make up for the given bandwidth, adjusting agents already running.
+// Regions are not raised past their quota. It returns the bandwidth that did
+// not fit. Must be called with s.mu held.
+func (s *Scheduler) redistribute(lost int64) int64 {
+	allocations := s.scheduledAllocations()
+
+	headroom := make(map[string]int64, len(allocations))
+	regionHeadroom := make(map[string]int64)
+	for agentID, alloc := range allocations {
+		agent, ok := s.agentConfig(agentID)
+		if !ok {
+			continue
+		}
+		if _, pinned := s.overrides.pin(agentID); pinned {
+			continue
+		}
+		capacity := s.deliverableCapacity(agent)
+		if capacity <= alloc.AllocatedBW {
+			continue
+		}
+		headroom[agentID] = capacity - alloc.AllocatedBW
+		regionHeadroom[agent.Region] += headroom[agentID]
+	}
+
+	// Scale headroom down in regions close to their maximum
+	room := s.regionRoom(allocations)
+	var totalHeadroom int64
+	for agentID := range headroom {
+		agent, _ := s.agentConfig(agentID)
+		if limit, limited := room[agent.Region]; limited && limit < regionHeadroom[agent.Region] {
+			headroom[agentID] = headroom[agentID] * bandwidth.Max64(limit, 0) / regionHeadroom[agent.Region]
+		}
+		totalHeadroom += headroom[agentID]
+	}
+	if totalHeadroom == 0 {
+		return lost
+	}
+
+	placing := bandwidth.Min64(lost, totalHeadroom)
+	var placed int64
+	for agentID, room := range headroom {
+		share := placing * room / totalHeadroom
+		if share <= 0 {
+			continue
+		}
+
+		alloc := allocations[agentID]
+		alloc.AllocatedBW += share
+		placed += share
+
+		// Agents not started yet pick the new allocation up when they start
+		running, ok := s.state.ActiveAgents[agentID]
+		if !ok || running.CurrentCommand == "" {
+			continue
+		}
+		if running != alloc {
+			// Continuing through a rotation: raise the current allocation too
+			running.AllocatedBW += share
+		}
+
+		desired := running.CommandedBW + share
+		if err := s.adjustAgent(agentID, running.CurrentCommand, desired); err != nil {
+			s.logger.Warnw("Failed to raise agent bandwidth",
+				"agent_id", agentID,
+				"error", err,
+			)
+			continue
+		}
+		running.setCommandedBW(desired)
+	}
+
+	return lost - placed
+}
+
+// startStandbyAgents starts connected agents that are not scheduled to cover
+// the given bandwidth, largest first and within the concurrency limit.
+// It returns the bandwidth still not covered.
+func (s *Scheduler) startStandbyAgents(needed int64) int64 {
+	if needed <= 0 {
+		return needed
+	}
+
+	for _, agent := range s.standbyAgents() {
+		if needed <= 0 {
+			break
+		}
+
+		s.mu.RLock()
+		full := len(s.scheduledAllocations()) >= s.config.Scheduler.MaxConcurrent
+		room, limited := s.regionRoom(s.scheduledAllocations())[agent.Region]
+		fits := s.scheduledTopology().fits(agent)
+		s.mu.RUnlock()
+		if full {
+			s.logger.Warnw("Max concurrent agents reached, cannot start standby agent", "unplaced", needed)
+			break
+		}
+		if !fits {
+			continue // A topology rule allows no more agents like it
+		}
+
+		limits := s.agentLimits(agent)
+		if limited {
+			if room < limits.Min {
+				continue // Its region is at its quota
+			}
+			limits.Max = bandwidth.Min64(limits.Max, room)
+		}
+
+		alloc := &AgentAllocation{
+			AgentID:     agent.ID,
+			AllocatedBW: bandwidth.Clamp64(needed, limits.Min, limits.Max),
+			StartTime:   time.Now(),
+		}
+
+		s.startAgent(alloc)
+		if alloc.CurrentCommand == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.state.ActiveAgents[agent.ID] = alloc
+		if s.rotation != nil {
+			s.rotation.allocations[agent.ID] = alloc
+		}
+		s.mu.Unlock()
+
+		s.logger.Infow("Started standby agent to cover lost bandwidth",
+			"agent_id", agent.ID,
+			"bandwidth", alloc.AllocatedBW,
+		)
+		needed -= alloc.AllocatedBW
+	}
+
+	return needed
+}
+
+// standbyAgents returns connected, healthy, schedulable agents that are
+// neither active nor part of a rotation in progress, largest deliverable
+// capacity first
+func (s *Scheduler) standbyAgents() []AgentConfig {
+	connected := make(map[string]bool)
+	for _, agentID := range s.connectedAgents() {
+		connected[agentID] = true
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	var standby []AgentConfig
+	for _, agent := range s.config.Agents {
+		if !connected[agent.ID] || s.server.IsAgentDegraded(agent.ID) || s.calibrations.isRunning(agent.ID) {
+			continue
+		}
+		if !s.schedulable(agent.ID, now) || s.overrides.excluded(agent) {
+			continue
+		}
+		if set := s.overrides.activeSet(); set != nil && !containsString(set, agent.ID) {
+			continue
+		}
+		if _, active := s.state.ActiveAgents[agent.ID]; active {
+			continue
+		}
+		if s.rotation != nil && s.rotation.planned(agent.ID) {
+			continue
+		}
+		standby = append(standby, agent)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(standby, func(i, j int) bool {
+		return s.deliverableCapacity(standby[i]) > s.deliverableCapacity(standby[j])
+	})
+	return standby
+}
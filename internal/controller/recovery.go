@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+// Store keys for scheduler state
+const (
+	keySchedulerState = "state"
+)
+
+// schedulerSnapshot is the persisted form of the scheduler state
+type schedulerSnapshot struct {
+	SavedAt       time.Time                   `json:"saved_at"`
+	StartTime     time.Time                   `json:"start_time"`
+	Phase         string                      `json:"phase"`
+	ActiveAgents  map[string]*AgentAllocation `json:"active_agents"`
+	NextRotation  time.Time                   `json:"next_rotation"`
+	LastRotation  time.Time                   `json:"last_rotation"`
+	RotationCount int                         `json:"rotation_count"`
+	Pause         PauseStatus                 `json:"pause"`
+}
+
+// saveState persists the scheduler state and agent usage history
+func (s *Scheduler) saveState() {
+	if s.store == nil {
+		return
+	}
+
+	s.mu.RLock()
+	snapshot := schedulerSnapshot{
+		SavedAt:       time.Now(),
+		StartTime:     s.startTime,
+		Phase:         s.state.Phase,
+		ActiveAgents:  make(map[string]*AgentAllocation, len(s.state.ActiveAgents)),
+		NextRotation:  s.state.NextRotation,
+		LastRotation:  s.state.LastRotation,
+		RotationCount: s.state.RotationCount,
+		Pause:         s.pause,
+	}
+	for agentID, alloc := range s.state.ActiveAgents {
+		snapshot.ActiveAgents[agentID] = copyAllocation(alloc)
+	}
+
+	statuses := make(map[string]interface{}, len(s.agentStatus))
+	for agentID, status := range s.agentStatus {
+		statusCopy := *status
+		statuses[agentID] = &statusCopy
+	}
+	s.mu.RUnlock()
+
+	if err := s.store.Put(bucketScheduler, s.storeKey(keySchedulerState), snapshot); err != nil {
+		s.logger.Warnw("Failed to persist scheduler state", "error", err)
+	}
+	if err := s.store.PutAll(bucketAgents, statuses); err != nil {
+		s.logger.Warnw("Failed to persist agent status", "error", err)
+	}
+	s.saveModel()
+	s.saveTransferUsage()
+	s.saveP95Samples()
+	s.saveVolume()
+}
+
+// restoreState loads persisted state from a previous run. It returns true
+// if a schedule was restored and should be continued rather than replaced.
+func (s *Scheduler) restoreState() bool {
+	if s.store == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Agent usage history
+	for agentID, status := range s.agentStatus {
+		var saved AgentStatus
+		found, err := s.store.Get(bucketAgents, agentID, &saved)
+		if err != nil {
+			s.logger.Warnw("Failed to restore agent status", "agent_id", agentID, "error", err)
+			continue
+		}
+		if found {
+			status.LastUsed = saved.LastUsed
+			status.TotalRuntime = saved.TotalRuntime
+			status.UseCount = saved.UseCount
+			status.Mode = saved.Mode
+		}
+	}
+
+	var snapshot schedulerSnapshot
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keySchedulerState), &snapshot)
+	if err != nil {
+		s.logger.Warnw("Failed to restore scheduler state", "error", err)
+		return false
+	}
+	if !found {
+		return false
+	}
+
+	// Keep the concurrency pattern continuous across restarts
+	if !snapshot.StartTime.IsZero() {
+		s.startTime = snapshot.StartTime
+	}
+	s.state.LastRotation = snapshot.LastRotation
+	s.state.RotationCount = snapshot.RotationCount
+	s.state.NextRotation = snapshot.NextRotation
+	s.pause = snapshot.Pause
+	if s.pause.Paused {
+		s.logger.Infow("Scheduler remains paused", "paused_by", s.pause.PausedBy, "paused_at", s.pause.PausedAt)
+	}
+
+	// Only agents that are still configured can be recovered
+	for agentID, alloc := range snapshot.ActiveAgents {
+		if _, known := s.agentStatus[agentID]; !known {
+			continue
+		}
+		s.state.ActiveAgents[agentID] = alloc
+		s.pendingReconcile[agentID] = true
+	}
+
+	s.logger.Infow("Restored scheduler state",
+		"saved_at", snapshot.SavedAt,
+		"rotation_count", snapshot.RotationCount,
+		"next_rotation", snapshot.NextRotation,
+		"active_agents", len(s.state.ActiveAgents),
+	)
+
+	if len(s.state.ActiveAgents) == 0 {
+		return false
+	}
+
+	s.state.Phase = "recovering"
+	return true
+}
+
+// reconcileAgent brings a reconnecting agent in line with the schedule.
+// Jobs it reports as still running are adopted into ActiveAgents or stopped
+// according to the adoption policy; an agent restored as active that is
+// running nothing is given its allocation again.
+func (s *Scheduler) reconcileAgent(agentID string, jobs []protocol.ActiveJob) {
+	s.mu.Lock()
+	pending := s.pendingReconcile[agentID]
+	restored, active := s.state.ActiveAgents[agentID]
+	delete(s.pendingReconcile, agentID)
+
+	if len(jobs) == 0 {
+		s.mu.Unlock()
+
+		if pending && active {
+			s.logger.Infow("Restarting restored agent allocation",
+				"agent_id", agentID,
+				"bandwidth", restored.AllocatedBW,
+			)
+			s.startAgent(restored)
+		}
+		return
+	}
+
+	// Jobs past their planned end are not worth keeping
+	var live []protocol.ActiveJob
+	var expired []string
+	for _, job := range jobs {
+		if remaining, err := time.ParseDuration(job.RemainingDuration); err == nil && remaining <= 0 {
+			expired = append(expired, job.CommandID)
+			continue
+		}
+		live = append(live, job)
+	}
+
+	reason := s.adoptionRefusal(agentID, active)
+	if reason == "" && len(live) > 0 {
+		alloc := allocationFromJobs(agentID, live)
+		s.state.ActiveAgents[agentID] = alloc
+		if status, ok := s.agentStatus[agentID]; ok {
+			status.LastUsed = time.Now()
+		}
+		s.mu.Unlock()
+
+		s.logger.Infow("Adopted running jobs from agent",
+			"agent_id", agentID,
+			"jobs", len(live),
+			"bandwidth", alloc.AllocatedBW,
+			"remaining", alloc.PlannedDuration.Round(time.Second),
+		)
+
+		for _, commandID := range expired {
+			s.stopCommand(agentID, commandID)
+		}
+		return
+	}
+
+	if active {
+		delete(s.state.ActiveAgents, agentID)
+	}
+	s.mu.Unlock()
+
+	if reason == "" {
+		reason = "all jobs expired"
+	}
+	s.logger.Infow("Stopping jobs reported by agent",
+		"agent_id", agentID,
+		"jobs", len(jobs),
+		"reason", reason,
+	)
+	s.stopAgent(agentID)
+}
+
+// adoptionRefusal returns why an agent's running jobs cannot be adopted,
+// or an empty string if they can. Must be called with s.mu held.
+func (s *Scheduler) adoptionRefusal(agentID string, alreadyActive bool) string {
+	if s.server.Fenced() {
+		return "emergency stop engaged"
+	}
+	if s.config.Scheduler.AdoptionPolicy == AdoptionPolicyStop {
+		return "adoption policy is stop"
+	}
+	if _, known := s.agentStatus[agentID]; !known {
+		return "agent not configured"
+	}
+	if !alreadyActive && len(s.state.ActiveAgents) >= s.config.Scheduler.MaxConcurrent {
+		return "max concurrent agents reached"
+	}
+	return ""
+}
+
+// allocationFromJobs builds an allocation describing jobs an agent is running.
+// The largest job is taken as the base command and the others as boosts.
+func allocationFromJobs(agentID string, jobs []protocol.ActiveJob) *AgentAllocation {
+	alloc := &AgentAllocation{
+		AgentID:   agentID,
+		StartTime: time.Now(),
+	}
+
+	var primary protocol.ActiveJob
+	for _, job := range jobs {
+		if job.Bandwidth >= primary.Bandwidth {
+			primary = job
+		}
+		if remaining, err := time.ParseDuration(job.RemainingDuration); err == nil && remaining > alloc.PlannedDuration {
+			alloc.PlannedDuration = remaining
+		}
+	}
+
+	alloc.CurrentCommand = primary.CommandID
+	alloc.URL = primary.URL
+	alloc.AllocatedBW = primary.Bandwidth
+	alloc.CommandedBW = primary.Bandwidth
+
+	for _, job := range jobs {
+		kind := CommandKindBoost
+		if job.CommandID == primary.CommandID {
+			kind = CommandKindBase
+		}
+		alloc.Commands = append(alloc.Commands, &TrackedCommand{
+			CommandID: job.CommandID,
+			Kind:      kind,
+			Bandwidth: job.Bandwidth,
+			URL:       job.URL,
+			StartedAt: alloc.StartTime,
+		})
+	}
+
+	return alloc
+}
+
+// requestAgentStatus asks every connected agent to report its running jobs
+// so they can be reconciled with the schedule
+func (s *Scheduler) requestAgentStatus() {
+	for _, agentID := range s.connectedAgents() {
+		s.mu.Lock()
+		s.awaitingStatus[agentID] = true
+		s.mu.Unlock()
+
+		if err := s.server.RequestStatus(agentID); err != nil {
+			s.logger.Warnw("Failed to request agent status", "agent_id", agentID, "error", err)
+		}
+	}
+}
+
+// OnAgentStatus is called when an agent reports its status
+func (s *Scheduler) OnAgentStatus(agentID string, status *protocol.StatusPayload) {
+	s.mu.Lock()
+	awaiting := s.awaitingStatus[agentID]
+	delete(s.awaitingStatus, agentID)
+	s.mu.Unlock()
+
+	if awaiting && s.server.IsLeader() {
+		s.reconcileAgent(agentID, status.Jobs)
+	}
+}
+
+// stepDown gives up scheduling after losing leadership. Agents keep running
+// their jobs for the new leader to adopt; nothing is written to the store
+// since the new leader owns it now.
+func (s *Scheduler) stepDown() {
+	s.logger.Warn("Leadership lost, stepping down to standby")
+
+	s.mu.Lock()
+	effective := s.target.At(time.Now())
+	s.state = &SchedulerState{
+		Phase:         "standby",
+		ActiveAgents:  make(map[string]*AgentAllocation),
+		TargetTotalBW: effective.TargetMbps,
+		Tolerance:     effective.Tolerance,
+		TargetWindow:  effective.Window,
+		NextRotation:  time.Now(),
+	}
+	s.pendingReconcile = make(map[string]bool)
+	s.awaitingStatus = make(map[string]bool)
+	s.lost = nil
+	s.mu.Unlock()
+
+	s.pid.Reset()
+}
+
+// finishReconcile drops restored allocations for agents that did not come
+// back within the reconcile window. It returns true if restored or adopted
+// agents are still active and the schedule should be continued.
+func (s *Scheduler) finishReconcile() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for agentID := range s.pendingReconcile {
+		s.logger.Warnw("Restored agent did not reconnect, dropping allocation", "agent_id", agentID)
+		delete(s.state.ActiveAgents, agentID)
+		delete(s.pendingReconcile, agentID)
+	}
+
+	if len(s.state.ActiveAgents) == 0 {
+		return false
+	}
+
+	s.state.Phase = "stable"
+	return true
+}
@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/bandwidth"
+)
+
+// RegionStatus reports a region's bandwidth against its quota
+type RegionStatus struct {
+	Region      string  `json:"region"`
+	Agents      int     `json:"agents"`
+	PlannedMbps int64   `json:"planned_mbps"`
+	ActualMbps  float64 `json:"actual_mbps"`
+	MinMbps     int64   `json:"min_mbps"`
+	MaxMbps     int64   `json:"max_mbps,omitempty"` // 0 = no limit
+	Satisfied   bool    `json:"satisfied"`
+}
+
+// RegionConstraints reports whether the active schedule meets the region
+// constraints
+type RegionConstraints struct {
+	Satisfied     bool           `json:"satisfied"`
+	MinActive     int            `json:"min_active_regions"`
+	ActiveRegions int            `json:"active_regions"`
+	Regions       []RegionStatus `json:"regions"`
+	Violations    []string       `json:"violations,omitempty"`
+}
+
+// regionLimits returns the bounds of every region at the given target.
+// Regions without a quota are not limited.
+func (s *Scheduler) regionLimits(target float64) map[string]bandwidth.Limits {
+	limits := make(map[string]bandwidth.Limits)
+	for _, agent := range s.config.Agents {
+		limits[agent.Region] = bandwidth.Limits{Max: math.MaxInt64}
+	}
+	for region, quota := range s.config.Scheduler.Regions.Quotas {
+		limits[region] = quota.Limits(target)
+	}
+	return limits
+}
+
+// agentLimits returns the floor and ceiling the scheduler plans an agent
+// within. Pinned agents are held at their pinned bandwidth.
+func (s *Scheduler) agentLimits(agent AgentConfig) bandwidth.Limits {
+	if pinned, ok := s.overrides.pin(agent.ID); ok {
+		return bandwidth.Limits{Min: pinned, Max: pinned}
+	}
+	ceiling := bandwidth.Min64(s.config.Scheduler.ServerBandwidthMax, s.deliverableCapacity(agent))
+	return bandwidth.Limits{
+		Min: bandwidth.Min64(s.config.Scheduler.ServerBandwidthMin, ceiling),
+		Max: ceiling,
+	}
+}
+
+// selectWithConstraints picks count of the available agents by weight, first
+// taking pinned agents, then adding agents until every region can reach its
+// minimum and enough regions are represented. Agents are not picked for a region whose floors would
+// exceed its maximum, nor past a topology rule's max_active. Topology skew is
+// kept for every agent but those required by regions, which may also take
+// the selection past count.
+func (s *Scheduler) selectWithConstraints(available []AgentConfig, weights []float64, count int) []int {
+	s.mu.RLock()
+	limits := s.regionLimits(s.state.TargetTotalBW)
+	s.mu.RUnlock()
+
+	topology := newTopologyTracker(s.config.Scheduler.Topology.Rules, available)
+	picked := make([]bool, len(available))
+	capacity := make(map[string]int64)
+	floors := make(map[string]int64)
+	var selected []int
+
+	take := func(i int) {
+		agentLimits := s.agentLimits(available[i])
+		picked[i] = true
+		topology.add(available[i])
+		selected = append(selected, i)
+		capacity[available[i].Region] += agentLimits.Max
+		floors[available[i].Region] += agentLimits.Min
+	}
+
+	pick := func(required bool, eligible func(agent AgentConfig) bool) bool {
+		var candidates []int
+		var candidateWeights []float64
+		for i, agent := range available {
+			if picked[i] || !eligible(agent) || !topology.fits(agent) {
+				continue
+			}
+			if !required && !topology.balanced(agent) {
+				continue
+			}
+			agentLimits := s.agentLimits(agent)
+			if floors[agent.Region]+agentLimits.Min > limits[agent.Region].Max {
+				continue
+			}
+			candidates = append(candidates, i)
+			candidateWeights = append(candidateWeights, weights[i])
+		}
+		if len(candidates) == 0 {
+			return false
+		}
+
+		take(candidates[bandwidth.WeightedRandomSelection(1, candidateWeights)[0]])
+		return true
+	}
+
+	// Pinned agents, whatever the constraints
+	for i, agent := range available {
+		if _, pinned := s.overrides.pin(agent.ID); pinned {
+			take(i)
+		}
+	}
+
+	// Regions with a minimum, in a stable order
+	regions := make([]string, 0, len(s.config.Scheduler.Regions.Quotas))
+	for region := range s.config.Scheduler.Regions.Quotas {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		for capacity[region] < limits[region].Min {
+			if !pick(true, func(agent AgentConfig) bool { return agent.Region == region }) {
+				s.logger.Warnw("Not enough agents to reach region minimum",
+					"region", region,
+					"minimum", limits[region].Min,
+					"capacity", capacity[region],
+				)
+				break
+			}
+		}
+	}
+
+	unrepresented := func(agent AgentConfig) bool {
+		_, represented := capacity[agent.Region]
+		return agent.Region != "" && !represented
+	}
+	for countRegions(capacity) < s.config.Scheduler.Regions.MinActive {
+		if !pick(true, unrepresented) {
+			s.logger.Warnw("Not enough regions available",
+				"min_active", s.config.Scheduler.Regions.MinActive,
+				"selected", countRegions(capacity),
+			)
+			break
+		}
+	}
+
+	for len(selected) < count {
+		if !pick(false, func(AgentConfig) bool { return true }) {
+			break
+		}
+	}
+
+	return selected
+}
+
+// allocateWithRegions water-fills the target across regions within their
+// limits, then across the agents of each region
+func (s *Scheduler) allocateWithRegions(agents []AgentConfig, weights []float64, limits []bandwidth.Limits) bandwidth.Allocation {
+	regionLimits := s.regionLimits(s.state.TargetTotalBW)
+
+	index := make(map[string]int)
+	var groupLimits []bandwidth.Limits
+	groups := make([]int, len(agents))
+	for i, agent := range agents {
+		g, ok := index[agent.Region]
+		if !ok {
+			g = len(groupLimits)
+			index[agent.Region] = g
+			groupLimits = append(groupLimits, regionLimits[agent.Region])
+		}
+		groups[i] = g
+	}
+
+	plan, _ := bandwidth.WaterFillGroups(int64(s.state.TargetTotalBW), weights, limits, groups, groupLimits)
+	return plan
+}
+
+// regionRoom returns how much more bandwidth each region with a maximum can
+// take on top of the given allocations. Must be called with s.mu held.
+func (s *Scheduler) regionRoom(allocations map[string]*AgentAllocation) map[string]int64 {
+	room := make(map[string]int64)
+	for region, limits := range s.regionLimits(s.state.TargetTotalBW) {
+		if limits.Max != math.MaxInt64 {
+			room[region] = limits.Max
+		}
+	}
+	for agentID, alloc := range allocations {
+		agent, ok := s.agentConfig(agentID)
+		if !ok {
+			continue
+		}
+		if _, limited := room[agent.Region]; limited {
+			room[agent.Region] -= alloc.AllocatedBW
+		}
+	}
+	return room
+}
+
+// GetRegionConstraints reports whether the active schedule meets the region
+// quotas and the minimum number of active regions (for API)
+func (s *Scheduler) GetRegionConstraints() RegionConstraints {
+	agg := s.aggregated()
+
+	s.mu.RLock()
+	limits := s.regionLimits(s.state.TargetTotalBW)
+	byRegion := make(map[string]*RegionStatus)
+	for agentID, alloc := range s.state.ActiveAgents {
+		agent, ok := s.agentConfig(agentID)
+		if !ok {
+			continue
+		}
+		status, ok := byRegion[agent.Region]
+		if !ok {
+			status = &RegionStatus{Region: agent.Region}
+			byRegion[agent.Region] = status
+		}
+		status.Agents++
+		status.PlannedMbps += alloc.AllocatedBW
+		status.ActualMbps += agg.AgentBreakdown[agentID]
+	}
+	s.mu.RUnlock()
+
+	result := RegionConstraints{
+		Satisfied: true,
+		MinActive: s.config.Scheduler.Regions.MinActive,
+		Regions:   make([]RegionStatus, 0, len(limits)),
+	}
+
+	for region, regionLimits := range limits {
+		if region == "" {
+			continue // Agents without a region are not constrained
+		}
+		status, ok := byRegion[region]
+		if !ok {
+			status = &RegionStatus{Region: region}
+		}
+		if status.Agents > 0 {
+			result.ActiveRegions++
+		}
+
+		status.MinMbps = regionLimits.Min
+		if regionLimits.Max != math.MaxInt64 {
+			status.MaxMbps = regionLimits.Max
+		}
+		status.Satisfied = true
+		if status.PlannedMbps < regionLimits.Min {
+			status.Satisfied = false
+			result.Violations = append(result.Violations, fmt.Sprintf(
+				"region %q planned %d Mbps, below its minimum of %d Mbps", region, status.PlannedMbps, regionLimits.Min))
+		} else if status.PlannedMbps > regionLimits.Max {
+			status.Satisfied = false
+			result.Violations = append(result.Violations, fmt.Sprintf(
+				"region %q planned %d Mbps, above its maximum of %d Mbps", region, status.PlannedMbps, regionLimits.Max))
+		}
+		result.Satisfied = result.Satisfied && status.Satisfied
+
+		result.Regions = append(result.Regions, *status)
+	}
+
+	if result.ActiveRegions < result.MinActive {
+		result.Satisfied = false
+		result.Violations = append(result.Violations, fmt.Sprintf(
+			"%d regions active, at least %d required", result.ActiveRegions, result.MinActive))
+	}
+
+	sort.Slice(result.Regions, func(i, j int) bool {
+		return result.Regions[i].Region < result.Regions[j].Region
+	})
+	sort.Strings(result.Violations)
+	return result
+}
+
+// countRegions counts the named regions in a map keyed by region
+func countRegions(byRegion map[string]int64) int {
+	n := 0
+	for region := range byRegion {
+		if region != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,467 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mashiro/google-bandwidth-controller/internal/bandwidth"
+)
+
+// Rotation phases
+const (
+	RotationRampingDown = "ramping_down"
+	RotationRampingUp   = "ramping_up"
+	RotationOverlapping = "overlapping"
+	RotationCompleted   = "completed"
+	RotationCancelled   = "cancelled"
+)
+
+// rotationTick is how often the main loop advances a rotation in progress
+const rotationTick = 500 * time.Millisecond
+
+// ErrNoRotation is returned when there is no rotation in progress to cancel
+var ErrNoRotation = errors.New("no rotation in progress")
+
+// RotationStatus describes a rotation in progress (for API)
+type RotationStatus struct {
+	ID         string    `json:"id"`
+	Mode       string    `json:"mode"`
+	Phase      string    `json:"phase"`
+	StartedAt  time.Time `json:"started_at"`
+	PhaseSince time.Time `json:"phase_since"`
+	Starting   []string  `json:"starting"`
+	Stopping   []string  `json:"stopping"`
+}
+
+// scheduledStart is an agent start due at a point in time
+type scheduledStart struct {
+	at    time.Time
+	alloc *AgentAllocation
+}
+
+// scheduledStop is an agent stop due at a point in time
+type scheduledStop struct {
+	at      time.Time
+	agentID string
+}
+
+// rotation is a rotation in progress. It is a state machine advanced by the
+// scheduler main loop; only the main loop touches the pending work, while
+// the status fields are guarded by the scheduler's mu.
+type rotation struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	status RotationStatus
+
+	allocations map[string]*AgentAllocation // The new schedule
+
+	starts        []scheduledStart // Pending, in due order
+	stops         []scheduledStop  // Pending, in due order
+	rampDownUntil time.Time
+
+	// Overlap mode: departing agents still running, smallest first, and
+	// the bandwidth each ran at when the overlap began
+	departing       []string
+	departingFrom   map[string]int64
+	stopped         map[string]bool
+	overlapDeadline time.Time
+}
+
+// scheduleStarts staggers the starts of the incoming agents over the ramp-up duration
+func (r *rotation) scheduleStarts(toStart []*AgentAllocation, from time.Time, over time.Duration) {
+	delays := bandwidth.CalculateStagger(len(toStart), over.Seconds())
+	for i, alloc := range toStart {
+		r.starts = append(r.starts, scheduledStart{
+			at:    from.Add(time.Duration(delays[i] * float64(time.Second))),
+			alloc: alloc,
+		})
+	}
+	sort.Slice(r.starts, func(i, j int) bool { return r.starts[i].at.Before(r.starts[j].at) })
+}
+
+// scheduleStops staggers the stops of the departing agents over the ramp-down duration
+func (r *rotation) scheduleStops(toStop []string, from time.Time, over time.Duration) {
+	delays := bandwidth.CalculateStagger(len(toStop), over.Seconds())
+	for i, agentID := range toStop {
+		r.stops = append(r.stops, scheduledStop{
+			at:      from.Add(time.Duration(delays[i] * float64(time.Second))),
+			agentID: agentID,
+		})
+	}
+	sort.Slice(r.stops, func(i, j int) bool { return r.stops[i].at.Before(r.stops[j].at) })
+}
+
+// planned reports whether an agent is part of the rotation's new schedule
+func (r *rotation) planned(agentID string) bool {
+	_, ok := r.allocations[agentID]
+	return ok
+}
+
+// beginRotation plans a new schedule and starts rotating to it. It does
+// nothing if a rotation is already in progress.
+func (s *Scheduler) beginRotation(ctx context.Context) {
+	s.mu.Lock()
+	if s.rotation != nil || s.draining || s.pause.Paused || s.server.Fenced() {
+		s.mu.Unlock()
+		return
+	}
+	s.applyTarget()
+	s.mu.Unlock()
+
+	// Calculate new schedule
+	newConcurrency := s.calculateConcurrency()
+	allocations, plan := s.planSchedule(newConcurrency)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.PlannedTotalBW = plan.Total
+	s.state.PlanShortfall = plan.Shortfall
+
+	toStop := s.findAgentsToStop(allocations)
+	toStart := s.findAgentsToStart(allocations)
+
+	rotationCtx, cancel := context.WithCancelCause(ctx)
+	now := time.Now()
+	r := &rotation{
+		ctx:    rotationCtx,
+		cancel: cancel,
+		status: RotationStatus{
+			ID:        uuid.New().String(),
+			Mode:      s.config.Scheduler.RotationMode,
+			StartedAt: now,
+			Starting:  make([]string, 0, len(toStart)),
+			Stopping:  toStop,
+		},
+		allocations: allocations,
+		stopped:     make(map[string]bool),
+	}
+	for _, alloc := range toStart {
+		r.status.Starting = append(r.status.Starting, alloc.AgentID)
+	}
+	s.rotation = r
+
+	s.logger.Infow("Starting rotation cycle",
+		"rotation_id", r.status.ID,
+		"concurrency", newConcurrency,
+		"selected_agents", len(allocations),
+		"planned_bandwidth", plan.Total,
+		"starting", len(toStart),
+		"stopping", len(toStop),
+	)
+
+	if r.status.Mode == RotationModeOverlap {
+		// Make before break: start everyone, hand over as throughput arrives
+		r.scheduleStarts(toStart, now, s.config.Scheduler.RampUpDuration)
+
+		agg := s.aggregated()
+		r.departing = append(r.departing, toStop...)
+		sort.Slice(r.departing, func(i, j int) bool {
+			return agg.AgentBreakdown[r.departing[i]] < agg.AgentBreakdown[r.departing[j]]
+		})
+		r.departingFrom = make(map[string]int64, len(toStop))
+		for _, agentID := range toStop {
+			if alloc, ok := s.state.ActiveAgents[agentID]; ok {
+				r.departingFrom[agentID] = alloc.CommandedBW
+			}
+		}
+		r.overlapDeadline = now.Add(s.config.Scheduler.RampUpDuration + s.config.Scheduler.OverlapTimeout)
+
+		s.setRotationPhase(r, RotationOverlapping, "")
+		return
+	}
+
+	r.scheduleStops(toStop, now, s.config.Scheduler.RampDownDuration)
+	r.rampDownUntil = now
+	if len(toStop) > 0 {
+		r.rampDownUntil = now.Add(s.config.Scheduler.RampDownDuration)
+	}
+	r.scheduleStarts(toStart, r.rampDownUntil, s.config.Scheduler.RampUpDuration)
+
+	s.setRotationPhase(r, RotationRampingDown, "")
+}
+
+// advanceRotation performs whatever the rotation in progress has due and
+// moves it to its next phase once the current one is done
+func (s *Scheduler) advanceRotation() {
+	s.mu.RLock()
+	r := s.rotation
+	s.mu.RUnlock()
+	if r == nil {
+		return
+	}
+
+	if r.ctx.Err() != nil {
+		s.cancelledRotation(r, context.Cause(r.ctx))
+		return
+	}
+
+	now := time.Now()
+	switch r.status.Phase {
+	case RotationRampingDown:
+		s.runDueStops(r, now)
+		if len(r.stops) == 0 && !now.Before(r.rampDownUntil) {
+			s.mu.Lock()
+			s.setRotationPhase(r, RotationRampingUp, "")
+			s.mu.Unlock()
+		}
+
+	case RotationRampingUp:
+		s.runDueStarts(r, now)
+		if len(r.starts) == 0 {
+			s.completeRotation(r)
+		}
+
+	case RotationOverlapping:
+		s.runDueStarts(r, now)
+		s.handOver(r, now)
+		if len(r.starts) == 0 && len(r.departing) == 0 {
+			s.completeRotation(r)
+		}
+	}
+}
+
+// runDueStops stops the departing agents whose stop is due
+func (s *Scheduler) runDueStops(r *rotation, now time.Time) {
+	for len(r.stops) > 0 && !now.Before(r.stops[0].at) {
+		agentID := r.stops[0].agentID
+		r.stops = r.stops[1:]
+		s.retireAgent(r, agentID)
+	}
+}
+
+// runDueStarts starts the incoming agents whose start is due. Agents that
+// could not be started are left out of the new schedule.
+func (s *Scheduler) runDueStarts(r *rotation, now time.Time) {
+	for len(r.starts) > 0 && !now.Before(r.starts[0].at) {
+		alloc := r.starts[0].alloc
+		r.starts = r.starts[1:]
+
+		s.startAgent(alloc)
+		if alloc.CurrentCommand == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.state.ActiveAgents[alloc.AgentID] = alloc
+		s.mu.Unlock()
+	}
+}
+
+// retireAgent stops a departing agent and takes it out of the active set
+func (s *Scheduler) retireAgent(r *rotation, agentID string) {
+	s.stopAgent(agentID)
+	r.stopped[agentID] = true
+
+	s.mu.Lock()
+	delete(s.state.ActiveAgents, agentID)
+	s.mu.Unlock()
+}
+
+// handOver ramps the departing agents down in step with the newcomers: the
+// bandwidth the newcomers are measured to deliver is taken off the departing
+// agents, smallest first, so the total stays level. A departing agent is
+// stopped once it is down to the minimum agent bandwidth, and every one
+// regardless once the overlap timed out.
+func (s *Scheduler) handOver(r *rotation, now time.Time) {
+	if len(r.departing) == 0 {
+		return
+	}
+
+	if now.After(r.overlapDeadline) {
+		next := r.departing[0]
+		s.logger.Warnw("Overlap timed out, stopping departing agent", "agent_id", next)
+		r.departing = r.departing[1:]
+		s.retireAgent(r, next)
+		return
+	}
+
+	agg := s.aggregated()
+	arrived := 0.0
+	for _, agentID := range r.status.Starting {
+		arrived += agg.AgentBreakdown[agentID]
+	}
+
+	minBW := s.config.Scheduler.Control.MinAgentBandwidth
+	var retiring []string
+
+	s.mu.Lock()
+	remaining := r.departing[:0]
+	for _, agentID := range r.departing {
+		alloc, ok := s.state.ActiveAgents[agentID]
+		if !ok {
+			continue // Gone meanwhile
+		}
+
+		from := r.departingFrom[agentID]
+		shed := bandwidth.Min64(int64(arrived), from)
+		arrived -= float64(shed)
+		desired := from - shed
+
+		if desired <= minBW {
+			retiring = append(retiring, agentID)
+			continue
+		}
+		remaining = append(remaining, agentID)
+
+		// Only ever lowered, so a dip in the newcomers' rate does not
+		// bring a departing agent back up
+		if desired >= alloc.CommandedBW || alloc.CurrentCommand == "" {
+			continue
+		}
+		if err := s.adjustAgent(agentID, alloc.CurrentCommand, desired); err != nil {
+			s.logger.Warnw("Failed to ramp down departing agent", "agent_id", agentID, "error", err)
+			continue
+		}
+		alloc.setCommandedBW(desired)
+	}
+	r.departing = remaining
+	s.mu.Unlock()
+
+	for _, agentID := range retiring {
+		s.logger.Debugw("Departing agent ramped down, stopping it", "agent_id", agentID)
+		s.retireAgent(r, agentID)
+	}
+}
+
+// completeRotation commits the new schedule once all agents have been
+// started and stopped. Agents that stayed active are carried into their new
+// allocation; agents adopted while the rotation was running are not part of
+// the new schedule and must not be left running.
+func (s *Scheduler) completeRotation(r *rotation) {
+	s.mu.Lock()
+	active := make(map[string]*AgentAllocation, len(r.allocations))
+	for agentID, current := range s.state.ActiveAgents {
+		next, planned := r.allocations[agentID]
+		if !planned {
+			s.logger.Infow("Stopping agent adopted during rotation", "agent_id", agentID)
+			s.stopAgent(agentID)
+			continue
+		}
+		if next.CurrentCommand == "" {
+			s.continueAllocation(current, next)
+		}
+		active[agentID] = next
+	}
+
+	s.state.Phase = "stable"
+	s.state.ActiveAgents = active
+	s.state.LastRotation = time.Now()
+	s.state.RotationCount++
+	rotationCount := s.state.RotationCount
+
+	s.setRotationPhase(r, RotationCompleted, "")
+	s.rotation = nil
+	s.mu.Unlock()
+
+	r.cancel(nil)
+	s.pid.Reset() // Correct the new plan from scratch
+	s.scheduleNextRotation()
+
+	s.logger.Infow("Rotation cycle completed",
+		"rotation_id", r.status.ID,
+		"active_agents", len(active),
+		"rotation_count", rotationCount,
+	)
+}
+
+// cancelledRotation ends a cancelled rotation where it stands: agents
+// already stopped stay stopped, agents already started stay active and
+// pending work is dropped. The next rotation plans from there.
+func (s *Scheduler) cancelledRotation(r *rotation, cause error) {
+	reason := "cancelled"
+	if cause != nil {
+		reason = cause.Error()
+	}
+
+	s.mu.Lock()
+	if s.rotation != r {
+		s.mu.Unlock()
+		return
+	}
+	s.setRotationPhase(r, RotationCancelled, reason)
+	s.rotation = nil
+	if !s.draining {
+		s.state.Phase = "stable"
+	}
+	s.mu.Unlock()
+
+	s.logger.Warnw("Rotation cancelled",
+		"rotation_id", r.status.ID,
+		"reason", reason,
+		"pending_starts", len(r.starts),
+		"pending_stops", len(r.stops)+len(r.departing),
+	)
+
+	s.scheduleNextRotation()
+}
+
+// abandonRotation drops the rotation in progress on shutdown or loss of
+// leadership, leaving the agents as they are
+func (s *Scheduler) abandonRotation(reason string) {
+	s.mu.Lock()
+	r := s.rotation
+	if r == nil {
+		s.mu.Unlock()
+		return
+	}
+	r.cancel(errors.New(reason))
+	s.setRotationPhase(r, RotationCancelled, reason)
+	s.rotation = nil
+	s.mu.Unlock()
+
+	s.logger.Infow("Rotation abandoned", "rotation_id", r.status.ID, "reason", reason)
+}
+
+// setRotationPhase moves a rotation to a phase and publishes the
+// transition. Must be called with s.mu held.
+func (s *Scheduler) setRotationPhase(r *rotation, phase, reason string) {
+	now := time.Now()
+	r.status.Phase = phase
+	r.status.PhaseSince = now
+
+	switch phase {
+	case RotationRampingDown, RotationRampingUp, RotationOverlapping:
+		s.state.Phase = phase
+	}
+
+	s.events.Publish(Event{
+		Time:       now,
+		Type:       EventRotationPhase,
+		RotationID: r.status.ID,
+		Phase:      phase,
+		Reason:     reason,
+	})
+}
+
+// CancelRotation cancels the rotation in progress. The main loop winds it
+// down on its next step.
+func (s *Scheduler) CancelRotation(reason string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.rotation == nil {
+		return ErrNoRotation
+	}
+	s.rotation.cancel(errors.New(reason))
+	return nil
+}
+
+// GetRotation returns the rotation in progress, if any (for API)
+func (s *Scheduler) GetRotation() (RotationStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.rotation == nil {
+		return RotationStatus{}, false
+	}
+	return s.rotation.status, true
+}
+
+// Events returns the scheduler event bus
+func (s *Scheduler) Events() *EventBus {
+	return s.events
+}
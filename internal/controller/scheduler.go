@@ -12,16 +12,60 @@ import (
 	"github.com/mashiro/google-bandwidth-controller/pkg/logger"
 )
 
-// Scheduler manages bandwidth scheduling across agents
+// Scheduler manages bandwidth scheduling across the agents of a pool
 type Scheduler struct {
-	config      *Config
+	pool        string
+	config      *Config // Scoped to the pool
 	server      *Server
 	metrics     *MetricsAggregator
 	logger      *logger.Logger
+	store       *Store
 	state       *SchedulerState
 	mu          sync.RWMutex
 	startTime   time.Time
 	agentStatus map[string]*AgentStatus
+	profile     bandwidth.ConcurrencyProfile
+	target      *TargetSchedule
+	pid         *PIDController
+	leadership  <-chan bool
+
+	// Agents restored as active that have not reconnected yet
+	pendingReconcile map[string]bool
+	// Agents asked to report their running jobs after a takeover
+	awaitingStatus map[string]bool
+
+	// Rotation in progress, advanced by the main loop
+	rotation *rotation
+	events   *EventBus
+
+	// Bandwidth of disconnected agents waiting to be reallocated
+	lost []lostAllocation
+
+	// Learned agent capacities and calibrations in progress
+	calibrations *calibrations
+	// Learned share of commanded bandwidth agents deliver
+	model *ThroughputModel
+	// Operator pins and exclusions, applied ahead of selection
+	overrides *overrides
+	// Bytes downloaded by the pool and its agents in their billing periods
+	transfer *transferBudgets
+	// 5-minute samples and burst decisions in p95 target mode; nil otherwise
+	percentile *percentileMeter
+	// Bytes delivered in the current period in volume target mode; nil
+	// otherwise
+	volume *volumeMeter
+
+	// Recurring maintenance windows by agent, and agents being ramped down
+	// because they are draining or in one
+	maintenanceWindows map[string][]*maintenanceWindow
+	drains             map[string]*agentDrain
+
+	// Operator pause; the allocation is held while set
+	pause PauseStatus
+
+	// Set while agents are drained on shutdown; no new work is started
+	draining bool
+	done     chan struct{}
 }
 
 // SchedulerState represents current scheduler state
@@ -30,36 +74,122 @@ type SchedulerState struct {
 	ActiveAgents     map[string]*AgentAllocation // Currently active agent allocations
 	NextRotation     time.Time
 	TargetTotalBW    float64
+	Tolerance        float64 // Tolerance in force with the current target
+	TargetWindow     string  // Target schedule window in force
 	CurrentTotalBW   float64
 	LastRotation     time.Time
 	RotationCount    int
+	PlannedTotalBW   int64 // Total of the latest plan
+	PlanShortfall    int64 // Target the latest plan could not allocate
 }
 
 // AgentAllocation represents bandwidth allocation for an agent
 type AgentAllocation struct {
-	AgentID         string
-	AllocatedBW     int64
-	StartTime       time.Time
-	PlannedDuration time.Duration
-	CurrentCommand  string
-	URL             string
+	AgentID         string            `json:"agent_id"`
+	AllocatedBW     int64             `json:"allocated_bw"` // Planned bandwidth
+	CommandedBW     int64             `json:"commanded_bw"` // Bandwidth after closed-loop correction
+	StartTime       time.Time         `json:"start_time"`
+	PlannedDuration time.Duration     `json:"planned_duration"`
+	CurrentCommand  string            `json:"current_command"` // Base command
+	URL             string            `json:"url"`
+	Commands        []*TrackedCommand `json:"commands"` // Base and boost commands running on the agent
+}
+
+// Tracked command kinds
+const (
+	CommandKindBase  = "base"
+	CommandKindBoost = "boost"
+)
+
+// TrackedCommand is a download command running as part of an allocation
+type TrackedCommand struct {
+	CommandID string    `json:"command_id"`
+	Kind      string    `json:"kind"`      // base or boost
+	Bandwidth int64     `json:"bandwidth"` // Mbps
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// BoostBandwidth returns the total bandwidth of the allocation's boosts
+func (a *AgentAllocation) BoostBandwidth() int64 {
+	var total int64
+	for _, cmd := range a.Commands {
+		if cmd.Kind == CommandKindBoost {
+			total += cmd.Bandwidth
+		}
+	}
+	return total
+}
+
+// Boosts returns the allocation's boost commands, newest first
+func (a *AgentAllocation) Boosts() []*TrackedCommand {
+	var boosts []*TrackedCommand
+	for i := len(a.Commands) - 1; i >= 0; i-- {
+		if a.Commands[i].Kind == CommandKindBoost {
+			boosts = append(boosts, a.Commands[i])
+		}
+	}
+	return boosts
+}
+
+// removeCommand stops tracking a command
+func (a *AgentAllocation) removeCommand(commandID string) {
+	for i, cmd := range a.Commands {
+		if cmd.CommandID == commandID {
+			a.Commands = append(a.Commands[:i], a.Commands[i+1:]...)
+			return
+		}
+	}
+}
+
+// setCommandedBW records the bandwidth the base command was adjusted to
+func (a *AgentAllocation) setCommandedBW(bandwidthMbps int64) {
+	a.CommandedBW = bandwidthMbps
+	for _, cmd := range a.Commands {
+		if cmd.CommandID == a.CurrentCommand {
+			cmd.Bandwidth = bandwidthMbps
+		}
+	}
+}
+
+// copyAllocation returns a copy of an allocation that shares no state
+func copyAllocation(alloc *AgentAllocation) *AgentAllocation {
+	allocCopy := *alloc
+	allocCopy.Commands = make([]*TrackedCommand, len(alloc.Commands))
+	for i, cmd := range alloc.Commands {
+		cmdCopy := *cmd
+		allocCopy.Commands[i] = &cmdCopy
+	}
+	return &allocCopy
 }
 
 // AgentStatus tracks agent usage statistics
 type AgentStatus struct {
-	AgentID      string
-	LastUsed     time.Time
-	TotalRuntime time.Duration
-	UseCount     int
-	Region       string
+	AgentID      string        `json:"agent_id"`
+	LastUsed     time.Time     `json:"last_used"`
+	TotalRuntime time.Duration `json:"total_runtime"`
+	UseCount     int           `json:"use_count"`
+	Region       string        `json:"region"`
+	Mode         string        `json:"mode,omitempty"` // Set through the API; empty = active
 }
 
-// NewScheduler creates a new scheduler
-func NewScheduler(config *Config, server *Server, metrics *MetricsAggregator, log *logger.Logger) *Scheduler {
+// NewScheduler creates a scheduler for a pool. The config must be scoped to
+// the pool (see Config.ForPool).
+func NewScheduler(pool string, config *Config, server *Server, metrics *MetricsAggregator, store *Store, log *logger.Logger) *Scheduler {
+	target, err := NewTargetSchedule(config.Bandwidth)
+	if err != nil {
+		// Validated already; fall back to the static target regardless
+		log.Errorw("Failed to build target schedule, using static target", "error", err)
+		target, _ = NewTargetSchedule(BandwidthConfig{TargetGbps: config.Bandwidth.TargetGbps, Tolerance: config.Bandwidth.Tolerance})
+	}
+	effective := target.At(time.Now())
+
 	state := &SchedulerState{
 		Phase:          "idle",
 		ActiveAgents:   make(map[string]*AgentAllocation),
-		TargetTotalBW:  config.GetTargetBandwidthMbps(),
+		TargetTotalBW:  effective.TargetMbps,
+		Tolerance:      effective.Tolerance,
+		TargetWindow:   effective.Window,
 		NextRotation:   time.Now(),
 	}
 
@@ -72,65 +202,394 @@ func NewScheduler(config *Config, server *Server, metrics *MetricsAggregator, lo
 		}
 	}
 
+	// The config was validated, so building the profile only fails if a
+	// profile table changed on disk since
+	profile, err := config.Scheduler.ConcurrencyProfile.Build()
+	if err != nil {
+		log.Errorw("Failed to build concurrency profile, using sine waves", "error", err)
+		profile = &bandwidth.SineProfile{}
+	}
+
+	var percentile *percentileMeter
+	var volume *volumeMeter
+	switch config.Bandwidth.Mode {
+	case TargetModeP95:
+		percentile = newPercentileMeter(config.Bandwidth.P95, effective.TargetMbps, time.Now())
+	case TargetModeVolume:
+		volume = newVolumeMeter(config.Bandwidth, time.Now())
+	}
+
 	return &Scheduler{
+		pool:        pool,
 		config:      config,
 		server:      server,
 		metrics:     metrics,
 		logger:      log,
+		store:       store,
 		state:       state,
 		startTime:   time.Now(),
 		agentStatus: agentStatus,
+		profile:     profile,
+		target:      target,
+		pid: NewPIDController(
+			config.Scheduler.Control.Kp,
+			config.Scheduler.Control.Ki,
+			config.Scheduler.Control.Kd,
+		),
+		leadership: server.watchLeadership(),
+
+		pendingReconcile: make(map[string]bool),
+		awaitingStatus:   make(map[string]bool),
+		events:           NewEventBus(200),
+		calibrations:     newCalibrations(),
+		model:            NewThroughputModel(config.Scheduler.ThroughputModel),
+		overrides:        &overrides{},
+		transfer:         newTransferBudgets(config),
+		percentile:       percentile,
+		volume:           volume,
+
+		maintenanceWindows: parseMaintenance(config.Agents, log),
+		drains:             make(map[string]*agentDrain),
+		done:               make(chan struct{}),
 	}
 }
 
 // Run starts the scheduler main loop
 func (s *Scheduler) Run(ctx context.Context) {
 	s.logger.Info("Starting scheduler")
+	defer close(s.done)
+
+	// Only the leader schedules; a standby waits to take over
+	for {
+		if !s.waitForLeadership(ctx) {
+			return
+		}
+		if !s.lead(ctx) {
+			return
+		}
+	}
+}
+
+// Done returns a channel that is closed once the scheduler has stopped and,
+// if it was leading, drained its agents
+func (s *Scheduler) Done() <-chan struct{} {
+	return s.done
+}
 
-	// Initial schedule
-	s.performRotation()
+// waitForLeadership blocks until this controller is the leader. It returns
+// false if ctx is cancelled first.
+func (s *Scheduler) waitForLeadership(ctx context.Context) bool {
+	if s.server.IsLeader() {
+		return true
+	}
+
+	s.mu.Lock()
+	s.state.Phase = "standby"
+	s.mu.Unlock()
 
-	// Snapshot metrics periodically
-	go s.snapshotMetrics(ctx)
+	s.logger.Info("Standing by until this controller becomes leader")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case leader := <-s.leadership:
+			if leader {
+				return true
+			}
+		}
+	}
+}
+
+// lead runs the scheduler as leader. It returns true if leadership was
+// lost and false once ctx is cancelled.
+func (s *Scheduler) lead(ctx context.Context) bool {
+	// Take over persisted state and whatever the agents are running
+	s.server.takeLead()
+	defer s.server.releaseLead()
+
+	restored := s.restoreState()
+	s.restoreCapacities()
+	s.restoreModel()
+	s.restoreOverrides()
+	s.restoreTransferUsage()
+	s.restoreP95Samples()
+	s.restoreVolume()
+	if s.server.Fenced() {
+		s.halt("emergency stop engaged before takeover")
+	}
+	s.requestAgentStatus()
+
+	// Give agents a chance to reconnect before the first rotation
+	select {
+	case <-ctx.Done():
+		s.drain()
+		s.saveState()
+		return false
+	case leader := <-s.leadership:
+		if !leader {
+			s.stepDown()
+			return true
+		}
+	case <-time.After(s.config.Scheduler.ReconcileWindow):
+	}
+	continuing := s.finishReconcile()
+
+	// Initial schedule, unless we are continuing a restored or adopted one
+	if !continuing {
+		s.beginRotation(ctx)
+	} else if !restored {
+		s.scheduleNextRotation()
+	}
 
 	// Main evaluation loop
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
+	rotationTicker := time.NewTicker(rotationTick)
+	defer rotationTicker.Stop()
+
+	persistTicker := time.NewTicker(s.config.Persistence.SnapshotInterval)
+	defer persistTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Info("Stopping scheduler")
-			s.stopAllAgents()
-			return
+			s.abandonRotation("shutdown")
+			s.drain()
+			s.saveState()
+			return false
+
+		case leader := <-s.leadership:
+			if !leader {
+				s.abandonRotation("leadership lost")
+				s.stepDown()
+				return true
+			}
 
 		case <-ticker.C:
-			s.evaluateAndAdjust()
+			if s.evaluateAndAdjust() {
+				s.beginRotation(ctx)
+			}
+			s.calibrateDue()
+
+		case <-rotationTicker.C:
+			s.reallocateLost()
+			s.drainAgents()
+			s.advanceRotation()
+
+		case <-persistTicker.C:
+			s.saveState()
 		}
 	}
 }
 
-// evaluateAndAdjust checks if rotation is needed or fine-tuning required
-func (s *Scheduler) evaluateAndAdjust() {
+// evaluateAndAdjust checks if rotation is needed or fine-tuning required.
+// It returns true if a rotation is due.
+func (s *Scheduler) evaluateAndAdjust() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if it's time for rotation
-	if time.Now().After(s.state.NextRotation) {
+	s.applyTarget()
+	s.expireOverrides()
+
+	// Every tick is sampled towards the p95, rotations included
+	agg := s.aggregated()
+	s.observeP95(agg)
+
+	// Check if it's time for rotation, unless one is still running or the
+	// scheduler is paused or fenced
+	fenced := s.server.Fenced()
+	if s.rotation == nil && !s.pause.Paused && !fenced && time.Now().After(s.state.NextRotation) {
 		s.logger.Info("Rotation time reached, performing rotation")
-		go s.performRotation()
-		return
+		return true
 	}
 
 	// Update current bandwidth from metrics
-	agg := s.metrics.GetAggregated()
 	s.state.CurrentTotalBW = agg.TotalBandwidth
+	s.observeThroughput(agg)
 
-	// Adaptive bandwidth adjustment: check if agents need more tasks
-	s.adjustBandwidthIfNeeded(agg)
+	// A paused scheduler holds the allocation as it is, and a fenced one has
+	// none
+	if s.pause.Paused || fenced {
+		return false
+	}
+
+	// Closed-loop correction of the total, or legacy top-up jobs
+	if s.config.Scheduler.Control.Mode == ControlModeBoost {
+		s.adjustBandwidthIfNeeded(agg)
+	} else {
+		s.controlBandwidth(agg)
+	}
+	return false
 }
 
-// adjustBandwidthIfNeeded sends additional download tasks if agents are underperforming
+// controlBandwidth runs the PID controller on the measured total and
+// spreads its correction over the active agents. Must be called with s.mu held.
+func (s *Scheduler) controlBandwidth(agg AggregatedMetrics) {
+	// Measurements during ramps say little about the steady state
+	if s.state.Phase != "stable" || len(s.state.ActiveAgents) == 0 {
+		s.pid.Hold()
+		return
+	}
+
+	limit := s.state.TargetTotalBW * s.config.Scheduler.Control.MaxCorrection
+	correction := s.pid.Update(s.state.TargetTotalBW, s.measuredBandwidth(agg), limit, time.Now())
+
+	s.distributeCorrection(correction)
+}
+
+// distributeCorrection adds a total correction to the planned allocations in
+// proportion to their size, adjusting agents whose commanded bandwidth moves
+// by more than the deadband. Must be called with s.mu held.
+func (s *Scheduler) distributeCorrection(correction float64) {
+	var planned int64
+	for agentID, alloc := range s.state.ActiveAgents {
+		if _, pinned := s.overrides.pin(agentID); !pinned {
+			planned += alloc.AllocatedBW
+		}
+	}
+	if planned == 0 {
+		return
+	}
+
+	control := s.config.Scheduler.Control
+	for agentID, alloc := range s.state.ActiveAgents {
+		if alloc.CurrentCommand == "" {
+			continue
+		}
+		if _, pinned := s.overrides.pin(agentID); pinned {
+			continue // Held at its pinned bandwidth
+		}
+
+		share := float64(alloc.AllocatedBW) / float64(planned)
+		desired := alloc.AllocatedBW + int64(correction*share)
+
+		// Up to the most the agent may be planned at, so the loop can
+		// raise agents as well as lower them
+		maxBW := alloc.AllocatedBW
+		if agent, ok := s.agentConfig(agentID); ok {
+			maxBW = s.agentLimits(agent).Max
+		}
+		desired = bandwidth.Clamp64(desired, control.MinAgentBandwidth, bandwidth.Max64(maxBW, control.MinAgentBandwidth))
+
+		change := desired - alloc.CommandedBW
+		if change < 0 {
+			change = -change
+		}
+		if alloc.CommandedBW > 0 && float64(change) <= control.Deadband*float64(alloc.CommandedBW) {
+			continue
+		}
+
+		if err := s.adjustAgent(agentID, alloc.CurrentCommand, desired); err != nil {
+			s.logger.Warnw("Failed to adjust agent bandwidth",
+				"agent_id", agentID,
+				"error", err,
+			)
+			continue
+		}
+		alloc.setCommandedBW(desired)
+	}
+}
+
+// adjustAgent changes the bandwidth of a running command on an agent
+func (s *Scheduler) adjustAgent(agentID, commandID string, bandwidthMbps int64) error {
+	cmd := protocol.AdjustCommand{
+		CommandID: commandID,
+		Bandwidth: bandwidthMbps,
+	}
+
+	msg, err := protocol.NewMessage(protocol.MsgTypeAdjustCommand, agentID, cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := s.server.SendToAgent(agentID, msg); err != nil {
+		return err
+	}
+
+	s.logger.Debugw("Adjusted agent bandwidth",
+		"agent_id", agentID,
+		"command_id", commandID,
+		"bandwidth", bandwidthMbps,
+	)
+	return nil
+}
+
+// agentConfig returns the configuration of an agent
+func (s *Scheduler) agentConfig(agentID string) (AgentConfig, bool) {
+	for _, agent := range s.config.Agents {
+		if agent.ID == agentID {
+			return agent, true
+		}
+	}
+	return AgentConfig{}, false
+}
+
+// GetControlState returns the bandwidth controller internals (for API)
+func (s *Scheduler) GetControlState() PIDState {
+	return s.pid.State()
+}
+
+// applyTarget updates the state with the target currently in force.
+// Must be called with s.mu held.
+func (s *Scheduler) applyTarget() {
+	now := time.Now()
+	effective := s.target.At(now)
+
+	if effective.Window != s.state.TargetWindow {
+		s.logger.Infow("Bandwidth target window changed",
+			"from", s.state.TargetWindow,
+			"to", effective.Window,
+		)
+		// Error accumulated towards the old target says nothing about the new
+		s.pid.Reset()
+	}
+
+	target := effective.TargetMbps
+	switch {
+	case s.percentile != nil:
+		var changed bool
+		target, changed = s.percentile.steer(now, effective.TargetMbps)
+		if changed {
+			// Plan for the new target rather than correct towards it
+			s.state.NextRotation = now
+			s.logger.Infow("p95 burst decision changed",
+				"target", effective.TargetMbps,
+				"steering_to", target,
+			)
+		}
+	case s.volume != nil:
+		target = s.volume.rate(now)
+	}
+
+	s.state.TargetTotalBW = target * s.poolPace()
+	s.state.Tolerance = effective.Tolerance
+	s.state.TargetWindow = effective.Window
+}
+
+// EffectiveTarget returns the bandwidth target currently in force
+func (s *Scheduler) EffectiveTarget() EffectiveTarget {
+	return s.target.At(time.Now())
+}
+
+// LiveTarget returns the target the scheduler steers to: the target in
+// force as the p95 or volume mode and the pool's transfer budget adjust it
+func (s *Scheduler) LiveTarget() EffectiveTarget {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return EffectiveTarget{
+		TargetMbps: s.state.TargetTotalBW,
+		Tolerance:  s.state.Tolerance,
+		Window:     s.state.TargetWindow,
+	}
+}
+
+// adjustBandwidthIfNeeded tops up underperforming agents with boost
+// commands, and retires boosts once they are no longer needed.
+// Must be called with s.mu held.
 func (s *Scheduler) adjustBandwidthIfNeeded(agg AggregatedMetrics) {
 	// Only adjust during stable phase
 	if s.state.Phase != "stable" {
@@ -146,10 +605,25 @@ func (s *Scheduler) adjustBandwidthIfNeeded(agg AggregatedMetrics) {
 		}
 
 		targetBW := float64(alloc.AllocatedBW)
-		tolerance := s.config.Bandwidth.Tolerance // e.g., 0.15 = 15%
+		tolerance := s.state.Tolerance // e.g., 0.15 = 15%
 
 		// Calculate how much bandwidth is missing
 		minAcceptable := targetBW * (1 - tolerance)
+		maxAcceptable := targetBW * (1 + tolerance)
+
+		if len(alloc.Boosts()) > 0 {
+			// Overshooting: shed boosts first
+			if agentBW > maxAcceptable {
+				s.retireBoosts(alloc, agentBW-targetBW, "overshoot")
+				continue
+			}
+
+			// Recovered: the base command alone delivers again
+			if s.commandSpeed(agentID, alloc.CurrentCommand) >= minAcceptable {
+				s.retireBoosts(alloc, float64(alloc.BoostBandwidth()), "recovered")
+				continue
+			}
+		}
 
 		if agentBW < minAcceptable {
 			// Agent is underperforming, calculate deficit
@@ -164,17 +638,64 @@ func (s *Scheduler) adjustBandwidthIfNeeded(agg AggregatedMetrics) {
 					"current", agentBW,
 					"deficit", deficit,
 					"deficit_percent", deficitPercent,
+					"boost", alloc.BoostBandwidth(),
 				)
 
 				// Send additional download task to boost bandwidth
-				go s.boostAgentBandwidth(agentID, int64(deficit))
+				s.boostAgentBandwidth(alloc, int64(deficit))
 			}
 		}
 	}
 }
 
-// boostAgentBandwidth sends an additional download task to increase bandwidth
-func (s *Scheduler) boostAgentBandwidth(agentID string, additionalBW int64) {
+// commandSpeed returns the measured speed of a single command in Mbps
+func (s *Scheduler) commandSpeed(agentID, commandID string) float64 {
+	agentMetrics := s.metrics.GetAgentMetrics(agentID)
+	if agentMetrics == nil {
+		return 0
+	}
+	for _, cmd := range agentMetrics.CommandMetrics {
+		if cmd.CommandID == commandID {
+			return cmd.CurrentSpeed
+		}
+	}
+	return 0
+}
+
+// retireBoosts stops boost commands, newest first, until at least the
+// given bandwidth has been shed. Must be called with s.mu held.
+func (s *Scheduler) retireBoosts(alloc *AgentAllocation, bandwidthMbps float64, reason string) {
+	shed := int64(0)
+	for _, boost := range alloc.Boosts() {
+		if float64(shed) >= bandwidthMbps {
+			break
+		}
+		s.stopCommand(alloc.AgentID, boost.CommandID)
+		alloc.removeCommand(boost.CommandID)
+		shed += boost.Bandwidth
+	}
+
+	if shed > 0 {
+		s.logger.Infow("Retired bandwidth boosts",
+			"agent_id", alloc.AgentID,
+			"reason", reason,
+			"bandwidth", shed,
+			"remaining_boost", alloc.BoostBandwidth(),
+		)
+	}
+}
+
+// boostAgentBandwidth sends an additional download task to increase
+// bandwidth, within the agent's boost cap. Must be called with s.mu held.
+func (s *Scheduler) boostAgentBandwidth(alloc *AgentAllocation, additionalBW int64) {
+	control := s.config.Scheduler.Control
+	agentID := alloc.AgentID
+
+	// Give the latest boost time to take effect before adding another
+	if boosts := alloc.Boosts(); len(boosts) > 0 && time.Since(boosts[0].StartedAt) < control.BoostSettle {
+		return
+	}
+
 	// Minimum boost is 100 Mbps
 	if additionalBW < 100 {
 		additionalBW = 100
@@ -185,18 +706,25 @@ func (s *Scheduler) boostAgentBandwidth(agentID string, additionalBW int64) {
 		additionalBW = 500
 	}
 
-	// Select URL with type based on agent capabilities
-	selection := s.selectURL(agentID)
-	commandID := uuid.New().String()
+	// Never exceed the agent's total boost cap
+	headroom := control.MaxBoostPerAgent - alloc.BoostBandwidth()
+	if additionalBW > headroom {
+		additionalBW = headroom
+	}
+	if additionalBW < 100 {
+		s.logger.Debugw("Agent boost cap reached", "agent_id", agentID, "boost", alloc.BoostBandwidth())
+		return
+	}
 
 	// Duration: until next rotation
-	s.mu.RLock()
 	duration := time.Until(s.state.NextRotation)
-	if duration < 30*time.Second {
-		s.mu.RUnlock()
-		return // Too close to rotation, skip
+	if duration < 30*time.Second || s.draining {
+		return // Too close to rotation or shutting down, skip
 	}
-	s.mu.RUnlock()
+
+	// Select URL with type based on agent capabilities
+	selection := s.selectURL(agentID)
+	commandID := uuid.New().String()
 
 	cmd := protocol.DownloadCommand{
 		CommandID: commandID,
@@ -220,72 +748,55 @@ func (s *Scheduler) boostAgentBandwidth(agentID string, additionalBW int64) {
 		return
 	}
 
+	alloc.Commands = append(alloc.Commands, &TrackedCommand{
+		CommandID: commandID,
+		Kind:      CommandKindBoost,
+		Bandwidth: additionalBW,
+		URL:       selection.URL,
+		StartedAt: time.Now(),
+	})
+
 	s.logger.Infow("Sent bandwidth boost to agent",
 		"agent_id", agentID,
 		"additional_bandwidth", additionalBW,
+		"total_boost", alloc.BoostBandwidth(),
 		"url", selection.URL,
 		"type", selection.Type,
 	)
 }
 
-// performRotation performs a full scheduling rotation
-func (s *Scheduler) performRotation() {
-	s.mu.Lock()
-	s.state.Phase = "ramping_down"
-	s.mu.Unlock()
-
-	s.logger.Info("Starting rotation cycle")
+// continueAllocation carries an agent that stays active into its new
+// allocation. Boosts were planned until this rotation and are retired; the
+// base command is adjusted to the new bandwidth. Must be called with s.mu held.
+func (s *Scheduler) continueAllocation(current, next *AgentAllocation) {
+	s.retireBoosts(current, float64(current.BoostBandwidth()), "rotation")
 
-	// Phase 1: Calculate new schedule
-	newConcurrency := s.calculateConcurrency()
-	selectedAgents := s.selectAgents(newConcurrency)
-	allocations := s.allocateBandwidth(selectedAgents)
+	next.StartTime = current.StartTime
+	next.CurrentCommand = current.CurrentCommand
+	next.URL = current.URL
+	next.PlannedDuration = current.PlannedDuration
+	next.CommandedBW = current.CommandedBW
+	next.Commands = current.Commands
 
-	s.logger.Infow("New schedule calculated",
-		"concurrency", newConcurrency,
-		"selected_agents", len(selectedAgents),
-	)
-
-	// Phase 2: Ramp down agents that should stop
-	s.mu.Lock()
-	toStop := s.findAgentsToStop(allocations)
-	s.mu.Unlock()
-
-	if len(toStop) > 0 {
-		s.rampDownAgents(toStop)
+	if next.CurrentCommand == "" || next.CommandedBW == next.AllocatedBW {
+		return
 	}
 
-	// Phase 3: Ramp up new agents
-	s.mu.Lock()
-	s.state.Phase = "ramping_up"
-	s.mu.Unlock()
-
-	toStart := s.findAgentsToStart(allocations)
-	if len(toStart) > 0 {
-		s.rampUpAgents(toStart)
+	if err := s.adjustAgent(next.AgentID, next.CurrentCommand, next.AllocatedBW); err != nil {
+		s.logger.Warnw("Failed to adjust continuing agent",
+			"agent_id", next.AgentID,
+			"error", err,
+		)
+		return
 	}
-
-	// Phase 4: Update state
-	s.mu.Lock()
-	s.state.Phase = "stable"
-	s.state.ActiveAgents = allocations
-	s.state.LastRotation = time.Now()
-	s.state.RotationCount++
-	s.mu.Unlock()
-
-	// Phase 5: Schedule next rotation
-	s.scheduleNextRotation()
-
-	s.logger.Infow("Rotation cycle completed",
-		"active_agents", len(allocations),
-		"rotation_count", s.state.RotationCount,
-	)
+	next.setCommandedBW(next.AllocatedBW)
 }
 
-// calculateConcurrency calculates number of concurrent agents using sine waves
+// calculateConcurrency calculates number of concurrent agents from the configured profile
 func (s *Scheduler) calculateConcurrency() int {
 	elapsed := time.Since(s.startTime).Seconds()
-	return bandwidth.CalculateConcurrency(
+	return bandwidth.Concurrency(
+		s.profile,
 		elapsed,
 		s.config.Scheduler.MinConcurrent,
 		s.config.Scheduler.MaxConcurrent,
@@ -295,24 +806,56 @@ func (s *Scheduler) calculateConcurrency() int {
 
 // selectAgents selects which agents to use based on weighted random selection
 func (s *Scheduler) selectAgents(count int) []AgentConfig {
-	connectedAgents := s.server.GetConnectedAgents()
+	connectedAgents := s.connectedAgents()
 	available := make([]AgentConfig, 0)
 
-	// Filter to only connected agents
+	// Filter to only connected, schedulable agents that keep up with their
+	// command queue
+	now := time.Now()
+	s.mu.RLock()
 	for _, agent := range s.config.Agents {
 		for _, connectedID := range connectedAgents {
 			if agent.ID == connectedID {
+				if !s.schedulable(agent.ID, now) {
+					s.logger.Infow("Skipping cordoned agent", "agent_id", agent.ID)
+					break
+				}
+				if s.overrides.excluded(agent) {
+					s.logger.Infow("Skipping excluded agent", "agent_id", agent.ID)
+					break
+				}
+				if s.server.IsAgentDegraded(agent.ID) {
+					s.logger.Warnw("Skipping degraded agent", "agent_id", agent.ID)
+					break
+				}
+				if s.calibrations.isRunning(agent.ID) {
+					s.logger.Infow("Skipping agent being calibrated", "agent_id", agent.ID)
+					break
+				}
 				available = append(available, agent)
 				break
 			}
 		}
 	}
+	s.mu.RUnlock()
 
 	if len(available) == 0 {
 		s.logger.Warn("No connected agents available")
 		return []AgentConfig{}
 	}
 
+	// An active set override replaces selection, along with any pins
+	if set := s.overrides.activeSet(); set != nil {
+		var selected []AgentConfig
+		for _, agent := range available {
+			_, pinned := s.overrides.pin(agent.ID)
+			if pinned || containsString(set, agent.ID) {
+				selected = append(selected, agent)
+			}
+		}
+		return selected
+	}
+
 	if count > len(available) {
 		count = len(available)
 	}
@@ -323,23 +866,26 @@ func (s *Scheduler) selectAgents(count int) []AgentConfig {
 		weight := 1.0
 
 		// Higher weight for higher capacity
-		weight *= float64(agent.MaxBandwidth) / 1000.0
+		weight *= float64(s.capacity(agent)) / 1000.0
+
+		// Lower weight for agents that deliver less than they are asked to
+		weight *= s.model.AgentEfficiency(agent.ID)
 
 		// Higher weight if not recently used
 		status := s.agentStatus[agent.ID]
 		timeSinceUse := time.Since(status.LastUsed).Minutes()
 		weight *= bandwidth.ClampFloat(timeSinceUse/10.0, 0.5, 2.0)
 
-		// Geographic diversity bonus
-		if !s.isRegionOverused(agent.Region) {
+		// Geographic diversity bonus, unless topology rules spread agents
+		if len(s.config.Scheduler.Topology.Rules) == 0 && !s.isRegionOverused(agent.Region) {
 			weight *= 1.5
 		}
 
 		weights[i] = weight
 	}
 
-	// Weighted random selection
-	selectedIndices := bandwidth.WeightedRandomSelection(count, weights)
+	// Weighted random selection, within the region and topology constraints
+	selectedIndices := s.selectWithConstraints(available, weights, count)
 
 	selected := make([]AgentConfig, len(selectedIndices))
 	for i, idx := range selectedIndices {
@@ -349,43 +895,101 @@ func (s *Scheduler) selectAgents(count int) []AgentConfig {
 	return selected
 }
 
-// allocateBandwidth allocates bandwidth across selected agents
-func (s *Scheduler) allocateBandwidth(agents []AgentConfig) map[string]*AgentAllocation {
-	if len(agents) == 0 {
-		return make(map[string]*AgentAllocation)
+// isRegionOverused checks if a region is overused in current active agents
+func (s *Scheduler) isRegionOverused(region string) bool {
+	if region == "" {
+		return false
 	}
 
-	targetTotal := s.state.TargetTotalBW
-	numAgents := len(agents)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	allocations := make(map[string]*AgentAllocation)
+	regionCount := 0
+	totalActive := len(s.state.ActiveAgents)
 
-	// Get bandwidth allocations
-	bandwidthAllocations := bandwidth.AllocateBandwidth(
-		targetTotal,
-		numAgents,
-		s.config.Scheduler.ServerBandwidthMin,
-		s.config.Scheduler.ServerBandwidthMax,
-		s.config.Scheduler.BandwidthRandomness,
-	)
+	for agentID := range s.state.ActiveAgents {
+		if status, ok := s.agentStatus[agentID]; ok {
+			if status.Region == region {
+				regionCount++
+			}
+		}
+	}
+
+	if totalActive == 0 {
+		return false
+	}
 
-	// Apply agent capacity constraints
+	// Consider overused if more than 50% are from same region
+	return float64(regionCount)/float64(totalActive) > 0.5
+}
+
+// allocateBandwidth water-fills the target across selected agents, keeping
+// each within the server bandwidth range and what it can deliver, and each
+// region within its quota. The returned plan reports whether the agents can
+// reach the target.
+func (s *Scheduler) allocateBandwidth(agents []AgentConfig) (map[string]*AgentAllocation, bandwidth.Allocation) {
+	allocations := make(map[string]*AgentAllocation)
+
+	limits := make([]bandwidth.Limits, len(agents))
 	for i, agent := range agents {
-		allocated := bandwidthAllocations[i]
+		limits[i] = s.agentLimits(agent)
+	}
+	weights := bandwidth.RandomWeights(len(agents), s.config.Scheduler.BandwidthRandomness)
 
-		// Don't exceed agent max capacity
-		if allocated > agent.MaxBandwidth {
-			allocated = agent.MaxBandwidth
-		}
+	var plan bandwidth.Allocation
+	if s.config.Scheduler.Regions.Constrained() {
+		plan = s.allocateWithRegions(agents, weights, limits)
+	} else {
+		plan = bandwidth.WaterFill(int64(s.state.TargetTotalBW), weights, limits)
+	}
 
+	for i, agent := range agents {
 		allocations[agent.ID] = &AgentAllocation{
 			AgentID:     agent.ID,
-			AllocatedBW: allocated,
+			AllocatedBW: plan.Bandwidth[i],
 			StartTime:   time.Now(),
 		}
 	}
 
-	return allocations
+	return allocations, plan
+}
+
+// planSchedule selects agents and allocates the target across them. If the
+// selected agents cannot reach the target, more are added up to the
+// concurrency limit.
+func (s *Scheduler) planSchedule(concurrency int) (map[string]*AgentAllocation, bandwidth.Allocation) {
+	// Nothing runs towards a zero target, as between p95 bursts or once the
+	// pool's transfer budget is used up
+	s.mu.RLock()
+	idle := s.state.TargetTotalBW <= 0
+	s.mu.RUnlock()
+	if idle {
+		return make(map[string]*AgentAllocation), bandwidth.Allocation{Feasible: true}
+	}
+
+	for {
+		selected := s.selectAgents(concurrency)
+		allocations, plan := s.allocateBandwidth(selected)
+
+		if plan.Feasible {
+			return allocations, plan
+		}
+
+		s.logger.Warnw("Bandwidth plan infeasible",
+			"agents", len(selected),
+			"target", plan.Target,
+			"planned", plan.Total,
+			"shortfall", plan.Shortfall,
+			"excess", plan.Excess,
+		)
+
+		// Only a shortfall is helped by more agents, if there are any left
+		// and selection is not overridden by an active set
+		if plan.Shortfall == 0 || len(selected) < concurrency || concurrency >= s.config.Scheduler.MaxConcurrent || s.overrides.activeSet() != nil {
+			return allocations, plan
+		}
+		concurrency++
+	}
 }
 
 // findAgentsToStop finds agents that should be stopped
@@ -414,49 +1018,6 @@ func (s *Scheduler) findAgentsToStart(newAllocations map[string]*AgentAllocation
 	return toStart
 }
 
-// rampDownAgents gradually stops agents
-func (s *Scheduler) rampDownAgents(agentIDs []string) {
-	if len(agentIDs) == 0 {
-		return
-	}
-
-	s.logger.Infow("Ramping down agents", "count", len(agentIDs))
-
-	delays := bandwidth.CalculateStagger(len(agentIDs), s.config.Scheduler.RampDownDuration.Seconds())
-
-	for i, agentID := range agentIDs {
-		delay := time.Duration(delays[i] * float64(time.Second))
-
-		go func(id string, d time.Duration) {
-			time.Sleep(d)
-			s.stopAgent(id)
-		}(agentID, delay)
-	}
-
-	// Wait for all to complete
-	time.Sleep(s.config.Scheduler.RampDownDuration)
-}
-
-// rampUpAgents gradually starts agents
-func (s *Scheduler) rampUpAgents(allocations []*AgentAllocation) {
-	if len(allocations) == 0 {
-		return
-	}
-
-	s.logger.Infow("Ramping up agents", "count", len(allocations))
-
-	delays := bandwidth.CalculateStagger(len(allocations), s.config.Scheduler.RampUpDuration.Seconds())
-
-	for i, alloc := range allocations {
-		delay := time.Duration(delays[i] * float64(time.Second))
-
-		go func(a *AgentAllocation, d time.Duration) {
-			time.Sleep(d)
-			s.startAgent(a)
-		}(alloc, delay)
-	}
-}
-
 // startAgent sends download command to an agent
 func (s *Scheduler) startAgent(alloc *AgentAllocation) {
 	// Select URL with type based on agent capabilities and configuration
@@ -465,8 +1026,14 @@ func (s *Scheduler) startAgent(alloc *AgentAllocation) {
 	// Duration: until next rotation + buffer
 	s.mu.RLock()
 	duration := time.Until(s.state.NextRotation) + 60*time.Second
+	draining := s.draining
 	s.mu.RUnlock()
 
+	if draining {
+		s.logger.Infow("Not starting agent while draining", "agent_id", alloc.AgentID)
+		return
+	}
+
 	commandID := uuid.New().String()
 
 	cmd := protocol.DownloadCommand{
@@ -491,16 +1058,26 @@ func (s *Scheduler) startAgent(alloc *AgentAllocation) {
 		return
 	}
 
-	// Update allocation
+	// Update allocation, which may already be in the active set, and the
+	// agent status, both read by selection and the API
+	s.mu.Lock()
 	alloc.CurrentCommand = commandID
 	alloc.URL = selection.URL
 	alloc.PlannedDuration = duration
+	alloc.CommandedBW = alloc.AllocatedBW
+	alloc.Commands = []*TrackedCommand{{
+		CommandID: commandID,
+		Kind:      CommandKindBase,
+		Bandwidth: alloc.AllocatedBW,
+		URL:       selection.URL,
+		StartedAt: time.Now(),
+	}}
 
-	// Update agent status
 	if status, ok := s.agentStatus[alloc.AgentID]; ok {
 		status.LastUsed = time.Now()
 		status.UseCount++
 	}
+	s.mu.Unlock()
 
 	s.logger.Infow("Started agent",
 		"agent_id", alloc.AgentID,
@@ -534,13 +1111,127 @@ func (s *Scheduler) stopAgent(agentID string) {
 	s.logger.Infow("Stopped agent", "agent_id", agentID)
 }
 
-// stopAllAgents stops all active agents
-func (s *Scheduler) stopAllAgents() {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// stopCommand sends a stop command for a single command to an agent
+func (s *Scheduler) stopCommand(agentID, commandID string) {
+	cmd := protocol.StopCommand{
+		CommandID: commandID,
+	}
 
-	for agentID := range s.state.ActiveAgents {
-		s.stopAgent(agentID)
+	msg, err := protocol.NewMessage(protocol.MsgTypeStopCommand, agentID, cmd)
+	if err != nil {
+		s.logger.Errorw("Failed to create stop command", "error", err)
+		return
+	}
+
+	if err := s.server.SendToAgent(agentID, msg); err != nil {
+		s.logger.Warnw("Failed to send stop command to agent",
+			"agent_id", agentID,
+			"command_id", commandID,
+			"error", err,
+		)
+		return
+	}
+
+	s.logger.Infow("Stopped command", "agent_id", agentID, "command_id", commandID)
+}
+
+// drain stops every connected agent on shutdown, including boost and
+// adopted jobs. Stops are staggered over the ramp-down duration and the
+// agents' acknowledgements awaited until the drain timeout.
+func (s *Scheduler) drain() {
+	s.mu.Lock()
+	s.draining = true
+	s.state.Phase = "draining"
+	s.mu.Unlock()
+
+	agentIDs := s.connectedAgents()
+	s.logger.Infow("Draining agents", "count", len(agentIDs))
+
+	deadline := time.Now().Add(s.config.Scheduler.RampDownDuration + s.config.Scheduler.DrainTimeout)
+	delays := bandwidth.CalculateStagger(len(agentIDs), s.config.Scheduler.RampDownDuration.Seconds())
+
+	var wg sync.WaitGroup
+	var countMu sync.Mutex
+	acked, unconfirmed := 0, 0
+
+	for i, agentID := range agentIDs {
+		delay := time.Duration(delays[i] * float64(time.Second))
+
+		wg.Add(1)
+		go func(id string, d time.Duration) {
+			defer wg.Done()
+			time.Sleep(d)
+
+			ok := s.stopAgentAndWait(id, deadline)
+
+			countMu.Lock()
+			if ok {
+				acked++
+			} else {
+				unconfirmed++
+			}
+			countMu.Unlock()
+		}(agentID, delay)
+	}
+	wg.Wait()
+
+	// Nothing is running any more, so nothing needs recovering
+	s.mu.Lock()
+	s.state.ActiveAgents = make(map[string]*AgentAllocation)
+	s.state.Phase = "idle"
+	s.mu.Unlock()
+
+	if unconfirmed > 0 {
+		s.logger.Warnw("Drain finished without confirmation from all agents",
+			"acknowledged", acked,
+			"unconfirmed", unconfirmed,
+		)
+		return
+	}
+	s.logger.Infow("Drain complete", "acknowledged", acked)
+}
+
+// stopAgentAndWait stops everything an agent is running and waits until the
+// deadline for it to acknowledge. It returns true if the agent confirmed.
+func (s *Scheduler) stopAgentAndWait(agentID string, deadline time.Time) bool {
+	requestID := uuid.New().String()
+	ack := s.server.AwaitAck(requestID)
+	defer s.server.CancelAck(requestID)
+
+	cmd := protocol.StopCommand{
+		CommandID: "", // Empty = stop all
+		RequestID: requestID,
+	}
+
+	msg, err := protocol.NewMessage(protocol.MsgTypeStopCommand, agentID, cmd)
+	if err != nil {
+		s.logger.Errorw("Failed to create stop command", "error", err)
+		return false
+	}
+
+	if err := s.server.SendToAgent(agentID, msg); err != nil {
+		s.logger.Warnw("Failed to send stop command to agent",
+			"agent_id", agentID,
+			"error", err,
+		)
+		return false
+	}
+
+	select {
+	case result := <-ack:
+		if !result.Success {
+			s.logger.Warnw("Agent failed to stop cleanly",
+				"agent_id", agentID,
+				"message", result.Message,
+			)
+			return false
+		}
+		s.logger.Infow("Agent drained", "agent_id", agentID)
+		return true
+
+	case <-time.After(time.Until(deadline)):
+		s.logger.Warnw("Timed out waiting for agent to acknowledge stop", "agent_id", agentID)
+		return false
 	}
 }
 
@@ -569,8 +1260,8 @@ func (s *Scheduler) selectURL(agentID string) URLSelection {
 		// Roll to decide download type based on configured percentages
 		roll := rand.Intn(100)
 		if roll < s.config.URLMix.YtDlpPercent {
-			// Select a random YouTube URL for yt-dlp
-			url := s.config.YouTubeURLs[rand.Intn(len(s.config.YouTubeURLs))]
+			// Select a YouTube URL for yt-dlp
+			url := s.selectLearnedURL(agentID, s.config.YouTubeURLs)
 			return URLSelection{
 				URL:  url,
 				Type: protocol.DownloadTypeYtDlp,
@@ -579,12 +1270,28 @@ func (s *Scheduler) selectURL(agentID string) URLSelection {
 	}
 
 	// Default: use wget with standard download URLs
+	if len(s.config.URLs) == 0 {
+		return URLSelection{
+			URL:  s.selectRandomURL(),
+			Type: protocol.DownloadTypeWget,
+		}
+	}
 	return URLSelection{
-		URL:  s.selectRandomURL(),
+		URL:  s.selectLearnedURL(agentID, s.config.URLs),
 		Type: protocol.DownloadTypeWget,
 	}
 }
 
+// selectLearnedURL picks one of urls at random, favouring those the agent
+// has delivered well on
+func (s *Scheduler) selectLearnedURL(agentID string, urls []string) string {
+	weights := make([]float64, len(urls))
+	for i, url := range urls {
+		weights[i] = s.model.URLEfficiency(agentID, url)
+	}
+	return urls[bandwidth.WeightedRandomSelection(1, weights)[0]]
+}
+
 // scheduleNextRotation schedules the next rotation
 func (s *Scheduler) scheduleNextRotation() {
 	minInterval := s.config.Scheduler.RotationIntervalMin.Seconds()
@@ -604,39 +1311,32 @@ func (s *Scheduler) scheduleNextRotation() {
 	)
 }
 
-// isRegionOverused checks if a region is overused in current active agents
-func (s *Scheduler) isRegionOverused(region string) bool {
-	if region == "" {
-		return false
-	}
-
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	regionCount := 0
-	totalActive := len(s.state.ActiveAgents)
-
-	for agentID := range s.state.ActiveAgents {
-		if status, ok := s.agentStatus[agentID]; ok {
-			if status.Region == region {
-				regionCount++
-			}
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
 		}
 	}
-
-	if totalActive == 0 {
-		return false
-	}
-
-	// Consider overused if more than 50% are from same region
-	return float64(regionCount)/float64(totalActive) > 0.5
+	return false
 }
 
 // OnAgentConnect is called when an agent connects
-func (s *Scheduler) OnAgentConnect(agentID string) {
+func (s *Scheduler) OnAgentConnect(agentID string, info *protocol.RegisterPayload) {
 	s.logger.Infow("Agent connected to scheduler", "agent_id", agentID)
 
-	// Agent will be included in next rotation
+	// A standby leaves running jobs alone; they are reconciled on takeover
+	if !s.server.IsLeader() {
+		return
+	}
+
+	s.adoptReportedCapacity(agentID, info)
+
+	// Adopt or stop whatever the agent is still running, and give agents
+	// that were active before a restart their allocation back
+	s.reconcileAgent(agentID, info.ActiveJobs)
+
+	// Otherwise the agent will be included in next rotation
 }
 
 // OnAgentDisconnect is called when an agent disconnects
@@ -646,8 +1346,11 @@ func (s *Scheduler) OnAgentDisconnect(agentID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Remove from active agents
+	// Remove from active agents; its share is reallocated by the main loop
+	alloc, wasActive := s.state.ActiveAgents[agentID]
 	delete(s.state.ActiveAgents, agentID)
+	delete(s.drains, agentID)
+	s.recordLostAllocation(agentID, alloc, wasActive)
 }
 
 // GetState returns current scheduler state (for API)
@@ -659,24 +1362,47 @@ func (s *Scheduler) GetState() SchedulerState {
 	state := *s.state
 	state.ActiveAgents = make(map[string]*AgentAllocation)
 	for k, v := range s.state.ActiveAgents {
-		allocCopy := *v
-		state.ActiveAgents[k] = &allocCopy
+		state.ActiveAgents[k] = copyAllocation(v)
 	}
 
 	return state
 }
 
-// snapshotMetrics periodically records metrics snapshots
-func (s *Scheduler) snapshotMetrics(ctx context.Context) {
-	ticker := time.NewTicker(60 * time.Second)
-	defer ticker.Stop()
+// Pool returns the name of the scheduler's pool
+func (s *Scheduler) Pool() string {
+	return s.pool
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			s.metrics.RecordSnapshot()
+// AgentIDs returns the agents in the scheduler's pool
+func (s *Scheduler) AgentIDs() []string {
+	agentIDs := make([]string, len(s.config.Agents))
+	for i, agent := range s.config.Agents {
+		agentIDs[i] = agent.ID
+	}
+	return agentIDs
+}
+
+// connectedAgents returns the connected agents in the scheduler's pool
+func (s *Scheduler) connectedAgents() []string {
+	var connected []string
+	for _, agentID := range s.server.GetConnectedAgents() {
+		if _, ok := s.agentConfig(agentID); ok {
+			connected = append(connected, agentID)
 		}
 	}
+	return connected
+}
+
+// aggregated returns the aggregated metrics of the pool's agents
+func (s *Scheduler) aggregated() AggregatedMetrics {
+	return s.metrics.GetAggregatedFor(s.AgentIDs())
+}
+
+// storeKey returns the key a pool's scheduler data is stored under. The
+// default pool keeps the keys used before pools existed.
+func (s *Scheduler) storeKey(key string) string {
+	if s.pool == DefaultPool {
+		return key
+	}
+	return s.pool + "/" + key
 }
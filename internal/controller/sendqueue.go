@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+// SendPriority orders outbound messages within a client's send queue
+type SendPriority int
+
+const (
+	// PriorityCritical is used for stop and shutdown messages
+	PriorityCritical SendPriority = iota
+	// PriorityNormal is used for download and other commands
+	PriorityNormal
+	// PriorityLow is used for health checks
+	PriorityLow
+
+	numSendPriorities = 3
+)
+
+// String returns the priority name
+func (p SendPriority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+var (
+	// ErrSendQueueFull is returned when a message cannot be queued
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrSendQueueClosed is returned when the client has gone away
+	ErrSendQueueClosed = errors.New("send queue closed")
+	// ErrNotLeader is returned when a standby controller tries to send a command
+	ErrNotLeader = errors.New("controller is not the leader")
+)
+
+// PriorityFor returns the send priority for a message type
+func PriorityFor(msgType protocol.MessageType) SendPriority {
+	switch msgType {
+	case protocol.MsgTypeStopCommand, protocol.MsgTypeShutdown, protocol.MsgTypeRegisterAck:
+		return PriorityCritical
+	case protocol.MsgTypeHealthCheck:
+		return PriorityLow
+	default:
+		return PriorityNormal
+	}
+}
+
+// queuedMessage is a message waiting in the send queue
+type queuedMessage struct {
+	msg      *protocol.Message
+	queuedAt time.Time
+}
+
+// SendQueueStats contains send queue statistics for a client
+type SendQueueStats struct {
+	Depth          int           `json:"depth"`
+	DepthCritical  int           `json:"depth_critical"`
+	DepthNormal    int           `json:"depth_normal"`
+	DepthLow       int           `json:"depth_low"`
+	HighWatermark  int           `json:"high_watermark"`
+	Enqueued       int64         `json:"enqueued"`
+	Sent           int64         `json:"sent"`
+	Coalesced      int64         `json:"coalesced"`
+	Dropped        int64         `json:"dropped"`
+	OldestWait     time.Duration `json:"oldest_wait_ns"`
+	Degraded       bool          `json:"degraded"`
+	DegradedSince  time.Time     `json:"degraded_since,omitempty"`
+	DegradedReason string        `json:"degraded_reason,omitempty"`
+}
+
+// SendQueue is a per-client outbound queue with priorities, coalescing of
+// superseded commands and slow-consumer detection
+type SendQueue struct {
+	mu       sync.Mutex
+	queues   [numSendPriorities][]*queuedMessage
+	notify   chan struct{}
+	closed   bool
+	capacity int
+	maxLag   time.Duration
+	stats    SendQueueStats
+}
+
+// NewSendQueue creates a send queue holding at most capacity messages.
+// A consumer is considered slow once the queue is three quarters full or
+// the oldest message has waited longer than maxLag.
+func NewSendQueue(capacity int, maxLag time.Duration) *SendQueue {
+	if capacity <= 0 {
+		capacity = 256
+	}
+	return &SendQueue{
+		notify:   make(chan struct{}, 1),
+		capacity: capacity,
+		maxLag:   maxLag,
+	}
+}
+
+// Push queues a message, coalescing it with any pending messages it supersedes
+func (q *SendQueue) Push(msg *protocol.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrSendQueueClosed
+	}
+
+	priority := PriorityFor(msg.Type)
+
+	if q.coalesce(msg) {
+		// The message itself was made redundant by what is already queued
+		q.stats.Coalesced++
+		return nil
+	}
+
+	if q.depth() >= q.capacity {
+		// Critical messages evict the oldest lowest-priority message
+		if priority != PriorityCritical || !q.evictLowest() {
+			q.stats.Dropped++
+			q.markDegraded("queue full")
+			return ErrSendQueueFull
+		}
+		q.stats.Dropped++
+	}
+
+	q.queues[priority] = append(q.queues[priority], &queuedMessage{
+		msg:      msg,
+		queuedAt: time.Now(),
+	})
+	q.stats.Enqueued++
+
+	depth := q.depth()
+	if depth > q.stats.HighWatermark {
+		q.stats.HighWatermark = depth
+	}
+	if depth >= q.capacity*3/4 {
+		q.markDegraded("queue depth above threshold")
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Pop removes the highest-priority pending message, if any
+func (q *SendQueue) Pop() (*protocol.Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for p := range q.queues {
+		if len(q.queues[p]) == 0 {
+			continue
+		}
+
+		item := q.queues[p][0]
+		q.queues[p][0] = nil
+		q.queues[p] = q.queues[p][1:]
+		q.stats.Sent++
+		q.refreshDegraded()
+
+		return item.msg, true
+	}
+
+	return nil, false
+}
+
+// Ready returns a channel that is signalled when messages are queued
+func (q *SendQueue) Ready() <-chan struct{} {
+	return q.notify
+}
+
+// Close discards pending messages and rejects further pushes
+func (q *SendQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	for p := range q.queues {
+		q.queues[p] = nil
+	}
+}
+
+// Degraded reports whether the consumer is currently considered slow
+func (q *SendQueue) Degraded() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.refreshDegraded()
+	return q.stats.Degraded
+}
+
+// Stats returns a snapshot of queue statistics
+func (q *SendQueue) Stats() SendQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.refreshDegraded()
+	stats := q.stats
+	stats.DepthCritical = len(q.queues[PriorityCritical])
+	stats.DepthNormal = len(q.queues[PriorityNormal])
+	stats.DepthLow = len(q.queues[PriorityLow])
+	stats.Depth = q.depth()
+	stats.OldestWait = q.oldestWait()
+	return stats
+}
+
+// coalesce drops pending messages superseded by msg. It returns true if msg
+// itself is redundant and should not be queued.
+func (q *SendQueue) coalesce(msg *protocol.Message) bool {
+	switch msg.Type {
+	case protocol.MsgTypeShutdown:
+		// Nothing queued before a shutdown matters any more
+		q.removeWhere(func(m *protocol.Message) bool {
+			return m.Type != protocol.MsgTypeShutdown
+		})
+
+	case protocol.MsgTypeStopCommand:
+		var stop protocol.StopCommand
+		if err := msg.UnmarshalPayload(&stop); err != nil {
+			return false
+		}
+
+		if stop.CommandID == "" {
+			// Stop-all supersedes pending downloads, adjustments and earlier stops
+			q.removeWhere(func(m *protocol.Message) bool {
+				return m.Type == protocol.MsgTypeDownloadCommand || m.Type == protocol.MsgTypeAdjustCommand ||
+					m.Type == protocol.MsgTypeStopCommand
+			})
+			return false
+		}
+
+		// Adjustments to a command being stopped are moot
+		q.removeWhere(func(m *protocol.Message) bool {
+			return m.Type == protocol.MsgTypeAdjustCommand && commandIDOf(m) == stop.CommandID
+		})
+
+		// A stop for a download that was never sent cancels both
+		if q.removeWhere(func(m *protocol.Message) bool {
+			return m.Type == protocol.MsgTypeDownloadCommand && commandIDOf(m) == stop.CommandID
+		}) > 0 {
+			return true
+		}
+
+	case protocol.MsgTypeAdjustCommand:
+		// Only the latest bandwidth for a command matters
+		commandID := commandIDOf(msg)
+		q.removeWhere(func(m *protocol.Message) bool {
+			return m.Type == protocol.MsgTypeAdjustCommand && commandIDOf(m) == commandID
+		})
+
+	case protocol.MsgTypeHealthCheck, protocol.MsgTypeStatusRequest:
+		// Only the latest health check or status request is worth sending
+		q.removeWhere(func(m *protocol.Message) bool {
+			return m.Type == msg.Type
+		})
+	}
+
+	return false
+}
+
+// removeWhere removes pending messages matching the predicate and returns
+// the number removed
+func (q *SendQueue) removeWhere(match func(*protocol.Message) bool) int {
+	removed := 0
+	for p := range q.queues {
+		kept := q.queues[p][:0]
+		for _, item := range q.queues[p] {
+			if match(item.msg) {
+				removed++
+				continue
+			}
+			kept = append(kept, item)
+		}
+		for i := len(kept); i < len(q.queues[p]); i++ {
+			q.queues[p][i] = nil
+		}
+		q.queues[p] = kept
+	}
+	q.stats.Coalesced += int64(removed)
+	return removed
+}
+
+// evictLowest drops the oldest message of the lowest non-critical priority
+func (q *SendQueue) evictLowest() bool {
+	for p := numSendPriorities - 1; p > int(PriorityCritical); p-- {
+		if len(q.queues[p]) > 0 {
+			q.queues[p][0] = nil
+			q.queues[p] = q.queues[p][1:]
+			return true
+		}
+	}
+	return false
+}
+
+// markDegraded flags the consumer as slow
+func (q *SendQueue) markDegraded(reason string) {
+	if q.stats.Degraded {
+		return
+	}
+	q.stats.Degraded = true
+	q.stats.DegradedSince = time.Now()
+	q.stats.DegradedReason = reason
+}
+
+// refreshDegraded flags the consumer as slow while the oldest message has
+// waited longer than maxLag, and clears the flag once the consumer caught
+// up: the queue is down to a quarter and nothing in it is lagging
+func (q *SendQueue) refreshDegraded() {
+	lagging := q.maxLag > 0 && q.oldestWait() > q.maxLag
+	if lagging {
+		q.markDegraded("messages waiting longer than max lag")
+		return
+	}
+	if q.stats.Degraded && q.depth() <= q.capacity/4 {
+		q.stats.Degraded = false
+		q.stats.DegradedSince = time.Time{}
+		q.stats.DegradedReason = ""
+	}
+}
+
+// depth returns the number of pending messages
+func (q *SendQueue) depth() int {
+	total := 0
+	for p := range q.queues {
+		total += len(q.queues[p])
+	}
+	return total
+}
+
+// oldestWait returns how long the oldest pending message has been waiting
+func (q *SendQueue) oldestWait() time.Duration {
+	var oldest time.Time
+	for p := range q.queues {
+		if len(q.queues[p]) > 0 {
+			queuedAt := q.queues[p][0].queuedAt
+			if oldest.IsZero() || queuedAt.Before(oldest) {
+				oldest = queuedAt
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// commandIDOf extracts the command ID of a download or adjust command message
+func commandIDOf(msg *protocol.Message) string {
+	var cmd struct {
+		CommandID string `json:"command_id"`
+	}
+	if err := msg.UnmarshalPayload(&cmd); err != nil {
+		return ""
+	}
+	return cmd.CommandID
+}
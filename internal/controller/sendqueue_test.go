@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mashiro/google-bandwidth-controller/internal/protocol"
+)
+
+func message(t *testing.T, msgType protocol.MessageType, payload interface{}) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.NewMessage(msgType, "agent-001", payload)
+	if err != nil {
+		t.Fatalf("NewMessage: %v", err)
+	}
+	return msg
+}
+
+func drain(q *SendQueue) []protocol.MessageType {
+	var types []protocol.MessageType
+	for {
+		msg, ok := q.Pop()
+		if !ok {
+			return types
+		}
+		types = append(types, msg.Type)
+	}
+}
+
+func TestSendQueueCoalescing(t *testing.T) {
+	download := func(id string) interface{} { return protocol.DownloadCommand{CommandID: id} }
+	adjust := func(id string) interface{} { return protocol.AdjustCommand{CommandID: id} }
+	stop := func(id string) interface{} { return protocol.StopCommand{CommandID: id} }
+
+	tests := []struct {
+		name     string
+		pushes   []protocol.MessageType
+		payloads []interface{}
+		want     []protocol.MessageType
+	}{
+		{
+			name:     "latest adjust wins",
+			pushes:   []protocol.MessageType{protocol.MsgTypeAdjustCommand, protocol.MsgTypeAdjustCommand},
+			payloads: []interface{}{adjust("a"), adjust("a")},
+			want:     []protocol.MessageType{protocol.MsgTypeAdjustCommand},
+		},
+		{
+			name:     "adjusts to other commands kept",
+			pushes:   []protocol.MessageType{protocol.MsgTypeAdjustCommand, protocol.MsgTypeAdjustCommand},
+			payloads: []interface{}{adjust("a"), adjust("b")},
+			want:     []protocol.MessageType{protocol.MsgTypeAdjustCommand, protocol.MsgTypeAdjustCommand},
+		},
+		{
+			name:     "stop cancels unsent download",
+			pushes:   []protocol.MessageType{protocol.MsgTypeDownloadCommand, protocol.MsgTypeAdjustCommand, protocol.MsgTypeStopCommand},
+			payloads: []interface{}{download("a"), adjust("a"), stop("a")},
+			want:     nil,
+		},
+		{
+			name:     "stop all supersedes commands",
+			pushes:   []protocol.MessageType{protocol.MsgTypeDownloadCommand, protocol.MsgTypeAdjustCommand, protocol.MsgTypeStopCommand},
+			payloads: []interface{}{download("a"), adjust("b"), stop("")},
+			want:     []protocol.MessageType{protocol.MsgTypeStopCommand},
+		},
+		{
+			name:     "shutdown supersedes everything",
+			pushes:   []protocol.MessageType{protocol.MsgTypeDownloadCommand, protocol.MsgTypeHealthCheck, protocol.MsgTypeShutdown},
+			payloads: []interface{}{download("a"), nil, nil},
+			want:     []protocol.MessageType{protocol.MsgTypeShutdown},
+		},
+		{
+			name:     "latest health check wins",
+			pushes:   []protocol.MessageType{protocol.MsgTypeHealthCheck, protocol.MsgTypeHealthCheck},
+			payloads: []interface{}{nil, nil},
+			want:     []protocol.MessageType{protocol.MsgTypeHealthCheck},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewSendQueue(16, 0)
+			for i, msgType := range tt.pushes {
+				if err := q.Push(message(t, msgType, tt.payloads[i])); err != nil {
+					t.Fatalf("Push(%s): %v", msgType, err)
+				}
+			}
+			got := drain(q)
+			if len(got) != len(tt.want) {
+				t.Fatalf("sent %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("sent %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := NewSendQueue(16, 0)
+	pushes := []struct {
+		msgType protocol.MessageType
+		payload interface{}
+	}{
+		{protocol.MsgTypeHealthCheck, nil},
+		{protocol.MsgTypeDownloadCommand, protocol.DownloadCommand{CommandID: "a"}},
+		{protocol.MsgTypeStopCommand, protocol.StopCommand{CommandID: "b"}},
+	}
+	for _, p := range pushes {
+		if err := q.Push(message(t, p.msgType, p.payload)); err != nil {
+			t.Fatalf("Push(%s): %v", p.msgType, err)
+		}
+	}
+
+	want := []protocol.MessageType{protocol.MsgTypeStopCommand, protocol.MsgTypeDownloadCommand, protocol.MsgTypeHealthCheck}
+	got := drain(q)
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueFullEvictsForCritical(t *testing.T) {
+	q := NewSendQueue(2, 0)
+	for _, id := range []string{"a", "b"} {
+		if err := q.Push(message(t, protocol.MsgTypeDownloadCommand, protocol.DownloadCommand{CommandID: id})); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+
+	if err := q.Push(message(t, protocol.MsgTypeDownloadCommand, protocol.DownloadCommand{CommandID: "c"})); err != ErrSendQueueFull {
+		t.Fatalf("Push to full queue = %v, want ErrSendQueueFull", err)
+	}
+	if err := q.Push(message(t, protocol.MsgTypeStopCommand, protocol.StopCommand{CommandID: "x"})); err != nil {
+		t.Fatalf("critical Push to full queue = %v, want eviction", err)
+	}
+	if stats := q.Stats(); stats.Depth != 2 || stats.DepthCritical != 1 || stats.Dropped != 2 {
+		t.Fatalf("stats = %+v, want depth 2 with 1 critical and 2 dropped", stats)
+	}
+}
+
+func TestSendQueueDegraded(t *testing.T) {
+	tests := []struct {
+		name         string
+		capacity     int
+		maxLag       time.Duration
+		pushes       int
+		wait         time.Duration
+		pops         int
+		wantDegraded bool
+	}{
+		{name: "healthy", capacity: 8, pushes: 2, wantDegraded: false},
+		{name: "deep queue", capacity: 8, pushes: 6, wantDegraded: true},
+		{name: "deep queue drained", capacity: 8, pushes: 6, pops: 4, wantDegraded: false},
+		{name: "lagging", capacity: 8, maxLag: 10 * time.Millisecond, pushes: 1, wait: 20 * time.Millisecond, wantDegraded: true},
+		{name: "lagging drained", capacity: 8, maxLag: 10 * time.Millisecond, pushes: 1, wait: 20 * time.Millisecond, pops: 1, wantDegraded: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewSendQueue(tt.capacity, tt.maxLag)
+			for i := 0; i < tt.pushes; i++ {
+				cmd := protocol.DownloadCommand{CommandID: string(rune('a' + i))}
+				if err := q.Push(message(t, protocol.MsgTypeDownloadCommand, cmd)); err != nil {
+					t.Fatalf("Push: %v", err)
+				}
+			}
+			time.Sleep(tt.wait)
+			if tt.wait > 0 && !q.Degraded() {
+				t.Fatalf("Degraded() = false while lagging")
+			}
+			for i := 0; i < tt.pops; i++ {
+				q.Pop()
+			}
+			if got := q.Degraded(); got != tt.wantDegraded {
+				t.Fatalf("Degraded() = %v, want %v", got, tt.wantDegraded)
+			}
+		})
+	}
+}
@@ -15,13 +15,28 @@ import (
 
 // Server is the controller WebSocket server
 type Server struct {
-	config    *Config
-	upgrader  websocket.Upgrader
-	clients   sync.Map // map[string]*Client (agentID -> Client)
-	scheduler *Scheduler
-	metrics   *MetricsAggregator
-	logger    *logger.Logger
-	mu        sync.RWMutex
+	config   *Config
+	upgrader websocket.Upgrader
+	clients  sync.Map // map[string]*Client (agentID -> Client)
+	metrics  *MetricsAggregator
+	elector  *Elector // nil when HA is disabled
+	acks     sync.Map // map[string]chan protocol.CommandAck (requestID -> waiter)
+	store    *Store
+	logger   *logger.Logger
+	mu       sync.RWMutex
+
+	// Emergency stop fence, shared by every pool
+	fence   FenceStatus
+	fenceMu sync.Mutex
+
+	// One scheduler per pool, in configuration order, and by agent
+	schedulers []*Scheduler
+	agentPools map[string]*Scheduler
+	// Leadership changes fanned out to the schedulers
+	leadershipSubs []chan bool
+	// Schedulers leading; the store is open while any are
+	leaders int
+	leadMu  sync.Mutex
 }
 
 // Client represents a connected agent
@@ -29,14 +44,15 @@ type Client struct {
 	AgentID    string
 	AgentName  string
 	Conn       *websocket.Conn
-	SendChan   chan *protocol.Message
+	Queue      *SendQueue
 	LastSeen   time.Time
 	Info       *protocol.RegisterPayload
 	mu         sync.Mutex
 }
 
-// NewServer creates a new controller server
-func NewServer(config *Config, log *logger.Logger) *Server {
+// NewServer creates a new controller server. The store may be nil to run
+// without persistence, and the elector nil to always act as leader.
+func NewServer(config *Config, store *Store, elector *Elector, log *logger.Logger) *Server {
 	server := &Server{
 		config: config,
 		upgrader: websocket.Upgrader{
@@ -44,11 +60,20 @@ func NewServer(config *Config, log *logger.Logger) *Server {
 				return true // Allow all origins for simplicity
 			},
 		},
-		logger: log,
+		elector:    elector,
+		store:      store,
+		logger:     log,
+		agentPools: make(map[string]*Scheduler),
 	}
 
-	server.metrics = NewMetricsAggregator(config, log)
-	server.scheduler = NewScheduler(config, server, server.metrics, log)
+	server.metrics = NewMetricsAggregator(config, store, log)
+	for _, pool := range config.PoolConfigs() {
+		scheduler := NewScheduler(pool.Name, config.ForPool(pool), server, server.metrics, store, log.With("pool", pool.Name))
+		server.schedulers = append(server.schedulers, scheduler)
+		for _, agentID := range pool.Agents {
+			server.agentPools[agentID] = scheduler
+		}
+	}
 
 	return server
 }
@@ -66,8 +91,22 @@ func (s *Server) Start(ctx context.Context) error {
 
 	s.logger.Infow("Starting WebSocket server", "address", addr)
 
-	// Start scheduler
-	go s.scheduler.Run(ctx)
+	// Contend for leadership. The lease is held until agents are drained,
+	// so a standby does not take over while stop commands are in flight.
+	electorCtx, stopElector := context.WithCancel(context.Background())
+	defer stopElector()
+	if s.elector != nil {
+		go s.elector.Run(electorCtx)
+		go s.forwardLeadership(electorCtx)
+	}
+
+	// Start a scheduler per pool
+	for _, scheduler := range s.schedulers {
+		go scheduler.Run(ctx)
+	}
+
+	// Snapshot metrics periodically
+	go s.snapshotMetrics(ctx)
 
 	// Start client health checker
 	go s.healthCheckClients(ctx)
@@ -84,6 +123,29 @@ func (s *Server) Start(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		s.logger.Info("Shutting down WebSocket server")
+
+		// Let the schedulers ramp agents down before dropping connections
+		var drainLimit time.Duration
+		for _, scheduler := range s.schedulers {
+			limit := scheduler.config.Scheduler.RampDownDuration + scheduler.config.Scheduler.DrainTimeout
+			if limit > drainLimit {
+				drainLimit = limit
+			}
+		}
+		deadline := time.After(drainLimit + 5*time.Second)
+	wait:
+		for _, scheduler := range s.schedulers {
+			select {
+			case <-scheduler.Done():
+			case <-deadline:
+				s.logger.Warnw("Timed out waiting for scheduler to drain agents", "pool", scheduler.Pool())
+				break wait
+			}
+		}
+
+		s.CloseAll("controller shutting down")
+		stopElector()
+
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		return httpServer.Shutdown(shutdownCtx)
@@ -104,6 +166,13 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the leader takes agents; a standby turns them away so they try
+	// the next controller
+	if !s.IsLeader() {
+		http.Error(w, "Standby controller", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Upgrade connection
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -113,7 +182,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	client := &Client{
 		Conn:     conn,
-		SendChan: make(chan *protocol.Message, 256),
+		Queue:    NewSendQueue(s.config.Server.SendQueueSize, s.config.Server.SendQueueMaxLag),
 		LastSeen: time.Now(),
 	}
 
@@ -127,9 +196,12 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleClient(client *Client) {
 	defer func() {
 		client.Conn.Close()
+		client.Queue.Close()
 		if client.AgentID != "" {
 			s.clients.Delete(client.AgentID)
-			s.scheduler.OnAgentDisconnect(client.AgentID)
+			if scheduler := s.schedulerFor(client.AgentID); scheduler != nil {
+				scheduler.OnAgentDisconnect(client.AgentID)
+			}
 			s.metrics.RemoveAgent(client.AgentID)
 			s.logger.Infow("Agent disconnected", "agent_id", client.AgentID, "agent_name", client.AgentName)
 		}
@@ -161,17 +233,26 @@ func (s *Server) writeMessages(client *Client) {
 
 	for {
 		select {
-		case msg := <-client.SendChan:
-			client.mu.Lock()
-			err := client.Conn.WriteJSON(msg)
-			client.mu.Unlock()
-
-			if err != nil {
-				s.logger.Errorw("Failed to send message to agent",
-					"agent_id", client.AgentID,
-					"error", err,
-				)
-				return
+		case <-client.Queue.Ready():
+			// Drain everything queued, highest priority first
+			for {
+				msg, ok := client.Queue.Pop()
+				if !ok {
+					break
+				}
+
+				client.mu.Lock()
+				err := client.Conn.WriteJSON(msg)
+				client.mu.Unlock()
+
+				if err != nil {
+					s.logger.Errorw("Failed to send message to agent",
+						"agent_id", client.AgentID,
+						"type", msg.Type,
+						"error", err,
+					)
+					return
+				}
 			}
 
 		case <-ticker.C:
@@ -217,6 +298,24 @@ func (s *Server) processMessage(client *Client, msg *protocol.Message) {
 		}
 		s.handleStatus(client, &payload)
 
+	case protocol.MsgTypeCommandAck:
+		var payload protocol.CommandAck
+		if err := msg.UnmarshalPayload(&payload); err != nil {
+			s.logger.Errorw("Failed to unmarshal command ack", "error", err)
+			return
+		}
+		s.handleCommandAck(client, &payload)
+
+	case protocol.MsgTypeCalibrationResult:
+		var payload protocol.CalibrationResult
+		if err := msg.UnmarshalPayload(&payload); err != nil {
+			s.logger.Errorw("Failed to unmarshal calibration result", "error", err)
+			return
+		}
+		if scheduler := s.schedulerFor(client.AgentID); scheduler != nil {
+			scheduler.OnCalibrationResult(client.AgentID, &payload)
+		}
+
 	case protocol.MsgTypeError:
 		var payload protocol.ErrorPayload
 		if err := msg.UnmarshalPayload(&payload); err != nil {
@@ -247,10 +346,19 @@ func (s *Server) handleRegister(client *Client, payload *protocol.RegisterPayloa
 		"agent_name", payload.Name,
 		"version", payload.Version,
 		"capabilities", payload.Capabilities,
+		"active_jobs", len(payload.ActiveJobs),
 	)
 
-	// Notify scheduler
-	s.scheduler.OnAgentConnect(payload.AgentID)
+	// Stops the agent again if it reconnects during an emergency stop
+	s.sendRegisterAck(payload.AgentID, payload.Nonce)
+
+	// Notify the scheduler of the agent's pool
+	scheduler := s.schedulerFor(payload.AgentID)
+	if scheduler == nil {
+		s.logger.Warnw("Agent is not in any pool and will not be scheduled", "agent_id", payload.AgentID)
+		return
+	}
+	scheduler.OnAgentConnect(payload.AgentID, payload)
 }
 
 // handleMetrics handles metrics from agents
@@ -261,6 +369,9 @@ func (s *Server) handleMetrics(client *Client, payload *protocol.MetricsPayload)
 	}
 
 	s.metrics.UpdateAgentMetrics(client.AgentID, payload)
+	if scheduler := s.schedulerFor(client.AgentID); scheduler != nil {
+		scheduler.OnAgentMetrics(client.AgentID, payload)
+	}
 }
 
 // handleStatus handles status updates from agents
@@ -270,6 +381,63 @@ func (s *Server) handleStatus(client *Client, payload *protocol.StatusPayload) {
 		"state", payload.State,
 		"active_commands", len(payload.ActiveCommands),
 	)
+
+	if scheduler := s.schedulerFor(client.AgentID); scheduler != nil {
+		scheduler.OnAgentStatus(client.AgentID, payload)
+	}
+}
+
+// handleCommandAck delivers a command acknowledgement to whoever awaits it
+func (s *Server) handleCommandAck(client *Client, payload *protocol.CommandAck) {
+	s.logger.Debugw("Received command ack from agent",
+		"agent_id", client.AgentID,
+		"request_id", payload.RequestID,
+		"success", payload.Success,
+	)
+
+	waiter, ok := s.acks.LoadAndDelete(payload.RequestID)
+	if !ok {
+		return
+	}
+
+	waiter.(chan protocol.CommandAck) <- *payload
+}
+
+// AwaitAck registers interest in the acknowledgement for requestID. The
+// returned channel receives the ack; call CancelAck if it is no longer needed.
+func (s *Server) AwaitAck(requestID string) <-chan protocol.CommandAck {
+	waiter := make(chan protocol.CommandAck, 1)
+	s.acks.Store(requestID, waiter)
+	return waiter
+}
+
+// CancelAck stops waiting for the acknowledgement for requestID
+func (s *Server) CancelAck(requestID string) {
+	s.acks.Delete(requestID)
+}
+
+// CloseAll closes every agent connection with a going-away close frame
+func (s *Server) CloseAll(reason string) {
+	s.closeAll(websocket.CloseGoingAway, reason)
+}
+
+// closeAll closes every agent connection with a close code
+func (s *Server) closeAll(code int, reason string) {
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+
+	s.clients.Range(func(key, value interface{}) bool {
+		client := value.(*Client)
+
+		client.mu.Lock()
+		err := client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.mu.Unlock()
+
+		if err != nil {
+			s.logger.Debugw("Failed to send close frame", "agent_id", client.AgentID, "error", err)
+		}
+		client.Conn.Close()
+		return true
+	})
 }
 
 // handleError handles error messages from agents
@@ -291,14 +459,185 @@ func (s *Server) SendToAgent(agentID string, msg *protocol.Message) error {
 
 	client := clientVal.(*Client)
 
-	select {
-	case client.SendChan <- msg:
+	// Standby controllers watch agents but never command them
+	if isCommand(msg.Type) && !s.IsLeader() {
+		return fmt.Errorf("agent %s: %w", agentID, ErrNotLeader)
+	}
+
+	// Nothing new is started during an emergency stop
+	if startsWork(msg.Type) && s.Fenced() {
+		return fmt.Errorf("agent %s: %w", agentID, ErrFenced)
+	}
+
+	if err := client.Queue.Push(msg); err != nil {
+		if err == ErrSendQueueFull {
+			s.logger.Warnw("Agent send queue full, marking degraded",
+				"agent_id", agentID,
+				"type", msg.Type,
+				"priority", PriorityFor(msg.Type).String(),
+			)
+		}
+		return fmt.Errorf("agent %s: %w", agentID, err)
+	}
+
+	return nil
+}
+
+// IsLeader reports whether this controller may issue commands
+func (s *Server) IsLeader() bool {
+	if s.elector == nil {
+		return true
+	}
+	return s.elector.IsLeader()
+}
+
+// watchLeadership returns a channel receiving leadership changes. It is
+// nil, and never fires, when HA is disabled. Only the latest change is kept
+// for a slow receiver.
+func (s *Server) watchLeadership() <-chan bool {
+	if s.elector == nil {
 		return nil
+	}
+
+	ch := make(chan bool, 1)
+	s.mu.Lock()
+	s.leadershipSubs = append(s.leadershipSubs, ch)
+	s.mu.Unlock()
+	return ch
+}
+
+// takeLead opens the store and restores the controller-wide state when the
+// first scheduler takes the lead. Every pool shares the store, so it stays
+// open until the last scheduler hands the lead back with releaseLead.
+func (s *Server) takeLead() {
+	s.leadMu.Lock()
+	defer s.leadMu.Unlock()
+
+	s.leaders++
+	if s.leaders > 1 {
+		return
+	}
+
+	if err := s.store.Open(); err != nil {
+		s.logger.Warnw("Failed to open state store, continuing without persistence", "error", err)
+	}
+	s.metrics.RestoreHistory()
+	s.restoreFence()
+}
+
+// releaseLead closes the store once the last scheduler has stepped down or
+// stopped. Nothing is written after a step-down, since the new leader owns
+// the store now.
+func (s *Server) releaseLead() {
+	s.leadMu.Lock()
+	defer s.leadMu.Unlock()
+
+	s.leaders--
+	if s.leaders > 0 {
+		return
+	}
+
+	if err := s.store.Close(); err != nil {
+		s.logger.Warnw("Failed to close state store", "error", err)
+	}
+}
+
+// forwardLeadership passes the elector's leadership changes on to every
+// watcher, so each scheduler sees them. On losing the lead it closes the
+// agent connections, so agents move on to the new leader.
+func (s *Server) forwardLeadership(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case leader := <-s.elector.Changes():
+			s.mu.RLock()
+			for _, ch := range s.leadershipSubs {
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- leader
+			}
+			s.mu.RUnlock()
+
+			if !leader {
+				s.closeAll(websocket.CloseTryAgainLater, "controller is standby")
+			}
+		}
+	}
+}
+
+// GetLeaderInfo describes this instance's role and the current lease
+func (s *Server) GetLeaderInfo() map[string]interface{} {
+	if s.elector == nil {
+		return map[string]interface{}{
+			"ha_enabled": false,
+			"role":       "leader",
+		}
+	}
+
+	role := "standby"
+	if s.elector.IsLeader() {
+		role = "leader"
+	}
+
+	info := map[string]interface{}{
+		"ha_enabled":  true,
+		"role":        role,
+		"instance_id": s.elector.InstanceID(),
+	}
+
+	if lease, err := s.elector.Current(); err == nil {
+		info["lease_holder"] = lease.Holder
+		info["lease_expires_at"] = lease.ExpiresAt
+	}
+
+	return info
+}
+
+// RequestStatus asks an agent to report its status and running jobs
+func (s *Server) RequestStatus(agentID string) error {
+	msg, err := protocol.NewMessage(protocol.MsgTypeStatusRequest, agentID, struct{}{})
+	if err != nil {
+		return err
+	}
+	return s.SendToAgent(agentID, msg)
+}
+
+// isCommand reports whether a message type changes what an agent does
+func isCommand(msgType protocol.MessageType) bool {
+	switch msgType {
+	case protocol.MsgTypeHealthCheck, protocol.MsgTypeStatusRequest:
+		return false
 	default:
-		return fmt.Errorf("agent %s send channel full", agentID)
+		return true
 	}
 }
 
+// IsAgentDegraded reports whether an agent is a slow consumer of commands
+func (s *Server) IsAgentDegraded(agentID string) bool {
+	client, ok := s.GetClient(agentID)
+	if !ok {
+		return false
+	}
+	return client.Queue.Degraded()
+}
+
+// GetSendQueueStats returns send queue statistics for the given connected
+// agents, or for all connected agents if agentIDs is nil
+func (s *Server) GetSendQueueStats(agentIDs []string) map[string]SendQueueStats {
+	stats := make(map[string]SendQueueStats)
+	s.clients.Range(func(key, value interface{}) bool {
+		if agentIDs != nil && !containsString(agentIDs, key.(string)) {
+			return true
+		}
+		stats[key.(string)] = value.(*Client).Queue.Stats()
+		return true
+	})
+	return stats
+}
+
 // GetConnectedAgents returns a list of connected agent IDs
 func (s *Server) GetConnectedAgents() []string {
 	var agents []string
@@ -366,9 +705,40 @@ func (s *Server) checkStaleClients() {
 	})
 }
 
-// GetScheduler returns the scheduler instance
-func (s *Server) GetScheduler() *Scheduler {
-	return s.scheduler
+// schedulerFor returns the scheduler of an agent's pool, or nil if the
+// agent is in no pool
+func (s *Server) schedulerFor(agentID string) *Scheduler {
+	return s.agentPools[agentID]
+}
+
+// GetSchedulers returns the scheduler of every pool, in configuration order
+func (s *Server) GetSchedulers() []*Scheduler {
+	return s.schedulers
+}
+
+// GetScheduler returns the scheduler of a pool
+func (s *Server) GetScheduler(pool string) (*Scheduler, bool) {
+	for _, scheduler := range s.schedulers {
+		if scheduler.Pool() == pool {
+			return scheduler, true
+		}
+	}
+	return nil, false
+}
+
+// snapshotMetrics periodically records metrics snapshots
+func (s *Server) snapshotMetrics(ctx context.Context) {
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.metrics.RecordSnapshot()
+		}
+	}
 }
 
 // GetMetrics returns the metrics aggregator instance
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store bucket names
+const (
+	bucketScheduler = "scheduler"
+	bucketAgents    = "agents"
+	bucketHistory   = "history"
+	bucketCapacity  = "capacity"
+)
+
+// Store persists controller state in an embedded bbolt database.
+// A nil or closed *Store is valid and silently discards writes, which is
+// how persistence is disabled and how standby controllers stay off a
+// database shared with the leader.
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	db   *bolt.DB
+}
+
+// NewStore creates a store for the database at path without opening it
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Open opens (or creates) the database. Opening an open store is a no-op.
+func (s *Store) Open() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open state database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucketScheduler, bucketAgents, bucketHistory, bucketCapacity} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize state database: %w", err)
+	}
+
+	s.db = db
+	return nil
+}
+
+// IsOpen reports whether the database is open
+func (s *Store) IsOpen() bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db != nil
+}
+
+// Close closes the database. The store can be opened again later.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// update runs fn in a read-write transaction if the database is open
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Update(fn)
+}
+
+// view runs fn in a read-only transaction if the database is open
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.db == nil {
+		return nil
+	}
+	return s.db.View(fn)
+}
+
+// Put stores v as JSON under bucket/key
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// PutAll stores several values in one transaction
+func (s *Store) PutAll(bucket string, values map[string]interface{}) error {
+	if s == nil || len(values) == 0 {
+		return nil
+	}
+
+	encoded := make(map[string][]byte, len(values))
+	for key, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for key, data := range encoded {
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get loads bucket/key into v. It returns false if the key does not exist.
+func (s *Store) Get(bucket, key string, v interface{}) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	var data []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if raw := b.Get([]byte(key)); raw != nil {
+			data = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// ForEach calls fn for every key in bucket, in key order
+func (s *Store) ForEach(bucket string, fn func(key string, data []byte) error) error {
+	if s == nil {
+		return nil
+	}
+
+	return s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// DeleteBefore removes keys in bucket that sort before the given key.
+// Keys are expected to be time-ordered.
+func (s *Store) DeleteBefore(bucket, key string) error {
+	if s == nil {
+		return nil
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		// Collect first: deleting while iterating a cursor skips keys
+		var stale [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < key; k, _ = c.Next() {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// timeKey formats a timestamp as a lexically sortable key
+func timeKey(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000000000Z")
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseWeekday parses a day name such as "mon" or "monday"
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := strings.ToLower(d.String())
+		if name == full || name == full[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// recurringWindow is a weekly recurring period of the day
+type recurringWindow struct {
+	days  [7]bool
+	start time.Duration // Offset from midnight
+	end   time.Duration // Offset from midnight; <= start wraps past midnight
+}
+
+// targetWindow is a parsed TargetWindowConfig
+type targetWindow struct {
+	recurringWindow
+	name       string
+	targetMbps float64
+	tolerance  float64
+}
+
+// contains reports whether the window covers local time t
+func (w *recurringWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if w.end > w.start {
+		return w.days[t.Weekday()] && offset >= w.start && offset < w.end
+	}
+
+	// Overnight window: the day is the day it starts on
+	yesterday := (t.Weekday() + 6) % 7
+	return (w.days[t.Weekday()] && offset >= w.start) || (w.days[yesterday] && offset < w.end)
+}
+
+// EffectiveTarget is the bandwidth target in force at a point in time
+type EffectiveTarget struct {
+	TargetMbps float64 `json:"target_mbps"`
+	Tolerance  float64 `json:"tolerance"`
+	Window     string  `json:"window"` // Window in force, or "default"
+}
+
+// TargetSchedule resolves the bandwidth target for a point in time from
+// weekday/time windows. Changes between windows are spread over the
+// transition period instead of happening as a step.
+type TargetSchedule struct {
+	location   *time.Location
+	transition time.Duration
+	windows    []*targetWindow
+	defaults   EffectiveTarget
+}
+
+// NewTargetSchedule builds the target schedule from bandwidth config
+func NewTargetSchedule(config BandwidthConfig) (*TargetSchedule, error) {
+	schedule := &TargetSchedule{
+		location:   time.Local,
+		transition: config.Schedule.Transition,
+		defaults: EffectiveTarget{
+			TargetMbps: config.TargetGbps * 1000,
+			Tolerance:  config.Tolerance,
+			Window:     "default",
+		},
+	}
+
+	if config.Schedule.Timezone != "" {
+		location, err := time.LoadLocation(config.Schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+		schedule.location = location
+	}
+
+	for i, wc := range config.Schedule.Windows {
+		window, err := parseTargetWindow(wc, config.Tolerance)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i+1, err)
+		}
+		if window.name == "" {
+			window.name = fmt.Sprintf("window-%d", i+1)
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+
+	return schedule, nil
+}
+
+// parseTargetWindow validates and parses a window
+func parseTargetWindow(wc TargetWindowConfig, defaultTolerance float64) (*targetWindow, error) {
+	if wc.TargetGbps <= 0 {
+		return nil, fmt.Errorf("target_gbps must be > 0")
+	}
+	if wc.Tolerance < 0 || wc.Tolerance >= 1 {
+		return nil, fmt.Errorf("tolerance must be between 0 and 1")
+	}
+
+	recurring, err := parseRecurringWindow(wc.Days, wc.Start, wc.End)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &targetWindow{
+		recurringWindow: recurring,
+		name:            wc.Name,
+		targetMbps:      wc.TargetGbps * 1000,
+		tolerance:       wc.Tolerance,
+	}
+	if window.tolerance == 0 {
+		window.tolerance = defaultTolerance
+	}
+
+	return window, nil
+}
+
+// parseRecurringWindow parses days and HH:MM bounds. No days means every
+// day, and empty bounds mean the start and end of the day.
+func parseRecurringWindow(days []string, start, end string) (recurringWindow, error) {
+	window := recurringWindow{end: 24 * time.Hour}
+
+	if len(days) == 0 {
+		for d := range window.days {
+			window.days[d] = true
+		}
+	}
+	for _, day := range days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			return window, fmt.Errorf("unknown day %q", day)
+		}
+		window.days[weekday] = true
+	}
+
+	var err error
+	if start != "" {
+		if window.start, err = parseClock(start); err != nil {
+			return window, err
+		}
+	}
+	if end != "" {
+		if window.end, err = parseClock(end); err != nil {
+			return window, err
+		}
+	}
+	if window.start == window.end {
+		return window, fmt.Errorf("start and end must differ")
+	}
+
+	return window, nil
+}
+
+// parseClock parses a HH:MM time of day, allowing 24:00
+func parseClock(s string) (time.Duration, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// At returns the target in force at t
+func (ts *TargetSchedule) At(t time.Time) EffectiveTarget {
+	current := ts.raw(t)
+	if ts.transition <= 0 || len(ts.windows) == 0 {
+		return current
+	}
+
+	// Average the stepwise schedule over the transition period, which turns
+	// each change of window into a linear ramp
+	const samples = 60
+	step := ts.transition / samples
+
+	var targetSum, toleranceSum float64
+	steady := true
+	for i := 0; i < samples; i++ {
+		sample := ts.raw(t.Add(-time.Duration(i) * step))
+		targetSum += sample.TargetMbps
+		toleranceSum += sample.Tolerance
+		steady = steady && sample == current
+	}
+	if steady {
+		return current
+	}
+
+	current.TargetMbps = targetSum / samples
+	current.Tolerance = toleranceSum / samples
+	return current
+}
+
+// raw returns the target of the window covering t, without smoothing.
+// The first matching window wins.
+func (ts *TargetSchedule) raw(t time.Time) EffectiveTarget {
+	local := t.In(ts.location)
+	for _, window := range ts.windows {
+		if window.contains(local) {
+			return EffectiveTarget{
+				TargetMbps: window.targetMbps,
+				Tolerance:  window.tolerance,
+				Window:     window.name,
+			}
+		}
+	}
+	return ts.defaults
+}
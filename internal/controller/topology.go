@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TopologyStatus reports the active agents per label value against a rule
+type TopologyStatus struct {
+	Rule       TopologyRule   `json:"rule"`
+	Active     map[string]int `json:"active"` // By label value
+	Skew       int            `json:"skew"`
+	Satisfied  bool           `json:"satisfied"`
+	Violations []string       `json:"violations,omitempty"`
+}
+
+// topologyTracker counts the agents picked per label value for each rule.
+// Candidates are the agents that could still be picked; for skew, values
+// whose candidates are all picked no longer hold the others back.
+type topologyTracker struct {
+	rules      []TopologyRule
+	picked     []map[string]int
+	candidates []map[string]int
+}
+
+func newTopologyTracker(rules []TopologyRule, candidates []AgentConfig) *topologyTracker {
+	t := &topologyTracker{
+		rules:      rules,
+		picked:     make([]map[string]int, len(rules)),
+		candidates: make([]map[string]int, len(rules)),
+	}
+	for i, rule := range rules {
+		t.picked[i] = make(map[string]int)
+		t.candidates[i] = make(map[string]int)
+		for _, agent := range candidates {
+			if rule.Matches(agent) {
+				t.candidates[i][agent.Label(rule.Key)]++
+			}
+		}
+	}
+	return t
+}
+
+// add counts an agent as picked
+func (t *topologyTracker) add(agent AgentConfig) {
+	for i, rule := range t.rules {
+		if rule.Matches(agent) {
+			t.picked[i][agent.Label(rule.Key)]++
+		}
+	}
+}
+
+// fits reports whether picking the agent keeps every value within its
+// max_active
+func (t *topologyTracker) fits(agent AgentConfig) bool {
+	for i, rule := range t.rules {
+		if rule.MaxActive > 0 && rule.Matches(agent) && t.picked[i][agent.Label(rule.Key)] >= rule.MaxActive {
+			return false
+		}
+	}
+	return true
+}
+
+// balanced reports whether picking the agent keeps every value within
+// max_skew of the least picked value that still has candidates
+func (t *topologyTracker) balanced(agent AgentConfig) bool {
+	for i, rule := range t.rules {
+		if rule.MaxSkew == 0 || !rule.Matches(agent) {
+			continue
+		}
+
+		least := -1
+		for value, candidates := range t.candidates[i] {
+			picked := t.picked[i][value]
+			if picked < candidates && (least < 0 || picked < least) {
+				least = picked
+			}
+		}
+		if least >= 0 && t.picked[i][agent.Label(rule.Key)]+1-least > rule.MaxSkew {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduledTopology counts the scheduled agents against the topology rules.
+// Must be called with s.mu held.
+func (s *Scheduler) scheduledTopology() *topologyTracker {
+	topology := newTopologyTracker(s.config.Scheduler.Topology.Rules, nil)
+	for agentID := range s.scheduledAllocations() {
+		if agent, ok := s.agentConfig(agentID); ok {
+			topology.add(agent)
+		}
+	}
+	return topology
+}
+
+// GetTopology reports the active agents per label value for every topology
+// rule (for API). Skew is measured across the values of connected agents.
+func (s *Scheduler) GetTopology() []TopologyStatus {
+	s.mu.RLock()
+	active := make([]AgentConfig, 0, len(s.state.ActiveAgents))
+	for agentID := range s.state.ActiveAgents {
+		if agent, ok := s.agentConfig(agentID); ok {
+			active = append(active, agent)
+		}
+	}
+	s.mu.RUnlock()
+
+	connected := make(map[string]bool)
+	for _, agentID := range s.connectedAgents() {
+		connected[agentID] = true
+	}
+
+	statuses := make([]TopologyStatus, 0, len(s.config.Scheduler.Topology.Rules))
+	for _, rule := range s.config.Scheduler.Topology.Rules {
+		status := TopologyStatus{
+			Rule:   rule,
+			Active: make(map[string]int),
+		}
+		for _, agent := range s.config.Agents {
+			if connected[agent.ID] && rule.Matches(agent) {
+				status.Active[agent.Label(rule.Key)] = 0
+			}
+		}
+		for _, agent := range active {
+			if rule.Matches(agent) {
+				status.Active[agent.Label(rule.Key)]++
+			}
+		}
+
+		values := make([]string, 0, len(status.Active))
+		for value := range status.Active {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		least, most := -1, 0
+		for _, value := range values {
+			count := status.Active[value]
+			if least < 0 || count < least {
+				least = count
+			}
+			most = max(most, count)
+			if rule.MaxActive > 0 && count > rule.MaxActive {
+				status.Violations = append(status.Violations, fmt.Sprintf(
+					"%s=%s has %d active agents, at most %d allowed", rule.Key, value, count, rule.MaxActive))
+			}
+		}
+		if least >= 0 {
+			status.Skew = most - least
+		}
+		if rule.MaxSkew > 0 && status.Skew > rule.MaxSkew {
+			status.Violations = append(status.Violations, fmt.Sprintf(
+				"%s is skewed by %d active agents, at most %d allowed", rule.Key, status.Skew, rule.MaxSkew))
+		}
+		status.Satisfied = len(status.Violations) == 0
+
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
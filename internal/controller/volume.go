@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Store key for the volume delivered
+const keyVolume = "volume"
+
+// volumeTarget is a parsed VolumeConfig
+type volumeTarget struct {
+	bytes    int64
+	period   string
+	location *time.Location
+	minMbps  float64
+	maxMbps  float64
+}
+
+// parseVolumeTarget validates and parses the volume target of a bandwidth
+// config
+func parseVolumeTarget(bc BandwidthConfig) (volumeTarget, error) {
+	vc := bc.Volume
+	if vc.TargetTB <= 0 {
+		return volumeTarget{}, fmt.Errorf("target_tb must be positive")
+	}
+	if vc.Period != VolumePeriodDay && vc.Period != VolumePeriodWeek {
+		return volumeTarget{}, fmt.Errorf("period must be %s or %s", VolumePeriodDay, VolumePeriodWeek)
+	}
+	if vc.MinGbps < 0 {
+		return volumeTarget{}, fmt.Errorf("min_gbps must not be negative")
+	}
+
+	target := volumeTarget{
+		bytes:    int64(vc.TargetTB * 1e12),
+		period:   vc.Period,
+		location: time.Local,
+		minMbps:  vc.MinGbps * 1000,
+		maxMbps:  vc.MaxGbps * 1000,
+	}
+	if target.maxMbps == 0 {
+		target.maxMbps = bc.TargetGbps * 1000
+	}
+	if target.maxMbps <= target.minMbps {
+		return volumeTarget{}, fmt.Errorf("max_gbps must be above min_gbps")
+	}
+	if vc.Timezone != "" {
+		location, err := time.LoadLocation(vc.Timezone)
+		if err != nil {
+			return volumeTarget{}, fmt.Errorf("invalid timezone: %w", err)
+		}
+		target.location = location
+	}
+	return target, nil
+}
+
+// bounds returns the start and end of the day or week containing t
+func (v volumeTarget) bounds(t time.Time) (time.Time, time.Time) {
+	local := t.In(v.location)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, v.location)
+	if v.period == VolumePeriodWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// VolumeStatus is the progress of a pool towards its volume target
+type VolumeStatus struct {
+	PeriodStart         time.Time  `json:"period_start"`
+	PeriodEnd           time.Time  `json:"period_end"`
+	TargetBytes         int64      `json:"target_bytes"`
+	DeliveredBytes      int64      `json:"delivered_bytes"`
+	Progress            float64    `json:"progress"`      // Share of the target delivered
+	RequiredMbps        float64    `json:"required_mbps"` // Rate the bytes left need in the time left
+	TargetMbps          float64    `json:"target_mbps"`   // Rate steered to: the required rate within the bounds, paced
+	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+	ProjectedCompletion *time.Time `json:"projected_completion,omitempty"` // At the measured rate; unset while nothing flows
+	OnTrack             bool       `json:"on_track"`                       // Delivered, or projected to be by the period's end
+}
+
+// volumeMeter counts the bytes a pool delivers in the current period and
+// derives the rate still needed. It has its own lock so agent metrics can
+// be counted without s.mu.
+type volumeMeter struct {
+	mu          sync.Mutex
+	target      volumeTarget
+	start       time.Time // Current period
+	end         time.Time
+	delivered   int64
+	completedAt time.Time
+}
+
+// newVolumeMeter creates a meter for the period containing now. The config
+// was validated, so a target that fails to parse is never reached.
+func newVolumeMeter(bc BandwidthConfig, now time.Time) *volumeMeter {
+	target, err := parseVolumeTarget(bc)
+	if err != nil {
+		target = volumeTarget{
+			period:   VolumePeriodDay,
+			location: time.Local,
+			maxMbps:  bc.TargetGbps * 1000,
+		}
+	}
+	m := &volumeMeter{target: target}
+	m.roll(now)
+	return m
+}
+
+// roll starts a new period if the current one has ended
+func (m *volumeMeter) roll(now time.Time) {
+	if now.Before(m.end) {
+		return
+	}
+	m.start, m.end = m.target.bounds(now)
+	m.delivered = 0
+	m.completedAt = time.Time{}
+}
+
+// add counts delivered bytes. It returns true if they complete the target.
+func (m *volumeMeter) add(now time.Time, bytes int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	m.delivered += bytes
+	if m.completedAt.IsZero() && m.delivered >= m.target.bytes {
+		m.completedAt = now
+		return true
+	}
+	return false
+}
+
+// required returns the rate the bytes left need in the time left, in Mbps.
+// Must be called with m.mu held.
+func (m *volumeMeter) required(now time.Time) float64 {
+	left := m.target.bytes - m.delivered
+	if left <= 0 {
+		return 0
+	}
+	seconds := m.end.Sub(now).Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+	return float64(left) * 8 / 1e6 / seconds
+}
+
+// rate returns the target for now: the required rate within the bounds
+func (m *volumeMeter) rate(now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	return m.bounded(m.required(now))
+}
+
+// bounded clamps a rate to the bounds. Must be called with m.mu held.
+func (m *volumeMeter) bounded(mbps float64) float64 {
+	if mbps < m.target.minMbps {
+		return m.target.minMbps
+	}
+	if mbps > m.target.maxMbps {
+		return m.target.maxMbps
+	}
+	return mbps
+}
+
+// status returns the progress of the period, projecting its completion
+// from the measured rate
+func (m *volumeMeter) status(now time.Time, measuredMbps float64) VolumeStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	required := m.required(now)
+	status := VolumeStatus{
+		PeriodStart:    m.start,
+		PeriodEnd:      m.end,
+		TargetBytes:    m.target.bytes,
+		DeliveredBytes: m.delivered,
+		RequiredMbps:   required,
+		TargetMbps:     m.bounded(required),
+	}
+	if m.target.bytes > 0 {
+		status.Progress = float64(m.delivered) / float64(m.target.bytes)
+	}
+
+	if !m.completedAt.IsZero() {
+		completedAt := m.completedAt
+		status.CompletedAt = &completedAt
+		status.OnTrack = true
+	} else if measuredMbps > 0 {
+		left := float64(m.target.bytes - m.delivered)
+		projected := now.Add(time.Duration(left * 8 / 1e6 / measuredMbps * float64(time.Second)))
+		status.ProjectedCompletion = &projected
+		status.OnTrack = !projected.After(m.end)
+	}
+	return status
+}
+
+// volumeSnapshot is the persisted volume delivered
+type volumeSnapshot struct {
+	PeriodStart time.Time `json:"period_start"`
+	Delivered   int64     `json:"delivered"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// countVolume counts bytes an agent delivered towards the volume target
+func (s *Scheduler) countVolume(bytes int64) {
+	if s.volume == nil || bytes <= 0 {
+		return
+	}
+	if s.volume.add(time.Now(), bytes) {
+		s.logger.Infow("Volume target delivered", "bytes", s.volume.target.bytes)
+	}
+}
+
+// GetVolume returns the pool's progress towards its volume target, and
+// false if the pool is not in volume target mode (for API)
+func (s *Scheduler) GetVolume() (VolumeStatus, bool) {
+	if s.volume == nil {
+		return VolumeStatus{}, false
+	}
+	status := s.volume.status(time.Now(), s.aggregated().TotalBandwidth)
+	status.TargetMbps = s.LiveTarget().TargetMbps
+	return status, true
+}
+
+// saveVolume persists the volume delivered
+func (s *Scheduler) saveVolume() {
+	if s.store == nil || s.volume == nil {
+		return
+	}
+
+	m := s.volume
+	m.mu.Lock()
+	snapshot := volumeSnapshot{
+		PeriodStart: m.start,
+		Delivered:   m.delivered,
+		CompletedAt: m.completedAt,
+	}
+	m.mu.Unlock()
+
+	if err := s.store.Put(bucketScheduler, s.storeKey(keyVolume), snapshot); err != nil {
+		s.logger.Warnw("Failed to persist volume delivered", "error", err)
+	}
+}
+
+// restoreVolume loads the volume delivered before a restart or under a
+// previous leader, unless more has been counted since. Volume from a
+// period that has since ended is dropped.
+func (s *Scheduler) restoreVolume() {
+	if s.store == nil || s.volume == nil {
+		return
+	}
+
+	var snapshot volumeSnapshot
+	found, err := s.store.Get(bucketScheduler, s.storeKey(keyVolume), &snapshot)
+	if err != nil {
+		s.logger.Warnw("Failed to restore volume delivered", "error", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	m := s.volume
+	m.mu.Lock()
+	m.roll(time.Now())
+	restored := m.start.Equal(snapshot.PeriodStart) && snapshot.Delivered > m.delivered
+	if restored {
+		m.delivered = snapshot.Delivered
+		m.completedAt = snapshot.CompletedAt
+	}
+	m.mu.Unlock()
+
+	if restored {
+		s.logger.Infow("Restored volume delivered", "bytes", snapshot.Delivered)
+	}
+}
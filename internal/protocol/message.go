@@ -10,17 +10,23 @@ type MessageType string
 
 const (
 	// Controller -> Agent messages
-	MsgTypeDownloadCommand MessageType = "download_command"
-	MsgTypeStopCommand     MessageType = "stop_command"
-	MsgTypeHealthCheck     MessageType = "health_check"
-	MsgTypeShutdown        MessageType = "shutdown"
+	MsgTypeDownloadCommand  MessageType = "download_command"
+	MsgTypeStopCommand      MessageType = "stop_command"
+	MsgTypeHealthCheck      MessageType = "health_check"
+	MsgTypeShutdown         MessageType = "shutdown"
+	MsgTypeStatusRequest    MessageType = "status_request"
+	MsgTypeAdjustCommand    MessageType = "adjust_command"
+	MsgTypeCalibrateCommand MessageType = "calibrate_command"
+	MsgTypeRegisterAck      MessageType = "register_ack"
 
 	// Agent -> Controller messages
-	MsgTypeRegister       MessageType = "register"
-	MsgTypeMetrics        MessageType = "metrics"
-	MsgTypeHealthResponse MessageType = "health_response"
-	MsgTypeStatus         MessageType = "status"
-	MsgTypeError          MessageType = "error"
+	MsgTypeRegister          MessageType = "register"
+	MsgTypeMetrics           MessageType = "metrics"
+	MsgTypeHealthResponse    MessageType = "health_response"
+	MsgTypeStatus            MessageType = "status"
+	MsgTypeError             MessageType = "error"
+	MsgTypeCommandAck        MessageType = "command_ack"
+	MsgTypeCalibrationResult MessageType = "calibration_result"
 )
 
 // DownloadType defines the type of download tool to use
@@ -74,6 +80,35 @@ type DownloadCommand struct {
 // StopCommand instructs an agent to stop downloading
 type StopCommand struct {
 	CommandID string `json:"command_id,omitempty"` // Empty = stop all
+	RequestID string `json:"request_id,omitempty"` // If set, the agent acknowledges once stopped
+	Emergency bool   `json:"emergency,omitempty"`  // Also abort calibrations
+}
+
+// AdjustCommand changes the bandwidth limit of a running download
+type AdjustCommand struct {
+	CommandID string `json:"command_id"`
+	Bandwidth int64  `json:"bandwidth"` // Mbps
+}
+
+// CalibrateCommand asks an idle agent to measure its download capacity
+type CalibrateCommand struct {
+	CalibrationID string   `json:"calibration_id"`
+	URLs          []string `json:"urls"`     // Spread across the streams
+	Streams       int      `json:"streams"`  // Parallel unthrottled downloads
+	Duration      string   `json:"duration"` // Total probe time, e.g. "20s"
+	Warmup        string   `json:"warmup"`   // Start of the probe left out of the result
+}
+
+// RegisterAck answers a registration. While the controller's emergency stop
+// is engaged it tells the reconnecting agent to stop everything; the flag is
+// signed so only a controller holding the auth token can set it, and bound
+// to the registration's nonce so it cannot be replayed on a later connection.
+type RegisterAck struct {
+	EmergencyStop bool      `json:"emergency_stop"`
+	RequestID     string    `json:"request_id,omitempty"` // If set, the agent acknowledges once stopped
+	Nonce         string    `json:"nonce"`                // From the registration answered
+	IssuedAt      time.Time `json:"issued_at"`
+	Signature     string    `json:"signature"` // See Sign
 }
 
 // HealthCheck is a ping message to check agent health
@@ -89,7 +124,19 @@ type RegisterPayload struct {
 	Name         string            `json:"name"`
 	Version      string            `json:"version"`
 	Capabilities map[string]bool   `json:"capabilities"`
-	MaxBandwidth int64             `json:"max_bandwidth"` // Mbps
+	MaxBandwidth int64             `json:"max_bandwidth"` // Mbps, from the last calibration; 0 = not calibrated
+	CalibratedAt time.Time         `json:"calibrated_at,omitempty"`
+	ActiveJobs   []ActiveJob       `json:"active_jobs,omitempty"` // Jobs still running from before a reconnect
+	Nonce        string            `json:"nonce,omitempty"`       // Fresh per connection, echoed in the register ack
+}
+
+// ActiveJob describes a download job an agent is running
+type ActiveJob struct {
+	CommandID         string       `json:"command_id"`
+	URL               string       `json:"url"`
+	Type              DownloadType `json:"type"`
+	Bandwidth         int64        `json:"bandwidth"`                    // Mbps
+	RemainingDuration string       `json:"remaining_duration,omitempty"` // Empty = no planned end
 }
 
 // MetricsPayload contains bandwidth metrics from an agent
@@ -116,6 +163,7 @@ type StatusPayload struct {
 	ActiveCommands []string `json:"active_commands"`
 	UptimeSeconds  int64    `json:"uptime_seconds"`
 	LastError      string   `json:"last_error,omitempty"`
+	Jobs           []ActiveJob `json:"jobs,omitempty"`
 }
 
 // HealthResponse is the response to a health check
@@ -125,6 +173,25 @@ type HealthResponse struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// CommandAck acknowledges that a command requesting acknowledgement has completed
+type CommandAck struct {
+	RequestID string `json:"request_id"`
+	CommandID string `json:"command_id,omitempty"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+}
+
+// CalibrationResult reports the outcome of a capacity probe
+type CalibrationResult struct {
+	CalibrationID string  `json:"calibration_id"`
+	Success       bool    `json:"success"`
+	SustainedMbps float64 `json:"sustained_mbps"` // Median throughput after the warm-up
+	PeakMbps      float64 `json:"peak_mbps"`
+	Samples       int     `json:"samples"`
+	Streams       int     `json:"streams"`
+	Message       string  `json:"message,omitempty"`
+}
+
 // ErrorPayload contains error information from an agent
 type ErrorPayload struct {
 	Code    string `json:"code"`
@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Sign signs the acknowledgement for an agent with an HMAC-SHA256 keyed by
+// the shared auth token. The agent ID and the registration's nonce are
+// covered so an acknowledgement cannot be replayed to another agent or on
+// another connection.
+func (a *RegisterAck) Sign(agentID, key string) {
+	a.Signature = a.mac(agentID, key)
+}
+
+// Verify reports whether the acknowledgement was signed for the agent with key
+func (a *RegisterAck) Verify(agentID, key string) bool {
+	expected := a.mac(agentID, key)
+	return hmac.Equal([]byte(a.Signature), []byte(expected))
+}
+
+// mac computes the signature of the acknowledgement
+func (a *RegisterAck) mac(agentID, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(agentID))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatBool(a.EmergencyStop)))
+	h.Write([]byte{0})
+	h.Write([]byte(a.RequestID))
+	h.Write([]byte{0})
+	h.Write([]byte(a.Nonce))
+	h.Write([]byte{0})
+	h.Write([]byte(a.IssuedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(h.Sum(nil))
+}
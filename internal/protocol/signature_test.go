@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegisterAckSignature(t *testing.T) {
+	issuedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	signed := func() *RegisterAck {
+		ack := &RegisterAck{EmergencyStop: true, RequestID: "req-1", Nonce: "nonce-1", IssuedAt: issuedAt}
+		ack.Sign("agent-001", "secret")
+		return ack
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(ack *RegisterAck)
+		agentID string
+		key     string
+		want    bool
+	}{
+		{name: "valid", agentID: "agent-001", key: "secret", want: true},
+		{name: "wrong key", agentID: "agent-001", key: "other", want: false},
+		{name: "other agent", agentID: "agent-002", key: "secret", want: false},
+		{name: "stop flag changed", tamper: func(a *RegisterAck) { a.EmergencyStop = false }, agentID: "agent-001", key: "secret", want: false},
+		{name: "request changed", tamper: func(a *RegisterAck) { a.RequestID = "req-2" }, agentID: "agent-001", key: "secret", want: false},
+		{name: "nonce changed", tamper: func(a *RegisterAck) { a.Nonce = "nonce-2" }, agentID: "agent-001", key: "secret", want: false},
+		{name: "time changed", tamper: func(a *RegisterAck) { a.IssuedAt = issuedAt.Add(time.Second) }, agentID: "agent-001", key: "secret", want: false},
+		{name: "time zone ignored", tamper: func(a *RegisterAck) { a.IssuedAt = issuedAt.In(time.FixedZone("JST", 9*3600)) }, agentID: "agent-001", key: "secret", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := signed()
+			if tt.tamper != nil {
+				tt.tamper(ack)
+			}
+			if got := ack.Verify(tt.agentID, tt.key); got != tt.want {
+				t.Fatalf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ echo "=== Deploying Bandwidth Controller ==="
 INSTALL_DIR="/opt/bandwidth-controller"
 CONFIG_DIR="/etc/bandwidth-controller"
 LOG_DIR="/var/log/controller"
+DATA_DIR="/var/lib/bandwidth-controller"
 USER="controller"
 
 # Check if running as root
@@ -29,7 +30,8 @@ echo "Creating directories..."
 mkdir -p $INSTALL_DIR/bin
 mkdir -p $CONFIG_DIR
 mkdir -p $LOG_DIR
-chown $USER:$USER $LOG_DIR
+mkdir -p $DATA_DIR
+chown $USER:$USER $LOG_DIR $DATA_DIR
 
 # Build controller binary
 echo "Building controller binary..."
@@ -21,6 +21,7 @@ VERSION="v1.1.0"
 INSTALL_DIR="/opt/bandwidth-controller"
 CONFIG_DIR="/etc/bandwidth-controller"
 LOG_DIR="/var/log/controller"
+DATA_DIR="/var/lib/bandwidth-controller"
 USER="controller"
 
 echo "📦 开始部署..."
@@ -83,7 +84,8 @@ echo "📁 创建目录..."
 mkdir -p $INSTALL_DIR/bin
 mkdir -p $CONFIG_DIR
 mkdir -p $LOG_DIR
-chown $USER:$USER $LOG_DIR
+mkdir -p $DATA_DIR
+chown $USER:$USER $LOG_DIR $DATA_DIR
 
 # 安装二进制文件
 echo "📋 安装程序..."
@@ -138,7 +140,7 @@ NoNewPrivileges=true
 PrivateTmp=true
 ProtectSystem=strict
 ProtectHome=true
-ReadWritePaths=$LOG_DIR /tmp
+ReadWritePaths=$LOG_DIR $DATA_DIR /tmp
 
 # Resource limits
 LimitNOFILE=65536
@@ -6,6 +6,7 @@ class Dashboard {
         this.chart = null;
         this.historyData = [];
         this.maxHistoryPoints = 60;
+        this.pool = '';
 
         this.init();
     }
@@ -13,6 +14,7 @@ class Dashboard {
     async init() {
         this.initChart();
         document.getElementById('api-host').textContent = window.location.host;
+        await this.initPools();
         await this.fetchAndUpdate();
         setInterval(() => this.fetchAndUpdate(), this.refreshInterval);
     }
@@ -73,6 +75,28 @@ class Dashboard {
         });
     }
 
+    async initPools() {
+        try {
+            const { pools } = await this.fetchJSON('/pools');
+            if (!pools || pools.length === 0) return;
+
+            const select = document.getElementById('pool-select');
+            select.innerHTML = pools.map(pool =>
+                `<option value="${pool.name}">${pool.name}</option>`
+            ).join('');
+            select.classList.toggle('hidden', pools.length < 2);
+            this.pool = pools[0].name;
+
+            select.addEventListener('change', () => {
+                this.pool = select.value;
+                this.historyData = [];
+                this.fetchAndUpdate();
+            });
+        } catch (error) {
+            console.error('Failed to fetch pools:', error);
+        }
+    }
+
     async fetchAndUpdate() {
         try {
             const [metrics, status, agents] = await Promise.all([
@@ -94,7 +118,8 @@ class Dashboard {
     }
 
     async fetchJSON(endpoint) {
-        const response = await fetch(this.apiBase + endpoint);
+        const query = this.pool ? `?pool=${encodeURIComponent(this.pool)}` : '';
+        const response = await fetch(this.apiBase + endpoint + query);
         if (!response.ok) throw new Error(`HTTP ${response.status}`);
         return response.json();
     }
@@ -188,10 +213,13 @@ class Dashboard {
 
             const name = agent.name || agent.id;
             const region = agent.region ? `<span class="text-gray-600 text-xs ml-1">(${agent.region})</span>` : '';
+            const mode = agent.mode && !agent.mode.schedulable
+                ? `<span class="text-yellow-500 text-xs ml-1" title="${agent.mode.maintenance || ''}">${agent.mode.draining ? 'draining' : agent.mode.maintenance ? 'maintenance' : agent.mode.mode}</span>`
+                : '';
 
             return `
                 <div class="flex items-center gap-3 py-2 px-3 bg-gray-700/30 rounded text-sm">
-                    <div class="w-32 truncate font-medium">${name}${region}</div>
+                    <div class="w-32 truncate font-medium">${name}${region}${mode}</div>
                     <div class="flex-1">
                         <div class="bg-gray-700 rounded-full h-2 overflow-hidden">
                             <div class="h-full bg-blue-500 transition-all duration-300" style="width: ${percentage}%"></div>